@@ -0,0 +1,408 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+const (
+	// PreRelabel is the view of the series before relabeling
+	PreRelabel = "pre-relabel"
+	// PostRelabel is the view of the series after relabeling
+	PostRelabel = "post-relabel"
+
+	// labelValueSeparator separates the label name and value of the keys of
+	// label value sketches
+	labelValueSeparator = "\xff"
+)
+
+var (
+	estimatedSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_estimated_series",
+			Help: "Estimated number of series within the analytics window.",
+		},
+		[]string{"view"},
+	)
+	estimatedMetricSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_estimated_metric_series",
+			Help: "Estimated number of series within the analytics window of the metric names with the most series.",
+		},
+		[]string{"view", "metric"},
+	)
+	estimatedLabelSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_estimated_label_series",
+			Help: "Estimated number of series within the analytics window of the label names with the most series.",
+		},
+		[]string{"view", "label"},
+	)
+	sampleRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_samples_per_second",
+			Help: "Samples per second within the analytics window.",
+		},
+		[]string{"view"},
+	)
+)
+
+func RegisterPrometheusMetrics() {
+	prometheus.MustRegister(estimatedSeries)
+	prometheus.MustRegister(estimatedMetricSeries)
+	prometheus.MustRegister(estimatedLabelSeries)
+	prometheus.MustRegister(sampleRate)
+}
+
+// Analytics holds the views of the series before and after relabeling, so
+// the series saved by relabeling can be seen
+type Analytics struct {
+	cfg   config.Analytics
+	views map[string]*View
+}
+
+// NewAnalytics returns the pre and post relabel views
+func NewAnalytics(cfg config.Analytics) *Analytics {
+	return &Analytics{
+		cfg: cfg,
+		views: map[string]*View{
+			PreRelabel:  newView(PreRelabel, cfg),
+			PostRelabel: newView(PostRelabel, cfg),
+		},
+	}
+}
+
+// Enabled tells if series are observed
+func (a *Analytics) Enabled() bool {
+	return a.cfg.Enabled
+}
+
+// View returns the view of the name, nil if there is none
+func (a *Analytics) View(name string) *View {
+	return a.views[name]
+}
+
+// Report returns the reports of the views, or of the named view only, with
+// the n keys with the most series. n defaults to the configured top-n.
+func (a *Analytics) Report(name string, n int) ([]*Report, error) {
+	if n == 0 {
+		n = a.cfg.TopN
+	}
+	if name == "" {
+		return []*Report{a.views[PreRelabel].report(n), a.views[PostRelabel].report(n)}, nil
+	}
+
+	view, ok := a.views[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown view %q", name)
+	}
+	return []*Report{view.report(n)}, nil
+}
+
+// Report is the estimated number of series within the window of a view.
+// Untracked counts the metric names, label names and label values not added
+// to a sketch, as their bucket tracked max-keys keys of their kind already.
+type Report struct {
+	View             string     `json:"view"`
+	Window           string     `json:"window"`
+	Series           int64      `json:"series"`
+	SamplesPerSecond float64    `json:"samples_per_second"`
+	Untracked        int        `json:"untracked"`
+	Metrics          []Estimate `json:"metrics"`
+	LabelNames       []Estimate `json:"label_names"`
+	LabelValues      []Estimate `json:"label_values"`
+}
+
+// Estimate is the estimated number of series of a metric name, label name
+// or label value
+type Estimate struct {
+	Name             string  `json:"name"`
+	Value            string  `json:"value,omitempty"`
+	Series           int64   `json:"series"`
+	SamplesPerSecond float64 `json:"samples_per_second,omitempty"`
+}
+
+// Series is a series after relabeling
+type Series struct {
+	Name        string
+	Tags        map[string]string
+	Fingerprint uint64
+}
+
+// View estimates the number of series over a sliding window, split into
+// buckets. Sketches of the buckets within the window are merged for reports.
+type View struct {
+	mtx     sync.Mutex
+	name    string
+	cfg     config.Analytics
+	width   time.Duration
+	buckets []*bucket
+	now     func() time.Time
+
+	// gaugedMetrics and gaugedLabels have gauges set, to be deleted once
+	// they are no longer in the top n
+	gaugedMetrics []string
+	gaugedLabels  []string
+}
+
+type bucket struct {
+	start         time.Time
+	samples       int
+	series        *sketch
+	metrics       map[string]*sketch
+	metricSamples map[string]int
+	labels        map[string]*sketch
+	values        map[string]*sketch
+	untracked     int
+}
+
+func newView(name string, cfg config.Analytics) *View {
+	v := &View{
+		name: name,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+	if cfg.Buckets > 0 {
+		v.width = cfg.Window / time.Duration(cfg.Buckets)
+	}
+	return v
+}
+
+// ObserveMetrics adds a sample of each of the metrics
+func (v *View) ObserveMetrics(metrics []model.Metric) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	b := v.current()
+	for _, metric := range metrics {
+		series := b.observe(string(metric[model.MetricNameLabel]), uint64(metric.Fingerprint()), v.cfg)
+		for name, value := range metric {
+			if name != model.MetricNameLabel && value != "" {
+				series.label(string(name), string(value))
+			}
+		}
+	}
+}
+
+// ObserveSeries adds a sample of each of the series
+func (v *View) ObserveSeries(series []Series) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	b := v.current()
+	for _, s := range series {
+		observed := b.observe(s.Name, s.Fingerprint, v.cfg)
+		for name, value := range s.Tags {
+			observed.label(name, value)
+		}
+	}
+}
+
+// observation adds the labels of an observed series to the sketches
+type observation struct {
+	b   *bucket
+	fp  uint64
+	cfg config.Analytics
+}
+
+func (b *bucket) observe(name string, fp uint64, cfg config.Analytics) observation {
+	b.samples++
+	b.series.insert(fp)
+	if s := b.sketch(b.metrics, name, cfg); s != nil {
+		s.insert(fp)
+		b.metricSamples[name]++
+	}
+	return observation{b: b, fp: fp, cfg: cfg}
+}
+
+func (o observation) label(name, value string) {
+	if s := o.b.sketch(o.b.labels, name, o.cfg); s != nil {
+		s.insert(o.fp)
+	}
+	if s := o.b.sketch(o.b.values, name+labelValueSeparator+value, o.cfg); s != nil {
+		s.insert(o.fp)
+	}
+}
+
+// sketch returns the sketch of the key, nil if the bucket tracks too many
+// keys of its kind already. Every kind has its own limit, so label values
+// can't crowd out metric names.
+func (b *bucket) sketch(sketches map[string]*sketch, key string, cfg config.Analytics) *sketch {
+	if s, ok := sketches[key]; ok {
+		return s
+	}
+	if len(sketches) >= cfg.MaxKeys {
+		b.untracked++
+		return nil
+	}
+	s := newSketch(cfg.Precision)
+	sketches[key] = s
+	return s
+}
+
+// current returns the bucket of now, starting a new one and dropping the
+// buckets out of the window when the current one is full
+func (v *View) current() *bucket {
+	now := v.now()
+	if n := len(v.buckets); n > 0 && now.Sub(v.buckets[n-1].start) < v.width {
+		return v.buckets[n-1]
+	}
+
+	if len(v.buckets) > 0 {
+		v.updateGauges(now)
+	}
+	v.buckets = append(v.buckets, &bucket{
+		start:         now,
+		series:        newSketch(v.cfg.Precision),
+		metrics:       make(map[string]*sketch),
+		metricSamples: make(map[string]int),
+		labels:        make(map[string]*sketch),
+		values:        make(map[string]*sketch),
+	})
+	v.expire(now)
+	return v.buckets[len(v.buckets)-1]
+}
+
+func (v *View) expire(now time.Time) {
+	i := 0
+	for i < len(v.buckets) && now.Sub(v.buckets[i].start) >= v.cfg.Window {
+		i++
+	}
+	v.buckets = v.buckets[i:]
+}
+
+// updateGauges sets the gauges to the estimates of the buckets, once per bucket
+func (v *View) updateGauges(now time.Time) {
+	v.expire(now)
+	series, rate := v.totals(now)
+	estimatedSeries.WithLabelValues(v.name).Set(float64(series))
+	sampleRate.WithLabelValues(v.name).Set(rate)
+
+	for _, metric := range v.gaugedMetrics {
+		estimatedMetricSeries.DeleteLabelValues(v.name, metric)
+	}
+	v.gaugedMetrics = v.gaugedMetrics[:0]
+	for _, e := range v.top(metricSketches, metricSamples, v.cfg.TopN, now) {
+		estimatedMetricSeries.WithLabelValues(v.name, e.Name).Set(float64(e.Series))
+		v.gaugedMetrics = append(v.gaugedMetrics, e.Name)
+	}
+
+	for _, label := range v.gaugedLabels {
+		estimatedLabelSeries.DeleteLabelValues(v.name, label)
+	}
+	v.gaugedLabels = v.gaugedLabels[:0]
+	for _, e := range v.top(labelSketches, nil, v.cfg.TopN, now) {
+		estimatedLabelSeries.WithLabelValues(v.name, e.Name).Set(float64(e.Series))
+		v.gaugedLabels = append(v.gaugedLabels, e.Name)
+	}
+}
+
+func (v *View) report(n int) *Report {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	now := v.now()
+	v.expire(now)
+	series, rate := v.totals(now)
+	r := &Report{
+		View:             v.name,
+		Window:           v.covered(now).String(),
+		Series:           series,
+		SamplesPerSecond: rate,
+		Metrics:          v.top(metricSketches, metricSamples, n, now),
+		LabelNames:       v.top(labelSketches, nil, n, now),
+		LabelValues:      v.top(valueSketches, nil, n, now),
+	}
+	for _, b := range v.buckets {
+		r.Untracked += b.untracked
+	}
+	for i := range r.LabelValues {
+		parts := strings.SplitN(r.LabelValues[i].Name, labelValueSeparator, 2)
+		r.LabelValues[i].Name, r.LabelValues[i].Value = parts[0], parts[1]
+	}
+	return r
+}
+
+// covered is the duration of the window covered by the buckets
+func (v *View) covered(now time.Time) time.Duration {
+	if len(v.buckets) == 0 {
+		return 0
+	}
+	covered := now.Sub(v.buckets[0].start)
+	if covered < time.Second {
+		covered = time.Second
+	}
+	return covered
+}
+
+func (v *View) totals(now time.Time) (int64, float64) {
+	if len(v.buckets) == 0 {
+		return 0, 0
+	}
+
+	registers := make([]uint8, 1<<v.cfg.Precision)
+	var samples int
+	for _, b := range v.buckets {
+		b.series.merge(registers)
+		samples += b.samples
+	}
+	return round(estimate(registers)), float64(samples) / v.covered(now).Seconds()
+}
+
+func metricSketches(b *bucket) map[string]*sketch { return b.metrics }
+func labelSketches(b *bucket) map[string]*sketch  { return b.labels }
+func valueSketches(b *bucket) map[string]*sketch  { return b.values }
+func metricSamples(b *bucket) map[string]int      { return b.metricSamples }
+
+// top returns the n keys of the sketches with the most series, with their
+// sample rate if the buckets count samples per key
+func (v *View) top(sketches func(*bucket) map[string]*sketch, counts func(*bucket) map[string]int, n int, now time.Time) []Estimate {
+	merged := make(map[string][]uint8)
+	samples := make(map[string]int)
+	for _, b := range v.buckets {
+		for key, s := range sketches(b) {
+			registers, ok := merged[key]
+			if !ok {
+				registers = make([]uint8, 1<<v.cfg.Precision)
+				merged[key] = registers
+			}
+			s.merge(registers)
+			if counts != nil {
+				samples[key] += counts(b)[key]
+			}
+		}
+	}
+
+	estimates := make([]Estimate, 0, len(merged))
+	for key, registers := range merged {
+		estimates = append(estimates, Estimate{Name: key, Series: round(estimate(registers))})
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		if estimates[i].Series != estimates[j].Series {
+			return estimates[i].Series > estimates[j].Series
+		}
+		return estimates[i].Name < estimates[j].Name
+	})
+	if len(estimates) > n {
+		estimates = estimates[:n]
+	}
+
+	for i := range estimates {
+		if count := samples[estimates[i].Name]; count > 0 {
+			estimates[i].SamplesPerSecond = float64(count) / v.covered(now).Seconds()
+		}
+	}
+	return estimates
+}
+
+func round(x float64) int64 {
+	return int64(math.Floor(x + .5))
+}
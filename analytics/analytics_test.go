@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func testConfig() config.Analytics {
+	return config.Analytics{Enabled: true, Window: 10 * time.Minute, Buckets: 5, Precision: 10, MaxKeys: 100, TopN: 10}
+}
+
+func TestViewReport(t *testing.T) {
+	now := time.Unix(1000, 0)
+	v := newView(PreRelabel, testConfig())
+	v.now = func() time.Time { return now }
+
+	var metrics []model.Metric
+	for _, pod := range []model.LabelValue{"a", "b", "c"} {
+		metrics = append(metrics,
+			model.Metric{model.MetricNameLabel: "http_requests_total", "job": "api", "pod": pod},
+			model.Metric{model.MetricNameLabel: "up", "job": "api", "pod": pod},
+		)
+	}
+	metrics = append(metrics, model.Metric{model.MetricNameLabel: "up", "job": "db"})
+	v.ObserveMetrics(metrics)
+	now = now.Add(10 * time.Second)
+	v.ObserveMetrics(metrics[:2])
+
+	r := v.report(2)
+	assert.Equal(t, PreRelabel, r.View)
+	assert.Equal(t, "10s", r.Window)
+	assert.Equal(t, int64(7), r.Series)
+	assert.Equal(t, 0.9, r.SamplesPerSecond)
+	assert.Equal(t, 0, r.Untracked)
+	assert.Equal(t, []Estimate{
+		{Name: "up", Series: 4, SamplesPerSecond: 0.5},
+		{Name: "http_requests_total", Series: 3, SamplesPerSecond: 0.4},
+	}, r.Metrics)
+	assert.Equal(t, []Estimate{{Name: "job", Series: 7}, {Name: "pod", Series: 6}}, r.LabelNames)
+	assert.Equal(t, []Estimate{{Name: "job", Value: "api", Series: 6}, {Name: "pod", Value: "a", Series: 2}}, r.LabelValues)
+}
+
+func TestViewSlidingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	v := newView(PostRelabel, testConfig())
+	v.now = func() time.Time { return now }
+
+	v.ObserveSeries([]Series{{Name: "up", Tags: map[string]string{"pod": "a"}, Fingerprint: 1}})
+	now = now.Add(5 * time.Minute)
+	v.ObserveSeries([]Series{{Name: "up", Tags: map[string]string{"pod": "b"}, Fingerprint: 2}})
+	assert.Equal(t, int64(2), v.report(10).Series)
+
+	// the first bucket leaves the window
+	now = now.Add(6 * time.Minute)
+	r := v.report(10)
+	assert.Equal(t, int64(1), r.Series)
+	assert.Equal(t, []Estimate{{Name: "pod", Value: "b", Series: 1}}, r.LabelValues)
+}
+
+func TestViewMaxKeys(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxKeys = 2
+	v := newView(PreRelabel, cfg)
+
+	// the label values over the limit don't crowd out the metric names
+	v.ObserveMetrics([]model.Metric{
+		{model.MetricNameLabel: "up", "pod": "a"},
+		{model.MetricNameLabel: "up", "pod": "b"},
+		{model.MetricNameLabel: "up", "pod": "c"},
+		{model.MetricNameLabel: "down", "pod": "a"},
+	})
+	r := v.report(10)
+	assert.Equal(t, int64(4), r.Series)
+	assert.Equal(t, 1, r.Untracked)
+	assert.Len(t, r.Metrics, 2)
+	assert.Len(t, r.LabelNames, 1)
+	assert.Len(t, r.LabelValues, 2)
+}
+
+func TestAnalyticsReport(t *testing.T) {
+	a := NewAnalytics(testConfig())
+
+	reports, err := a.Report("", 0)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 2)
+
+	reports, err = a.Report(PostRelabel, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, PostRelabel, reports[0].View)
+
+	_, err = a.Report("unknown", 0)
+	assert.EqualError(t, err, `unknown view "unknown"`)
+}
@@ -0,0 +1,112 @@
+package analytics
+
+import (
+	"math"
+	"math/bits"
+)
+
+// sketch is a HyperLogLog sketch of series fingerprints. Sketches of few
+// series keep their registers in a map and switch to a dense array once they
+// would not be smaller than it.
+type sketch struct {
+	precision uint8
+	sparse    map[uint16]uint8
+	dense     []uint8
+}
+
+func newSketch(precision uint8) *sketch {
+	return &sketch{precision: precision, sparse: make(map[uint16]uint8)}
+}
+
+// insert adds the fingerprint of a series
+func (s *sketch) insert(fp uint64) {
+	x := mix(fp)
+	index := uint16(x >> (64 - s.precision))
+	// the guard bit bounds the rank if the remaining bits are zero
+	rank := uint8(bits.LeadingZeros64(x<<s.precision|1<<(s.precision-1))) + 1
+
+	if s.dense != nil {
+		if rank > s.dense[index] {
+			s.dense[index] = rank
+		}
+		return
+	}
+
+	if rank > s.sparse[index] {
+		s.sparse[index] = rank
+	}
+	// a map entry takes about 16 bytes, a dense register 1
+	if len(s.sparse) > s.registers()/16 {
+		s.dense = make([]uint8, s.registers())
+		for index, rank := range s.sparse {
+			s.dense[index] = rank
+		}
+		s.sparse = nil
+	}
+}
+
+// merge adds the registers of the sketch to the dense registers
+func (s *sketch) merge(registers []uint8) {
+	if s.dense != nil {
+		for index, rank := range s.dense {
+			if rank > registers[index] {
+				registers[index] = rank
+			}
+		}
+		return
+	}
+	for index, rank := range s.sparse {
+		if rank > registers[index] {
+			registers[index] = rank
+		}
+	}
+}
+
+func (s *sketch) registers() int {
+	return 1 << s.precision
+}
+
+// estimate returns the estimated number of distinct series of the merged
+// registers
+func estimate(registers []uint8) float64 {
+	m := float64(len(registers))
+	var sum float64
+	var zeros int
+	for _, rank := range registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	e := alpha(len(registers)) * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if e <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// mix spreads the bits of FNV based fingerprints, which differ little for
+// similar series, over the whole hash
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
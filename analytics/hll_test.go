@@ -0,0 +1,48 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSketchEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 100000} {
+		s := newSketch(12)
+		for i := 0; i < n; i++ {
+			s.insert(uint64(i))
+			// duplicates don't count
+			s.insert(uint64(i))
+		}
+
+		registers := make([]uint8, s.registers())
+		s.merge(registers)
+		assert.InDelta(t, n, estimate(registers), math.Max(1, 0.05*float64(n)), "%d series", n)
+	}
+}
+
+func TestSketchDense(t *testing.T) {
+	s := newSketch(8)
+	for i := 0; i < 16; i++ {
+		s.insert(uint64(i))
+	}
+	assert.Nil(t, s.dense)
+
+	s.insert(16)
+	assert.NotNil(t, s.dense)
+	assert.Nil(t, s.sparse)
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b := newSketch(10), newSketch(10)
+	for i := 0; i < 500; i++ {
+		a.insert(uint64(i))
+		b.insert(uint64(i + 250))
+	}
+
+	registers := make([]uint8, a.registers())
+	a.merge(registers)
+	b.merge(registers)
+	assert.InDelta(t, 750, estimate(registers), 0.05*750)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/spf13/cobra"
+)
+
+var failOnWarnings bool
+
+// checkConfigCmd checks config files for errors and suspicious metric relabel
+// configs, to gate config changes before they are deployed
+var checkConfigCmd = &cobra.Command{
+	Use:   "check-config [config files]",
+	Short: "check config files strictly, reporting every error and warning",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		// defaults set while checking are logged at info level
+		logrus.SetLevel(logrus.WarnLevel)
+		if len(args) == 0 {
+			args = []string{cfgFile}
+		}
+		if !checkConfig(args, failOnWarnings, os.Stdout) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	checkConfigCmd.Flags().BoolVar(&failOnWarnings, "fail-on-warnings", false, "if set to true, warnings fail the check like errors")
+	RootCmd.AddCommand(checkConfigCmd)
+}
+
+// checkConfig prints the problems of the files and tells if none of them has
+// errors, or warnings if they fail the check
+func checkConfig(files []string, failOnWarnings bool, out io.Writer) bool {
+	passed := true
+	for _, file := range files {
+		fmt.Fprintf(out, "Checking %s\n", file)
+
+		var errors, warnings int
+		for _, problem := range config.CheckCfgFile(file) {
+			fmt.Fprintf(out, "  %s\n", problem)
+			if problem.Warning {
+				warnings++
+			} else {
+				errors++
+			}
+		}
+
+		if errors > 0 || failOnWarnings && warnings > 0 {
+			fmt.Fprintf(out, "  FAILED: %d errors, %d warnings\n", errors, warnings)
+			passed = false
+			continue
+		}
+		fmt.Fprintf(out, "  SUCCESS: %d warnings\n", warnings)
+	}
+	return passed
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	cases := []struct {
+		name           string
+		files          []string
+		failOnWarnings bool
+		passed         bool
+		output         string
+	}{
+		{
+			name:   "valid config",
+			files:  []string{"testdata/config.yaml"},
+			passed: true,
+			output: "Checking testdata/config.yaml\n  SUCCESS: 0 warnings\n",
+		},
+		{
+			name:   "warnings",
+			files:  []string{"testdata/warnings.yaml"},
+			passed: true,
+			output: "Checking testdata/warnings.yaml\n" +
+				"  line 3: warning: metric_relabel_configs[0]: drops every series\n" +
+				"  SUCCESS: 1 warnings\n",
+		},
+		{
+			name:           "failing warnings",
+			files:          []string{"testdata/warnings.yaml"},
+			failOnWarnings: true,
+			passed:         false,
+			output: "Checking testdata/warnings.yaml\n" +
+				"  line 3: warning: metric_relabel_configs[0]: drops every series\n" +
+				"  FAILED: 0 errors, 1 warnings\n",
+		},
+		{
+			name:   "errors",
+			files:  []string{"testdata/unknown_field.yaml", "testdata/config.yaml"},
+			passed: false,
+			output: "Checking testdata/unknown_field.yaml\n" +
+				"  error: kairosdb-url: kairosdb-url is mandatory\n" +
+				"  line 1: error: field tests not found in type config.Config\n" +
+				"  FAILED: 2 errors, 0 warnings\n" +
+				"Checking testdata/config.yaml\n  SUCCESS: 0 warnings\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			assert.Equal(t, c.passed, checkConfig(c.files, c.failOnWarnings, &out))
+			assert.Equal(t, c.output, out.String())
+		})
+	}
+}
@@ -17,19 +17,27 @@ package cmd
 import (
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/proofpoint/prom-to-kairosdb/analytics"
 	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/ha"
 	"github.com/proofpoint/prom-to-kairosdb/kairosdb"
+	"github.com/proofpoint/prom-to-kairosdb/processor"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
 	"github.com/proofpoint/prom-to-kairosdb/server"
 	"github.com/spf13/cobra"
 )
 
 var (
-	cfgFile string
-	dryRun  bool
-	debug   bool
+	cfgFile       string
+	dryRun        bool
+	debug         bool
+	watchInterval time.Duration
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -53,36 +61,88 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME)")
 	RootCmd.PersistentFlags().BoolVar(&dryRun, "dryrun", false, "if set to true, dont push metrics to downstream")
 	RootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "if set to true, print debug level logs")
+	RootCmd.Flags().DurationVar(&watchInterval, "watch-config", 0, "if set, reload the config file when it changed, checking at this interval")
 
 	server.RegisterPrometheusMetrics()
 	kairosdb.RegisterPrometheusMetrics()
+	processor.RegisterPrometheusMetrics()
+	ha.RegisterPrometheusMetrics()
+	analytics.RegisterPrometheusMetrics()
+	relabel.RegisterPrometheusMetrics()
 }
 
 func Main() {
-	cfg, err := config.ParseCfgFile(cfgFile)
+	cfg, err := loadConfig()
 	if err != nil {
 		logrus.Errorf("%s", err)
 		os.Exit(-1)
 	}
 
 	if debug {
-		cfg.Debug = true
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
 	client := kairosdb.NewClient(cfg)
-	serve(cfg.Server.Port, *client)
+	var tracker *ha.Tracker
+	if cfg.HADedup.Enabled {
+		tracker = ha.NewTracker(cfg.HADedup)
+	}
+	serve(cfg, *client, tracker)
+}
+
+// loadConfig parses the config file, on start and on reload
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.ParseCfgFile(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	if debug {
+		cfg.Debug = true
+	}
+	return cfg, nil
 }
 
-func serve(addr string, client kairosdb.Client) error {
+func serve(cfg *config.Config, client kairosdb.Client, tracker *ha.Tracker) error {
 	serverobj := &server.Server{
-		Client: client,
+		Client:    client,
+		HATracker: tracker,
 	}
 
+	reloader := server.NewReloader(serverobj, cfg, loadConfig)
+	reloader.WatchSignals()
+	if watchInterval > 0 {
+		// the file ParseCfgFile read, as the path may be relative to the
+		// executable or the home directory
+		file, err := config.AbsFilename(cfgFile)
+		if err != nil {
+			logrus.Errorf("%s", err)
+			os.Exit(-1)
+		}
+		reloader.WatchFile(file, watchInterval)
+	}
+
+	// the processors are flushed on shutdown, so open windows are written
+	// and the counter reset offsets are saved
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-term
+		logrus.Infof("received %s, flushing the processors", sig)
+		if err := serverobj.Flush(); err != nil {
+			logrus.Errorf("failed flushing the processors: %s", err)
+		}
+		os.Exit(0)
+	}()
+
 	http.Handle("/write", serverobj)
+	http.HandleFunc("/debug/cardinality", serverobj.ServeCardinality)
+	http.HandleFunc("/debug/analytics", serverobj.ServeAnalytics)
+	http.HandleFunc("/status/relabel", serverobj.ServeRelabelStatus)
+	http.HandleFunc("/api/v1/explain", serverobj.ServeExplain)
+	http.HandleFunc("/-/reload", reloader.ServeReload)
 	http.Handle("/metrics", promhttp.Handler())
 
-	err := http.ListenAndServe(addr, nil)
+	err := http.ListenAndServe(cfg.Server.Port, nil)
 	logrus.Errorf("%s", err)
 	return err
 }
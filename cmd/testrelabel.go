@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/kairosdb"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// testRelabelCmd tests the metric relabel configs of the config against
+// expectation files, like promtool test rules
+var testRelabelCmd = &cobra.Command{
+	Use:   "test-relabel [test files]",
+	Short: "test the metric relabel configs against expected datapoints",
+	Args:  cobra.MinimumNArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.ParseCfgFile(cfgFile)
+		if err != nil {
+			logrus.Errorf("%s", err)
+			os.Exit(1)
+		}
+
+		if !testRelabel(cfg, args, os.Stdout) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(testRelabelCmd)
+}
+
+// relabelTests is a test file of test-relabel
+type relabelTests struct {
+	Tests []relabelTest `yaml:"tests"`
+}
+
+// relabelTest feeds the input series, in text form, through the metric
+// relabel configs. No expected datapoints means the input is dropped.
+type relabelTest struct {
+	Name     string              `yaml:"name"`
+	Input    []string            `yaml:"input"`
+	Expected []expectedDataPoint `yaml:"expected,omitempty"`
+}
+
+// expectedDataPoint is compared to a datapoint by name and tags, and by
+// value, timestamp, type and TTL if they are set
+type expectedDataPoint struct {
+	Name      string            `yaml:"name"`
+	Tags      map[string]string `yaml:"tags,omitempty"`
+	Value     *float64          `yaml:"value,omitempty"`
+	Timestamp *int64            `yaml:"timestamp,omitempty"`
+	Type      string            `yaml:"type,omitempty"`
+	TTL       int64             `yaml:"ttl,omitempty"`
+}
+
+// testRelabel runs the tests of the files and tells if all of them passed
+func testRelabel(cfg *config.Config, files []string, out io.Writer) bool {
+	passed := true
+	for _, file := range files {
+		fmt.Fprintf(out, "Testing %s\n", file)
+		failures, err := testRelabelFile(cfg, file, out)
+		if err != nil {
+			fmt.Fprintf(out, "  FAILED: %s\n", err)
+			passed = false
+			continue
+		}
+		if failures > 0 {
+			passed = false
+			continue
+		}
+		fmt.Fprintf(out, "  SUCCESS\n")
+	}
+	return passed
+}
+
+func testRelabelFile(cfg *config.Config, file string, out io.Writer) (int, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	var tests relabelTests
+	if err := yaml.UnmarshalStrict(buf, &tests); err != nil {
+		return 0, err
+	}
+
+	var failures int
+	for i, test := range tests.Tests {
+		name := test.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		var samples model.Samples
+		for _, input := range test.Input {
+			sample, err := relabel.ParseSeries(input)
+			if err != nil {
+				return failures, fmt.Errorf("test %s: %s", name, err)
+			}
+			samples = append(samples, sample)
+		}
+
+		// the datapoints are sanitized like they are by explain
+		datapoints := kairosdb.FilterAndProcessSamples(samples, cfg)
+		kairosdb.Sanitize(datapoints, cfg.Sanitize)
+		missing, unexpected := compareDataPoints(test.Expected, datapoints)
+		if len(missing) == 0 && len(unexpected) == 0 {
+			continue
+		}
+
+		failures++
+		fmt.Fprintf(out, "  FAILED: %s\n", name)
+		for _, input := range test.Input {
+			fmt.Fprintf(out, "    input: %s\n", input)
+		}
+		for _, expected := range missing {
+			fmt.Fprintf(out, "    - %s\n", formatExpected(expected))
+		}
+		for _, datapoint := range unexpected {
+			fmt.Fprintf(out, "    + %s\n", formatDataPoint(datapoint))
+		}
+	}
+	return failures, nil
+}
+
+// compareDataPoints returns the expected datapoints which were not written
+// and the datapoints which were not expected
+func compareDataPoints(expected []expectedDataPoint, datapoints []*kairosdb.DataPoint) ([]expectedDataPoint, []*kairosdb.DataPoint) {
+	unexpected := append([]*kairosdb.DataPoint(nil), datapoints...)
+	var missing []expectedDataPoint
+	for _, e := range expected {
+		found := false
+		for i, datapoint := range unexpected {
+			if e.matches(datapoint) {
+				unexpected = append(unexpected[:i], unexpected[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, e)
+		}
+	}
+	return missing, unexpected
+}
+
+func (e expectedDataPoint) matches(datapoint *kairosdb.DataPoint) bool {
+	if e.Name != datapoint.Name || len(e.Tags) != len(datapoint.Tags) {
+		return false
+	}
+	for key, value := range e.Tags {
+		if actual, ok := datapoint.Tags[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return (e.Value == nil || *e.Value == datapoint.Value) &&
+		(e.Timestamp == nil || *e.Timestamp == datapoint.Timestamp) &&
+		(e.Type == "" || e.Type == datapoint.Type) &&
+		(e.TTL == 0 || e.TTL == datapoint.TTL)
+}
+
+// formatDataPoint writes the datapoint like a series in text form, followed
+// by its type and TTL
+func formatDataPoint(datapoint *kairosdb.DataPoint) string {
+	s := fmt.Sprintf("%s %s %d", formatSeries(datapoint.Name, datapoint.Tags), strconv.FormatFloat(datapoint.Value, 'g', -1, 64), datapoint.Timestamp)
+	if datapoint.Type != "" {
+		s += " type=" + datapoint.Type
+	}
+	if datapoint.TTL != 0 {
+		s += fmt.Sprintf(" ttl=%d", datapoint.TTL)
+	}
+	return s
+}
+
+func formatExpected(e expectedDataPoint) string {
+	s := formatSeries(e.Name, e.Tags)
+	if e.Value != nil {
+		s += " " + strconv.FormatFloat(*e.Value, 'g', -1, 64)
+	}
+	if e.Timestamp != nil {
+		s += fmt.Sprintf(" %d", *e.Timestamp)
+	}
+	if e.Type != "" {
+		s += " type=" + e.Type
+	}
+	if e.TTL != 0 {
+		s += fmt.Sprintf(" ttl=%d", e.TTL)
+	}
+	return s
+}
+
+func formatSeries(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", key, tags[key])
+	}
+	return name + "{" + strings.Join(pairs, ", ") + "}"
+}
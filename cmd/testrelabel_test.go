@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestRelabel(t *testing.T) {
+	cfg, err := config.ParseCfgFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		files  []string
+		passed bool
+		output string
+	}{
+		{
+			name:   "passing tests",
+			files:  []string{"testdata/passing.yaml"},
+			passed: true,
+			output: "Testing testdata/passing.yaml\n  SUCCESS\n",
+		},
+		{
+			name:   "failing test",
+			files:  []string{"testdata/failing.yaml", "testdata/passing.yaml"},
+			passed: false,
+			output: "Testing testdata/failing.yaml\n" +
+				"  FAILED: pod is kept\n" +
+				"    input: up{job=\"api\",pod=\"a\"} 1 1000\n" +
+				"    - up{job=\"api\", pod=\"a\"}\n" +
+				"    + up{job=\"api\"} 1 1000\n" +
+				"Testing testdata/passing.yaml\n  SUCCESS\n",
+		},
+		{
+			name:   "unknown field",
+			files:  []string{"testdata/unknown_field.yaml"},
+			passed: false,
+			output: "Testing testdata/unknown_field.yaml\n" +
+				"  FAILED: yaml: unmarshal errors:\n  line 3: field inputs not found in type cmd.relabelTest\n",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			assert.Equal(t, c.passed, testRelabel(cfg, c.files, &out))
+			assert.Equal(t, c.output, out.String())
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Problem is an error or a warning found in a config file. Line is 0 if the
+// line is not known.
+type Problem struct {
+	Line    int
+	Warning bool
+	Message string
+}
+
+func (p Problem) String() string {
+	severity := "error"
+	if p.Warning {
+		severity = "warning"
+	}
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", severity, p.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.Line, severity, p.Message)
+}
+
+// lineError matches the line of the errors of the yaml parser
+var lineError = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// CheckCfgFile parses the config file strictly, rejecting unknown keys, and
+// returns every error and suspicious metric relabel config, unlike
+// ParseCfgFile which fails on the first error
+func CheckCfgFile(cfgFile string) []Problem {
+	if cfgFile == "" {
+		return []Problem{{Message: "no config file provided"}}
+	}
+	buf, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+	return checkCfg(buf)
+}
+
+func checkCfg(buf []byte) []Problem {
+	var problems []Problem
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(buf, cfg); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			// syntax errors stop the parser
+			return []Problem{parseProblem(err.Error())}
+		}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, parseProblem(msg))
+		}
+	}
+
+	// invalid regexes are decoded without being compiled
+	walkRegexps(reflect.ValueOf(cfg), "", func(path string, re Regexp) {
+		problems = append(problems, Problem{Line: lineOf(buf, re.original), Message: fmt.Sprintf("%s: %s", path, re.err)})
+	})
+
+	lines := newLineIndex(buf)
+	rules := len(cfg.MetricRelabelConfigs)
+	for _, section := range sections {
+		// the rules are checked one by one below
+		if section.key == "metric_relabel_configs" {
+			continue
+		}
+		if err := section.validate(cfg); err != nil {
+			problems = append(problems, Problem{Line: lines.key(section.key), Message: fmt.Sprintf("%s: %s", section.key, err)})
+		}
+	}
+
+	// the rule of the metricname-prefix is appended after the rules of the file
+	for i, c := range cfg.MetricRelabelConfigs[:rules] {
+		line := lines.item("metric_relabel_configs", i)
+		for _, msg := range checkRelabelConfig(c) {
+			problems = append(problems, Problem{Line: line, Message: fmt.Sprintf("metric_relabel_configs[%d]: %s", i, msg)})
+		}
+		for _, msg := range lintRelabelConfig(c, cfg.MetricRelabelConfigs[:i]) {
+			problems = append(problems, Problem{Line: line, Warning: true, Message: fmt.Sprintf("metric_relabel_configs[%d]: %s", i, msg)})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+// lineOf returns the first line containing the text, or 0
+func lineOf(buf []byte, text string) int {
+	i := bytes.Index(buf, []byte(text))
+	if i < 0 {
+		return 0
+	}
+	return bytes.Count(buf[:i], []byte("\n")) + 1
+}
+
+func parseProblem(msg string) Problem {
+	m := lineError.FindStringSubmatch(msg)
+	if m == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return Problem{Line: line, Message: m[2]}
+}
+
+// checkRelabelConfig returns the errors of the rule, including the ones
+// ParseCfgFile lets through but which break relabeling
+func checkRelabelConfig(c *RelabelConfig) []string {
+	var errs []string
+	if err := validateRelabelConfig(c); err != nil {
+		errs = append(errs, err.Error())
+	}
+	switch c.Action {
+	case RelabelKeep, RelabelDrop, RelabelLabelKeep, RelabelLabelDrop, RelabelAddPrefix:
+	case "":
+		errs = append(errs, "action is required")
+	default:
+		errs = append(errs, fmt.Sprintf("unknown action %q", c.Action))
+	}
+	if c.Regex.Regexp == nil && c.Regex.err == nil {
+		errs = append(errs, "regex is required")
+	}
+	// the regex was likely written for the value of the source labels, and
+	// matched against label names it drops the labels it should keep
+	if c.Action == RelabelLabelKeep && len(c.SourceLabels) > 0 {
+		errs = append(errs, "labelkeep matches label names and ignores source_labels")
+	}
+	return errs
+}
+
+// lintRelabelConfig returns the warnings of the rule which is applied after
+// the previous rules
+func lintRelabelConfig(c *RelabelConfig, previous []*RelabelConfig) []string {
+	var warnings []string
+	for i, p := range previous {
+		if sameRelabelConfig(c, p) {
+			warnings = append(warnings, fmt.Sprintf("duplicate of metric_relabel_configs[%d]", i))
+		}
+	}
+	if c.Regex.Regexp == nil {
+		return warnings
+	}
+
+	if neverMatches(c.Regex.String()) {
+		return append(warnings, fmt.Sprintf("regex %q can never match", c.Regex.String()))
+	}
+
+	// without source labels the regex only sees an empty value
+	matchesAll := matchesAnyValue(c.Regex)
+	if len(c.SourceLabels) == 0 {
+		matchesAll = c.Regex.MatchString("")
+	}
+	switch c.Action {
+	case RelabelKeep, RelabelDrop:
+		switch {
+		case matchesAll && c.Action == RelabelDrop:
+			warnings = append(warnings, "drops every series")
+		case matchesAll:
+			warnings = append(warnings, "keeps every series and has no effect")
+		case len(c.SourceLabels) == 0:
+			warnings = append(warnings, "without source_labels the regex only sees an empty value and never matches")
+		}
+	case RelabelAddPrefix:
+		if len(c.SourceLabels) == 0 && !matchesAll {
+			warnings = append(warnings, "without source_labels the regex only sees an empty value and never matches")
+		}
+	case RelabelLabelKeep:
+		if !c.Regex.MatchString(model.MetricNameLabel) {
+			warnings = append(warnings, fmt.Sprintf("drops the metric name, as the regex doesn't match %s", model.MetricNameLabel))
+		}
+	case RelabelLabelDrop:
+		if c.Regex.MatchString(model.MetricNameLabel) {
+			warnings = append(warnings, fmt.Sprintf("drops the metric name, as the regex matches %s", model.MetricNameLabel))
+		}
+	}
+	return warnings
+}
+
+// probeValues are matched to tell if a regex matches any value
+var probeValues = []string{"", "x", "prom-to-kairosdb probe", "\x00\n\u00e9"}
+
+// matchesAnyValue tells if the regex matches the empty value as well as
+// arbitrary values, like .* does and ^$ doesn't
+func matchesAnyValue(re Regexp) bool {
+	for _, value := range probeValues {
+		if !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRelabelConfig(a, b *RelabelConfig) bool {
+	if a.Action != b.Action || a.Separator != b.Separator || a.Prefix != b.Prefix ||
+		a.Regex.original != b.Regex.original || len(a.SourceLabels) != len(b.SourceLabels) {
+		return false
+	}
+	for i := range a.SourceLabels {
+		if a.SourceLabels[i] != b.SourceLabels[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// neverMatches tells if the regex can't match any value, like a regex with
+// an anchor in the middle of characters. It doesn't find every such regex.
+func neverMatches(expr string) bool {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return !canMatch(re.Simplify())
+}
+
+func canMatch(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+	case syntax.OpCharClass:
+		return len(re.Rune) > 0
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if canMatch(sub) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpCapture, syntax.OpPlus:
+		return canMatch(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min == 0 || canMatch(re.Sub[0])
+	case syntax.OpConcat:
+		var consumed, ended bool
+		for _, sub := range re.Sub {
+			if !canMatch(sub) {
+				return false
+			}
+			switch {
+			case sub.Op == syntax.OpBeginText && consumed:
+				return false
+			case sub.Op == syntax.OpEndText:
+				ended = true
+			case consumes(sub):
+				if ended {
+					return false
+				}
+				consumed = true
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// consumes tells if every match of the regex has at least one character
+func consumes(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune) > 0
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return consumes(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && consumes(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if consumes(sub) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !consumes(sub) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// lineIndex finds the lines of the top level keys of a config file and of
+// the items of their block sequences
+type lineIndex struct {
+	keys  map[string]int
+	items map[string][]int
+}
+
+var topLevelKey = regexp.MustCompile(`^([^\s#-][^:]*):`)
+var sequenceItem = regexp.MustCompile(`^(\s*)- `)
+
+func newLineIndex(buf []byte) lineIndex {
+	index := lineIndex{keys: make(map[string]int), items: make(map[string][]int)}
+
+	var key, indent string
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if m := topLevelKey.FindStringSubmatch(text); m != nil {
+			key = strings.Trim(m[1], `"'`)
+			indent = ""
+			if _, ok := index.keys[key]; !ok {
+				index.keys[key] = line
+			}
+			continue
+		}
+		m := sequenceItem.FindStringSubmatch(text + " ")
+		if key == "" || m == nil {
+			continue
+		}
+		// nested sequences are indented further than the first item
+		if indent == "" {
+			indent = m[1] + "-"
+		}
+		if m[1]+"-" == indent {
+			index.items[key] = append(index.items[key], line)
+		}
+	}
+	return index
+}
+
+func (l lineIndex) key(key string) int {
+	return l.keys[key]
+}
+
+// item returns the line of the item of the sequence, or of its key if the
+// sequence is not a block sequence
+func (l lineIndex) item(key string, i int) int {
+	if items := l.items[key]; i < len(items) {
+		return items[i]
+	}
+	return l.keys[key]
+}
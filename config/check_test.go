@@ -0,0 +1,140 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCheckCfgFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		fileName string
+		problems []Problem
+	}{
+		{
+			name:     "valid config with duplicate rule",
+			fileName: "testdata/valid.yaml",
+			problems: []Problem{{Line: 6, Warning: true, Message: "metric_relabel_configs[1]: duplicate of metric_relabel_configs[0]"}},
+		},
+		{
+			name:     "errors and warnings",
+			fileName: "testdata/check.yaml",
+			problems: []Problem{
+				{Line: 2, Message: "timeout: timeout 3600000000000 is too high. It should be between 1s and 1m0s"},
+				{Line: 7, Message: "metric_relabel_configs[1]: labelkeep matches label names and ignores source_labels"},
+				{Line: 7, Warning: true, Message: "metric_relabel_configs[1]: drops the metric name, as the regex doesn't match __name__"},
+				{Line: 10, Warning: true, Message: `metric_relabel_configs[2]: regex "up$_total" can never match`},
+				{Line: 13, Warning: true, Message: "metric_relabel_configs[3]: duplicate of metric_relabel_configs[0]"},
+				{Line: 16, Message: `metric_relabel_configs[4]: unknown action "labeldorp"`},
+				{Line: 18, Message: "metric_relabel_configs[5]: regex is required"},
+				{Line: 20, Message: `counter-rates: matchers only support keep and drop actions, got "labeldrop"`},
+				{Line: 26, Message: "field defualt not found in type config.ValueTypes"},
+			},
+		},
+		{
+			name:     "invalid yaml",
+			fileName: "testdata/invalid_yaml.yaml",
+			problems: []Problem{{Line: 2, Message: "mapping values are not allowed in this context"}},
+		},
+		{
+			name:     "invalid regex",
+			fileName: "testdata/invalid_regex.yaml",
+			problems: []Problem{{Line: 4, Message: "metric_relabel_configs[0].regex: error parsing regexp: missing closing ): `$^*(`"}},
+		},
+		{
+			name:     "invalid regexes and other errors",
+			fileName: "testdata/check_invalid_regexes.yaml",
+			problems: []Problem{
+				{Line: 4, Message: "metric_relabel_configs[0].regex: error parsing regexp: missing closing ): `go_(.*`"},
+				{Line: 8, Message: "field sources not found in type config.RelabelConfig"},
+				{Line: 13, Message: "counter-rates.rules[0].match[0].regex: error parsing regexp: missing argument to repetition operator: `*`"},
+				{Line: 18, Message: "schema.metrics[0].tags.job: error parsing regexp: missing closing ]: `[a-`"},
+			},
+		},
+		{
+			name:     "no kairosdb url",
+			fileName: "testdata/no_kairosdb.yaml",
+			problems: []Problem{{Message: "kairosdb-url: kairosdb-url is mandatory"}},
+		},
+	}
+
+	for _, c := range cases {
+		problems := CheckCfgFile(c.fileName)
+		if !reflect.DeepEqual(c.problems, problems) {
+			t.Errorf("case '%s'. Expected %v, got %v", c.name, c.problems, problems)
+		}
+	}
+}
+
+func TestLintRelabelConfig(t *testing.T) {
+	cases := []struct {
+		name     string
+		cfg      *RelabelConfig
+		warnings []string
+	}{
+		{
+			name: "drop metric",
+			cfg:  &RelabelConfig{SourceLabels: []model.LabelName{"__name__"}, Regex: MustNewRegexp("^go_"), Action: RelabelDrop},
+		},
+		{
+			name:     "drop everything",
+			cfg:      &RelabelConfig{SourceLabels: []model.LabelName{"__name__"}, Regex: MustNewRegexp(".*"), Action: RelabelDrop},
+			warnings: []string{"drops every series"},
+		},
+		{
+			name:     "keep everything",
+			cfg:      &RelabelConfig{SourceLabels: []model.LabelName{"job"}, Regex: MustNewRegexp("a|"), Action: RelabelKeep},
+			warnings: []string{"keeps every series and has no effect"},
+		},
+		{
+			name: "drop series without label",
+			cfg:  &RelabelConfig{SourceLabels: []model.LabelName{"team"}, Regex: MustNewRegexp("^$"), Action: RelabelDrop},
+		},
+		{
+			name: "keep series with value or without label",
+			cfg:  &RelabelConfig{SourceLabels: []model.LabelName{"env"}, Regex: MustNewRegexp("^prod$|^$"), Action: RelabelKeep},
+		},
+		{
+			name:     "drop without source labels matching the empty value",
+			cfg:      &RelabelConfig{Regex: MustNewRegexp("^$"), Action: RelabelDrop},
+			warnings: []string{"drops every series"},
+		},
+		{
+			name:     "keep without source labels",
+			cfg:      &RelabelConfig{Regex: MustNewRegexp("api"), Action: RelabelKeep},
+			warnings: []string{"without source_labels the regex only sees an empty value and never matches"},
+		},
+		{
+			name: "anchor within alternation",
+			cfg:  &RelabelConfig{SourceLabels: []model.LabelName{"job"}, Regex: MustNewRegexp("(api$|db)x"), Action: RelabelKeep},
+		},
+		{
+			name:     "begin anchor after characters",
+			cfg:      &RelabelConfig{SourceLabels: []model.LabelName{"job"}, Regex: MustNewRegexp("a+^b"), Action: RelabelKeep},
+			warnings: []string{`regex "a+^b" can never match`},
+		},
+		{
+			name:     "empty character class",
+			cfg:      &RelabelConfig{SourceLabels: []model.LabelName{"job"}, Regex: MustNewRegexp(`a[^\x00-\x{10FFFF}]`), Action: RelabelDrop},
+			warnings: []string{`regex "a[^\\x00-\\x{10FFFF}]" can never match`},
+		},
+		{
+			name:     "labeldrop of the metric name",
+			cfg:      &RelabelConfig{Regex: MustNewRegexp("name"), Action: RelabelLabelDrop},
+			warnings: []string{"drops the metric name, as the regex matches __name__"},
+		},
+		{
+			name: "labelkeep with the metric name",
+			cfg:  &RelabelConfig{Regex: MustNewRegexp("^(__name__|job)$"), Action: RelabelLabelKeep},
+		},
+	}
+
+	for _, c := range cases {
+		warnings := lintRelabelConfig(c.cfg, nil)
+		if !reflect.DeepEqual(c.warnings, warnings) {
+			t.Errorf("case '%s'. Expected %v, got %v", c.name, c.warnings, warnings)
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -19,22 +23,477 @@ const defaultServerPort = ":9201"
 const minTimeout = 1 * time.Second
 const maxTimeout = 60 * time.Second
 const defaultTimeout = 30 * time.Second
+const defaultMetadataService = "prometheus"
+const defaultExemplarSuffix = "_exemplar"
+const defaultSeriesTTL = 15 * time.Minute
+const defaultRateSuffix = "_rate"
+const defaultIncreaseSuffix = "_increase"
+const defaultNormalizedSuffix = "_normalized"
+const defaultSaveInterval = 10 * time.Second
+const defaultOffsetTTL = 7 * 24 * time.Hour
+const defaultAggregationInterval = time.Minute
+const defaultAggregationGracePeriod = 30 * time.Second
+const defaultClusterLabel = "cluster"
+const defaultReplicaLabel = "replica"
+const defaultFailoverTimeout = 30 * time.Second
+const defaultHeartbeat = 5 * time.Minute
+const defaultMaxSeries = 1000000
+const defaultStaleSuffix = "_stale"
+const defaultStaleTag = "stale"
+const defaultQuarantinePrefix = "quarantine."
+const defaultReplacement = "_"
+
+// HashSuffixLength is the length of the hash appended to truncated values
+const HashSuffixLength = 8
+
+const defaultTopOffenders = 10
+const defaultAnalyticsWindow = 10 * time.Minute
+const defaultAnalyticsBuckets = 5
+const defaultAnalyticsPrecision = 10
+const defaultAnalyticsMaxKeys = 10000
 
 // Config struct is top level config object
 type Config struct {
-	KairosdbURL          URL              `json:"kairosdb-url" yaml:"kairosdb-url"`
-	MetricnamePrefix     string           `json:"metricname-prefix" yaml:"metricname-prefix"`
-	Timeout              time.Duration    `json:"timeout" yaml:"timeout"`
-	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
-	Server               Server           `yaml:"server,omitempty"`
-	DryRun               bool             `yaml:"dryrun,omitempty"`
-	Debug                bool             `yaml:"debug,omitempty"`
+	KairosdbURL          URL               `json:"kairosdb-url" yaml:"kairosdb-url"`
+	MetricnamePrefix     string            `json:"metricname-prefix" yaml:"metricname-prefix"`
+	Timeout              time.Duration     `json:"timeout" yaml:"timeout"`
+	MetricRelabelConfigs []*RelabelConfig  `yaml:"metric_relabel_configs,omitempty"`
+	Server               Server            `yaml:"server,omitempty"`
+	NativeHistograms     NativeHistograms  `yaml:"native-histograms,omitempty"`
+	Metadata             Metadata          `yaml:"metadata,omitempty"`
+	Exemplars            Exemplars         `yaml:"exemplars,omitempty"`
+	CounterRates         CounterRates      `yaml:"counter-rates,omitempty"`
+	CounterResets        CounterResets     `yaml:"counter-resets,omitempty"`
+	Aggregations         Aggregations      `yaml:"aggregations,omitempty"`
+	Collisions           Collisions        `yaml:"collisions,omitempty"`
+	HADedup              HADedup           `yaml:"ha-dedup,omitempty"`
+	Downsampling         Downsampling      `yaml:"downsampling,omitempty"`
+	ChangeOnly           ChangeOnly        `yaml:"change-only,omitempty"`
+	InfoMetrics          InfoMetrics       `yaml:"info-metrics,omitempty"`
+	ValueTypes           ValueTypes        `yaml:"value-types,omitempty"`
+	TTL                  TTLs              `yaml:"ttl,omitempty"`
+	SpecialValues        SpecialValues     `yaml:"special-values,omitempty"`
+	Timestamps           Timestamps        `yaml:"timestamps,omitempty"`
+	Sanitize             Sanitize          `yaml:"sanitize,omitempty"`
+	Schema               Schema            `yaml:"schema,omitempty"`
+	CardinalityLimits    CardinalityLimits `yaml:"cardinality-limits,omitempty"`
+	Analytics            Analytics         `yaml:"analytics,omitempty"`
+	DryRun               bool              `yaml:"dryrun,omitempty"`
+	Debug                bool              `yaml:"debug,omitempty"`
 }
 
 type Server struct {
 	Port string `yaml:"port,flow,omitempty"`
 }
 
+// NativeHistograms defines how Prometheus native histograms are written to KairosDB
+type NativeHistograms struct {
+	Mode HistogramMode `yaml:"mode,omitempty"`
+}
+
+// Metadata defines how Prometheus metric metadata is stored in KairosDB. Type,
+// help and unit are written as keys of the metric name below the service.
+type Metadata struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	Service string `yaml:"service,omitempty"`
+	// TypeTag is the tag the metric type is added as to datapoints, if set.
+	TypeTag string `yaml:"type-tag,omitempty"`
+}
+
+// Exemplars defines how exemplars are written to KairosDB. Each exemplar
+// becomes a datapoint of the metric name with the suffix appended, tagged
+// with the labels of the series and of the exemplar.
+type Exemplars struct {
+	Enabled      bool   `yaml:"enabled,omitempty"`
+	MetricSuffix string `yaml:"metric-suffix,omitempty"`
+}
+
+// CounterRates defines which counters are converted to per-second rates or
+// increases. The last value of every series is kept until the series was
+// idle for SeriesTTL.
+type CounterRates struct {
+	SeriesTTL time.Duration `yaml:"series-ttl,omitempty"`
+	Rules     []*RateRule   `yaml:"rules,omitempty"`
+}
+
+// RateRule selects counters with relabel style keep and drop matchers
+type RateRule struct {
+	Match          []*RelabelConfig `yaml:"match,omitempty"`
+	Output         RateOutput       `yaml:"output,omitempty"`
+	RateSuffix     string           `yaml:"rate-suffix,omitempty"`
+	IncreaseSuffix string           `yaml:"increase-suffix,omitempty"`
+}
+
+// RateOutput is the metric written for a counter
+type RateOutput string
+
+const (
+	// RateOutputRate writes the per-second rate
+	RateOutputRate RateOutput = "rate"
+	// RateOutputIncrease writes the increase since the previous sample
+	RateOutputIncrease RateOutput = "increase"
+	// RateOutputBoth writes the rate and the increase
+	RateOutputBoth RateOutput = "both"
+)
+
+// CounterResets defines which counters get normalized across counter resets.
+// The offsets are saved to StateFile at most every SaveInterval, so a restart
+// of the adapter does not reset the normalized counters. Series with an offset
+// are kept until idle for OffsetTTL, the others until idle for SeriesTTL.
+type CounterResets struct {
+	StateFile    string           `yaml:"state-file,omitempty"`
+	SaveInterval time.Duration    `yaml:"save-interval,omitempty"`
+	SeriesTTL    time.Duration    `yaml:"series-ttl,omitempty"`
+	OffsetTTL    time.Duration    `yaml:"offset-ttl,omitempty"`
+	Rules        []*NormalizeRule `yaml:"rules,omitempty"`
+}
+
+// NormalizeRule selects counters with relabel style keep and drop matchers.
+// The normalized counter is written as metric name with the suffix.
+type NormalizeRule struct {
+	Match  []*RelabelConfig `yaml:"match,omitempty"`
+	Suffix string           `yaml:"suffix,omitempty"`
+}
+
+// Aggregations defines streaming aggregations of the received samples,
+// similar to Prometheus recording rules like sum by (namespace) (metric).
+// Windows are written once GracePeriod passed after their end.
+type Aggregations struct {
+	GracePeriod time.Duration      `yaml:"grace-period,omitempty"`
+	Rules       []*AggregationRule `yaml:"rules,omitempty"`
+}
+
+// AggregationRule aggregates the series selected by relabel style keep and
+// drop matchers into the metric, grouped by the labels in By. The last value
+// of every series within a window of Interval is aggregated.
+type AggregationRule struct {
+	Match      []*RelabelConfig    `yaml:"match,omitempty"`
+	Metric     string              `yaml:"metric,omitempty"`
+	Function   AggregationFunction `yaml:"function,omitempty"`
+	By         model.LabelNames    `yaml:"by,flow,omitempty"`
+	Interval   time.Duration       `yaml:"interval,omitempty"`
+	DropInputs bool                `yaml:"drop-inputs,omitempty"`
+}
+
+// AggregationFunction is the function series are aggregated with
+type AggregationFunction string
+
+const (
+	// AggregationSum writes the sum of the series
+	AggregationSum AggregationFunction = "sum"
+	// AggregationAvg writes the average of the series
+	AggregationAvg AggregationFunction = "avg"
+	// AggregationMin writes the smallest value of the series
+	AggregationMin AggregationFunction = "min"
+	// AggregationMax writes the largest value of the series
+	AggregationMax AggregationFunction = "max"
+	// AggregationCount writes the number of series
+	AggregationCount AggregationFunction = "count"
+	// AggregationLast writes the most recent value of the series
+	AggregationLast AggregationFunction = "last"
+)
+
+// Collisions defines how samples of distinct Prometheus series are resolved
+// which end up as the same KairosDB series and timestamp after relabeling.
+// Rules select the policy of series with relabel style keep and drop
+// matchers, the first matching rule wins. The last timestamp of every
+// KairosDB series is kept until the series was idle for SeriesTTL.
+type Collisions struct {
+	Enabled   bool             `yaml:"enabled,omitempty"`
+	Policy    CollisionPolicy  `yaml:"policy,omitempty"`
+	SeriesTTL time.Duration    `yaml:"series-ttl,omitempty"`
+	Rules     []*CollisionRule `yaml:"rules,omitempty"`
+}
+
+// CollisionRule sets the collision policy of the matching series
+type CollisionRule struct {
+	Match  []*RelabelConfig `yaml:"match,omitempty"`
+	Policy CollisionPolicy  `yaml:"policy,omitempty"`
+}
+
+// CollisionPolicy is how colliding samples are resolved
+type CollisionPolicy string
+
+const (
+	// CollisionSum writes the sum of the colliding samples
+	CollisionSum CollisionPolicy = "sum"
+	// CollisionAvg writes the average of the colliding samples
+	CollisionAvg CollisionPolicy = "avg"
+	// CollisionMax writes the largest of the colliding samples
+	CollisionMax CollisionPolicy = "max"
+	// CollisionLast writes the last of the colliding samples
+	CollisionLast CollisionPolicy = "last"
+	// CollisionReject drops the colliding samples after the first one
+	CollisionReject CollisionPolicy = "reject"
+)
+
+// HADedup defines the deduplication of Prometheus HA pairs. Per cluster only
+// the samples of the elected replica are written, another replica is elected
+// once the elected one sent nothing for FailoverTimeout.
+type HADedup struct {
+	Enabled         bool          `yaml:"enabled,omitempty"`
+	ClusterLabel    string        `yaml:"cluster-label,omitempty"`
+	ReplicaLabel    string        `yaml:"replica-label,omitempty"`
+	FailoverTimeout time.Duration `yaml:"failover-timeout,omitempty"`
+}
+
+// Downsampling defines which series are written with at most one sample per
+// interval. The state of every series is kept until the series was idle for
+// SeriesTTL.
+type Downsampling struct {
+	SeriesTTL time.Duration       `yaml:"series-ttl,omitempty"`
+	Rules     []*DownsamplingRule `yaml:"rules,omitempty"`
+}
+
+// DownsamplingRule selects series with relabel style keep and drop matchers.
+// The first matching rule wins.
+type DownsamplingRule struct {
+	Match    []*RelabelConfig     `yaml:"match,omitempty"`
+	Interval time.Duration        `yaml:"interval,omitempty"`
+	Function DownsamplingFunction `yaml:"function,omitempty"`
+}
+
+// DownsamplingFunction selects the sample written per interval
+type DownsamplingFunction string
+
+const (
+	// DownsamplingFirst writes the first sample of an interval
+	DownsamplingFirst DownsamplingFunction = "first"
+	// DownsamplingLast writes the last sample of an interval
+	DownsamplingLast DownsamplingFunction = "last"
+	// DownsamplingAvg writes the average of the samples of an interval
+	DownsamplingAvg DownsamplingFunction = "avg"
+	// DownsamplingMax writes the largest sample of an interval
+	DownsamplingMax DownsamplingFunction = "max"
+)
+
+// ChangeOnly defines which series are only written when their value changes.
+// The last written value of at most MaxSeries series is kept, the series
+// seen least recently are evicted first, as are series idle for SeriesTTL.
+type ChangeOnly struct {
+	MaxSeries int           `yaml:"max-series,omitempty"`
+	SeriesTTL time.Duration `yaml:"series-ttl,omitempty"`
+	Rules     []*ChangeRule `yaml:"rules,omitempty"`
+}
+
+// ChangeRule selects series with relabel style keep and drop matchers. An
+// unchanged value is written again once Heartbeat passed since the last write.
+type ChangeRule struct {
+	Match     []*RelabelConfig `yaml:"match,omitempty"`
+	Heartbeat time.Duration    `yaml:"heartbeat,omitempty"`
+}
+
+// InfoMetrics defines which info metrics, like build_info, are written as
+// KairosDB string datapoints instead of datapoints with value 1.
+type InfoMetrics struct {
+	Rules []*InfoRule `yaml:"rules,omitempty"`
+}
+
+// InfoRule selects info metrics with relabel style keep and drop matchers.
+// The labels in ValueLabels are encoded as the value. Only the labels in
+// IdentifyingLabels are written as tags, or all other labels if it is empty.
+type InfoRule struct {
+	Match             []*RelabelConfig `yaml:"match,omitempty"`
+	ValueLabels       model.LabelNames `yaml:"value-labels,flow,omitempty"`
+	IdentifyingLabels model.LabelNames `yaml:"identifying-labels,flow,omitempty"`
+}
+
+// ValueTypes defines whether datapoints are written as KairosDB long or
+// double values. The type of the first matching rule is used, or the default.
+// Without any type KairosDB decides on its own.
+type ValueTypes struct {
+	Default ValueType        `yaml:"default,omitempty"`
+	Rules   []*ValueTypeRule `yaml:"rules,omitempty"`
+}
+
+// ValueTypeRule selects series with relabel style keep and drop matchers
+type ValueTypeRule struct {
+	Match []*RelabelConfig `yaml:"match,omitempty"`
+	Type  ValueType        `yaml:"type,omitempty"`
+}
+
+// ValueType is the KairosDB data type of datapoint values
+type ValueType string
+
+const (
+	// ValueTypeAuto writes integral values as long and others as double
+	ValueTypeAuto ValueType = "auto"
+	// ValueTypeLong writes values rounded to the nearest integer as long
+	ValueTypeLong ValueType = "long"
+	// ValueTypeDouble writes values as double
+	ValueTypeDouble ValueType = "double"
+)
+
+// TTLs defines the KairosDB TTL of datapoints. The TTL of the first matching
+// rule is used, or the default. Without any TTL the retention of KairosDB
+// applies.
+type TTLs struct {
+	Default model.Duration `yaml:"default,omitempty"`
+	Rules   []*TTLRule     `yaml:"rules,omitempty"`
+}
+
+// TTLRule selects series with relabel style keep and drop matchers. The
+// datapoints written with the TTL are counted per class.
+type TTLRule struct {
+	Match []*RelabelConfig `yaml:"match,omitempty"`
+	TTL   model.Duration   `yaml:"ttl,omitempty"`
+	Class string           `yaml:"class,omitempty"`
+}
+
+// SpecialValues defines how values KairosDB can't store are handled:
+// Prometheus stale markers, other NaN values and infinities.
+type SpecialValues struct {
+	StaleMarkers StaleMarkers `yaml:"stale-markers,omitempty"`
+	NaN          SpecialValue `yaml:"nan,omitempty"`
+	PositiveInf  SpecialValue `yaml:"positive-inf,omitempty"`
+	NegativeInf  SpecialValue `yaml:"negative-inf,omitempty"`
+}
+
+// StaleMarkers defines how the end of a series, marked by Prometheus with
+// a stale marker, is written.
+type StaleMarkers struct {
+	Action       StaleMarkerAction `yaml:"action,omitempty"`
+	MetricSuffix string            `yaml:"metric-suffix,omitempty"`
+	Tag          string            `yaml:"tag,omitempty"`
+}
+
+// StaleMarkerAction is how stale markers are written
+type StaleMarkerAction string
+
+const (
+	// StaleMarkerDrop drops stale markers
+	StaleMarkerDrop StaleMarkerAction = "drop"
+	// StaleMarkerDatapoint writes 1 to the metric name with the suffix
+	StaleMarkerDatapoint StaleMarkerAction = "datapoint"
+	// StaleMarkerTag writes 0 to the series with the tag set to true
+	StaleMarkerTag StaleMarkerAction = "tag"
+)
+
+// SpecialValue defines whether a NaN or infinite value is dropped or
+// replaced by the sentinel value
+type SpecialValue struct {
+	Action   SpecialValueAction `yaml:"action,omitempty"`
+	Sentinel float64            `yaml:"sentinel,omitempty"`
+}
+
+// SpecialValueAction is how a NaN or infinite value is handled
+type SpecialValueAction string
+
+const (
+	// SpecialValueDrop drops the value
+	SpecialValueDrop SpecialValueAction = "drop"
+	// SpecialValueSentinel writes the sentinel value instead
+	SpecialValueSentinel SpecialValueAction = "sentinel"
+)
+
+// Timestamps defines the window of sample timestamps around the receive
+// time which is accepted. A zero MaxSampleAge or MaxFutureSkew disables the
+// respective check.
+type Timestamps struct {
+	MaxSampleAge     time.Duration   `yaml:"max-sample-age,omitempty"`
+	MaxFutureSkew    time.Duration   `yaml:"max-future-skew,omitempty"`
+	Action           TimestampAction `yaml:"action,omitempty"`
+	QuarantinePrefix string          `yaml:"quarantine-prefix,omitempty"`
+}
+
+// TimestampAction is how samples with timestamps outside of the window are handled
+type TimestampAction string
+
+const (
+	// TimestampReject drops the sample
+	TimestampReject TimestampAction = "reject"
+	// TimestampClamp writes the sample with the receive time as timestamp
+	TimestampClamp TimestampAction = "clamp"
+	// TimestampQuarantine writes the sample with the quarantine prefix
+	// prepended to the metric name
+	TimestampQuarantine TimestampAction = "quarantine"
+)
+
+// Sanitize defines how metric names, tag keys and tag values are made safe
+// for KairosDB and Cassandra.
+type Sanitize struct {
+	MetricNames SanitizeRule `yaml:"metric-names,omitempty"`
+	TagKeys     SanitizeRule `yaml:"tag-keys,omitempty"`
+	TagValues   SanitizeRule `yaml:"tag-values,omitempty"`
+}
+
+// SanitizeRule replaces the characters matching InvalidChars with the
+// replacement. Values longer than MaxLength are truncated and a hash of the
+// value is appended, separated by the replacement, so they stay distinct.
+type SanitizeRule struct {
+	InvalidChars Regexp `yaml:"invalid-chars,omitempty"`
+	Replacement  string `yaml:"replacement,omitempty"`
+	MaxLength    int    `yaml:"max-length,omitempty"`
+}
+
+// Schema lists the tags allowed per metric. Series of metrics without schema
+// are handled according to UnknownMetrics, tags which are not listed or
+// whose value doesn't match according to Mode.
+type Schema struct {
+	Mode           SchemaMode      `yaml:"mode,omitempty"`
+	UnknownMetrics SchemaMode      `yaml:"unknown-metrics,omitempty"`
+	Metrics        []*MetricSchema `yaml:"metrics,omitempty"`
+}
+
+// MetricSchema maps the allowed tags of a metric to the pattern of their
+// values. Tags without pattern allow any value. Mode overrides the mode of
+// the schema for the metric.
+type MetricSchema struct {
+	Name string            `yaml:"name"`
+	Mode SchemaMode        `yaml:"mode,omitempty"`
+	Tags map[string]Regexp `yaml:"tags,omitempty"`
+}
+
+// SchemaMode is how series violating the schema are handled
+type SchemaMode string
+
+const (
+	// SchemaPass writes the series unchanged, only counting the violation
+	SchemaPass SchemaMode = "pass"
+	// SchemaStrip removes the violating tags from the series
+	SchemaStrip SchemaMode = "strip"
+	// SchemaDrop drops the series
+	SchemaDrop SchemaMode = "drop"
+)
+
+// CardinalityLimits bounds the number of active KairosDB series, globally
+// and per metric name. Once a limit is reached, samples of new series are
+// dropped while the active series keep being written. Series become inactive
+// after SeriesTTL without samples. Metrics overrides MaxSeriesPerMetric by
+// metric name.
+type CardinalityLimits struct {
+	Enabled            bool           `yaml:"enabled,omitempty"`
+	MaxSeries          int            `yaml:"max-series,omitempty"`
+	MaxSeriesPerMetric int            `yaml:"max-series-per-metric,omitempty"`
+	Metrics            map[string]int `yaml:"metrics,omitempty"`
+	SeriesTTL          time.Duration  `yaml:"series-ttl,omitempty"`
+	TopOffenders       int            `yaml:"top-offenders,omitempty"`
+}
+
+// Analytics estimates the number of series per metric name, label name and
+// label value with HyperLogLog sketches over a sliding window, split into
+// buckets. Precision sets the number of registers of every sketch to
+// 2^Precision. At most MaxKeys metric names, MaxKeys label names and MaxKeys
+// label values are tracked per bucket.
+type Analytics struct {
+	Enabled   bool          `yaml:"enabled,omitempty"`
+	Window    time.Duration `yaml:"window,omitempty"`
+	Buckets   int           `yaml:"buckets,omitempty"`
+	Precision uint8         `yaml:"precision,omitempty"`
+	MaxKeys   int           `yaml:"max-keys,omitempty"`
+	TopN      int           `yaml:"top-n,omitempty"`
+}
+
+// HistogramMode is the representation native histograms are converted to.
+type HistogramMode string
+
+const (
+	// HistogramModeSeries writes _bucket, _sum and _count series like a classic histogram.
+	HistogramModeSeries HistogramMode = "series"
+	// HistogramModeKairosDB writes a single datapoint of the KairosDB histogram type.
+	HistogramModeKairosDB HistogramMode = "kairosdb"
+)
+
 // RelabelConfig defines the metric relabeling
 type RelabelConfig struct {
 	SourceLabels model.LabelNames `yaml:"source_labels,flow,omitempty"`
@@ -77,6 +536,9 @@ func (u URL) MarshalYAML() (interface{}, error) {
 type Regexp struct {
 	*regexp.Regexp
 	original string
+	// err is the error compiling the expression while decoding, which is
+	// reported once the whole file is decoded
+	err error
 }
 
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
@@ -86,9 +548,7 @@ func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 	r, err := NewRegexp(s)
-	if err != nil {
-		return err
-	}
+	r.err = err
 	*re = r
 	return nil
 }
@@ -143,7 +603,7 @@ func ParseCfgFile(cfgFile string) (*Config, error) {
 		return nil, fmt.Errorf("no config file provided")
 	}
 
-	filename, err := getAbsFilename(cfgFile)
+	filename, err := AbsFilename(cfgFile)
 
 	if err != nil {
 		return nil, err
@@ -160,68 +620,560 @@ func ParseCfgFile(cfgFile string) (*Config, error) {
 		return nil, err
 	}
 
+	var regexErr error
+	walkRegexps(reflect.ValueOf(cfg), "", func(path string, re Regexp) {
+		if regexErr == nil {
+			regexErr = re.err
+		}
+	})
+	if regexErr != nil {
+		return nil, regexErr
+	}
+
+	for _, section := range sections {
+		if err := section.validate(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// sections validates the sections of the config by their key, in order, and
+// sets their defaults
+var sections = []struct {
+	key      string
+	validate func(cfg *Config) error
+}{
+	{"kairosdb-url", validateKairosdbURL},
+	{"server", validateServer},
+	{"metricname-prefix", addMetricnamePrefix},
+	{"metric_relabel_configs", func(cfg *Config) error { return validateMetricRelabelConfigs(cfg.MetricRelabelConfigs) }},
+	{"native-histograms", validateNativeHistograms},
+	{"metadata", validateMetadata},
+	{"exemplars", validateExemplars},
+	{"counter-rates", func(cfg *Config) error { return validateCounterRates(&cfg.CounterRates) }},
+	{"counter-resets", func(cfg *Config) error { return validateCounterResets(&cfg.CounterResets) }},
+	{"aggregations", func(cfg *Config) error { return validateAggregations(&cfg.Aggregations) }},
+	{"collisions", func(cfg *Config) error { return validateCollisions(&cfg.Collisions) }},
+	{"downsampling", func(cfg *Config) error { return validateDownsampling(&cfg.Downsampling) }},
+	{"change-only", func(cfg *Config) error { return validateChangeOnly(&cfg.ChangeOnly) }},
+	{"info-metrics", validateInfoMetrics},
+	{"value-types", func(cfg *Config) error { return validateValueTypes(&cfg.ValueTypes) }},
+	{"ttl", validateTTL},
+	{"special-values", func(cfg *Config) error { return validateSpecialValues(&cfg.SpecialValues) }},
+	{"timestamps", validateTimestamps},
+	{"sanitize", validateSanitize},
+	{"schema", func(cfg *Config) error { return validateSchema(&cfg.Schema) }},
+	{"cardinality-limits", func(cfg *Config) error { return validateCardinalityLimits(&cfg.CardinalityLimits) }},
+	{"analytics", func(cfg *Config) error { return validateAnalytics(&cfg.Analytics) }},
+	{"ha-dedup", validateHADedup},
+	{"timeout", validateTimeout},
+}
+
+func validateKairosdbURL(cfg *Config) error {
 	emptyurl := URL{}
 	if cfg.KairosdbURL == emptyurl {
-		return nil, fmt.Errorf("kairosdb-url is mandatory")
+		return fmt.Errorf("kairosdb-url is mandatory")
 	}
+	return nil
+}
 
+func validateServer(cfg *Config) error {
 	if cfg.Server.Port == "" {
 		cfg.Server.Port = defaultServerPort
 	}
+	return nil
+}
 
-	if cfg.MetricnamePrefix != "" {
-		regex, err := NewRegexp(".*")
-		if err != nil {
-			return nil, err
+// addMetricnamePrefix appends the metricname-prefix as addprefix rule to the
+// metric relabel configs
+func addMetricnamePrefix(cfg *Config) error {
+	if cfg.MetricnamePrefix == "" {
+		return nil
+	}
+
+	regex, err := NewRegexp(".*")
+	if err != nil {
+		return err
+	}
+
+	relabelConfig := &RelabelConfig{
+		SourceLabels: model.LabelNames{model.MetricNameLabel},
+		Regex:        regex,
+		Action:       RelabelAddPrefix,
+		Prefix:       cfg.MetricnamePrefix,
+	}
+
+	cfg.MetricRelabelConfigs = append(cfg.MetricRelabelConfigs, relabelConfig)
+	return nil
+}
+
+func validateMetricRelabelConfigs(metricRelabelConfigs []*RelabelConfig) error {
+	for _, c := range metricRelabelConfigs {
+		if err := validateRelabelConfig(c); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		relabelConfig := &RelabelConfig{
-			SourceLabels: model.LabelNames{model.MetricNameLabel},
-			Regex:        regex,
-			Action:       RelabelAddPrefix,
-			Prefix:       cfg.MetricnamePrefix,
+func validateRelabelConfig(c *RelabelConfig) error {
+	if c.Action == RelabelLabelDrop {
+		if c.SourceLabels != nil {
+			return fmt.Errorf("with action==labeldrop only regex is needed")
 		}
+	}
 
-		cfg.MetricRelabelConfigs = append(cfg.MetricRelabelConfigs, relabelConfig)
+	if c.Action == RelabelAddPrefix && c.Prefix == "" {
+		return fmt.Errorf("addprefix action requires prefix")
 	}
 
-	err = validateMetricRelabelConfigs(cfg.MetricRelabelConfigs)
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+func validateNativeHistograms(cfg *Config) error {
+	switch cfg.NativeHistograms.Mode {
+	case "":
+		cfg.NativeHistograms.Mode = HistogramModeSeries
+	case HistogramModeSeries, HistogramModeKairosDB:
+	default:
+		return fmt.Errorf("unknown native histogram mode %q", cfg.NativeHistograms.Mode)
+	}
+	return nil
+}
+
+func validateMetadata(cfg *Config) error {
+	if cfg.Metadata.Service == "" {
+		cfg.Metadata.Service = defaultMetadataService
+	}
+	return nil
+}
+
+func validateExemplars(cfg *Config) error {
+	if cfg.Exemplars.MetricSuffix == "" {
+		cfg.Exemplars.MetricSuffix = defaultExemplarSuffix
+	}
+	return nil
+}
+
+func validateInfoMetrics(cfg *Config) error {
+	for _, rule := range cfg.InfoMetrics.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if len(rule.ValueLabels) == 0 {
+			return fmt.Errorf("info metric rules require value-labels")
+		}
+	}
+	return nil
+}
+
+func validateTTL(cfg *Config) error {
+	for _, rule := range cfg.TTL.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if rule.TTL < model.Duration(time.Second) {
+			return fmt.Errorf("ttl rules require a ttl of at least 1s")
+		}
+		if rule.Class == "" {
+			rule.Class = rule.TTL.String()
+		}
+	}
+	return nil
+}
+
+func validateTimestamps(cfg *Config) error {
+	switch cfg.Timestamps.Action {
+	case "":
+		cfg.Timestamps.Action = TimestampReject
+	case TimestampReject, TimestampClamp, TimestampQuarantine:
+	default:
+		return fmt.Errorf("unknown timestamp action %q", cfg.Timestamps.Action)
+	}
+	if cfg.Timestamps.MaxSampleAge < 0 || cfg.Timestamps.MaxFutureSkew < 0 {
+		return fmt.Errorf("timestamps max-sample-age and max-future-skew must not be negative")
+	}
+	if cfg.Timestamps.QuarantinePrefix == "" {
+		cfg.Timestamps.QuarantinePrefix = defaultQuarantinePrefix
+	}
+	return nil
+}
+
+func validateSanitize(cfg *Config) error {
+	for _, rule := range []*SanitizeRule{&cfg.Sanitize.MetricNames, &cfg.Sanitize.TagKeys, &cfg.Sanitize.TagValues} {
+		if rule.Replacement == "" {
+			rule.Replacement = defaultReplacement
+		}
+		if rule.MaxLength < 0 || rule.MaxLength > 0 && rule.MaxLength <= len(rule.Replacement)+HashSuffixLength {
+			return fmt.Errorf("sanitize max-length must be larger than %d", len(rule.Replacement)+HashSuffixLength)
+		}
 	}
+	return nil
+}
 
+func validateHADedup(cfg *Config) error {
+	if cfg.HADedup.ClusterLabel == "" {
+		cfg.HADedup.ClusterLabel = defaultClusterLabel
+	}
+	if cfg.HADedup.ReplicaLabel == "" {
+		cfg.HADedup.ReplicaLabel = defaultReplicaLabel
+	}
+	if cfg.HADedup.FailoverTimeout == 0 {
+		cfg.HADedup.FailoverTimeout = defaultFailoverTimeout
+	}
+	return nil
+}
+
+func validateTimeout(cfg *Config) error {
 	if cfg.Timeout == 0*time.Second {
 		logrus.Infof("timeout not provided. Setting it to default value of %s", defaultTimeout)
 		cfg.Timeout = defaultTimeout
 	}
 	if cfg.Timeout > maxTimeout {
-		return nil, fmt.Errorf("timeout %d is too high. It should be between %v and %v", cfg.Timeout, minTimeout, maxTimeout)
+		return fmt.Errorf("timeout %d is too high. It should be between %v and %v", cfg.Timeout, minTimeout, maxTimeout)
 	}
 
 	if cfg.Timeout < minTimeout {
-		return nil, fmt.Errorf("timeout %d is too low. It should be between %v and %v", cfg.Timeout, minTimeout, maxTimeout)
+		return fmt.Errorf("timeout %d is too low. It should be between %v and %v", cfg.Timeout, minTimeout, maxTimeout)
 	}
+	return nil
+}
 
-	return cfg, nil
+func validateCounterRates(rates *CounterRates) error {
+	if rates.SeriesTTL == 0 {
+		rates.SeriesTTL = defaultSeriesTTL
+	}
+
+	for _, rule := range rates.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+
+		switch rule.Output {
+		case "":
+			rule.Output = RateOutputRate
+		case RateOutputRate, RateOutputIncrease, RateOutputBoth:
+		default:
+			return fmt.Errorf("unknown counter rate output %q", rule.Output)
+		}
+		if rule.RateSuffix == "" {
+			rule.RateSuffix = defaultRateSuffix
+		}
+		if rule.IncreaseSuffix == "" {
+			rule.IncreaseSuffix = defaultIncreaseSuffix
+		}
+	}
+	return nil
 }
 
-func validateMetricRelabelConfigs(metricRelabelConfigs []*RelabelConfig) error {
-	for _, c := range metricRelabelConfigs {
-		if c.Action == RelabelLabelDrop {
-			if c.SourceLabels != nil {
-				return fmt.Errorf("with action==labeldrop only regex is needed")
-			}
+func validateCounterResets(resets *CounterResets) error {
+	if resets.SeriesTTL == 0 {
+		resets.SeriesTTL = defaultSeriesTTL
+	}
+	if resets.SaveInterval == 0 {
+		resets.SaveInterval = defaultSaveInterval
+	}
+	if resets.OffsetTTL == 0 {
+		resets.OffsetTTL = defaultOffsetTTL
+	}
+	if resets.OffsetTTL < resets.SeriesTTL {
+		return fmt.Errorf("counter-resets offset-ttl must not be shorter than series-ttl")
+	}
+
+	for _, rule := range resets.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
 		}
+		if rule.Suffix == "" {
+			rule.Suffix = defaultNormalizedSuffix
+		}
+	}
+	return nil
+}
+
+func validateAggregations(aggregations *Aggregations) error {
+	if aggregations.GracePeriod == 0 {
+		aggregations.GracePeriod = defaultAggregationGracePeriod
+	}
+	if aggregations.GracePeriod < 0 {
+		return fmt.Errorf("aggregation grace-period must not be negative")
+	}
 
-		if c.Action == RelabelAddPrefix && c.Prefix == "" {
-			return fmt.Errorf("addprefix action requires prefix")
+	for _, rule := range aggregations.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if rule.Metric == "" {
+			return fmt.Errorf("aggregation rules require metric")
+		}
+
+		switch rule.Function {
+		case AggregationSum, AggregationAvg, AggregationMin, AggregationMax, AggregationCount, AggregationLast:
+		default:
+			return fmt.Errorf("unknown aggregation function %q of metric %s", rule.Function, rule.Metric)
+		}
+		if rule.Interval == 0 {
+			rule.Interval = defaultAggregationInterval
+		}
+		if rule.Interval < time.Millisecond {
+			return fmt.Errorf("aggregation interval of metric %s must be at least 1ms", rule.Metric)
+		}
+	}
+	return nil
+}
+
+func validateCollisions(collisions *Collisions) error {
+	if collisions.SeriesTTL == 0 {
+		collisions.SeriesTTL = defaultSeriesTTL
+	}
+	if collisions.Policy == "" {
+		collisions.Policy = CollisionLast
+	}
+	if err := validateCollisionPolicy(collisions.Policy); err != nil {
+		return err
+	}
+
+	for _, rule := range collisions.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if err := validateCollisionPolicy(rule.Policy); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func validateCollisionPolicy(policy CollisionPolicy) error {
+	switch policy {
+	case CollisionSum, CollisionAvg, CollisionMax, CollisionLast, CollisionReject:
+		return nil
+	default:
+		return fmt.Errorf("unknown collision policy %q", policy)
+	}
+}
 
+func validateDownsampling(downsampling *Downsampling) error {
+	if downsampling.SeriesTTL == 0 {
+		downsampling.SeriesTTL = defaultSeriesTTL
+	}
+
+	for _, rule := range downsampling.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if rule.Interval < time.Millisecond {
+			return fmt.Errorf("downsampling rules require an interval of at least 1ms")
+		}
+
+		switch rule.Function {
+		case "":
+			rule.Function = DownsamplingLast
+		case DownsamplingFirst, DownsamplingLast, DownsamplingAvg, DownsamplingMax:
+		default:
+			return fmt.Errorf("unknown downsampling function %q", rule.Function)
+		}
+	}
 	return nil
 }
 
-func getAbsFilename(cfgFile string) (string, error) {
+func validateChangeOnly(changeOnly *ChangeOnly) error {
+	if changeOnly.MaxSeries == 0 {
+		changeOnly.MaxSeries = defaultMaxSeries
+	}
+	if changeOnly.SeriesTTL == 0 {
+		changeOnly.SeriesTTL = defaultSeriesTTL
+	}
+	if changeOnly.MaxSeries < 0 {
+		return fmt.Errorf("change-only max-series must not be negative")
+	}
+
+	for _, rule := range changeOnly.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if rule.Heartbeat == 0 {
+			rule.Heartbeat = defaultHeartbeat
+		}
+	}
+	return nil
+}
+
+func validateValueTypes(types *ValueTypes) error {
+	if types.Default != "" {
+		if err := validateValueType(types.Default); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range types.Rules {
+		if err := validateMatchers(rule.Match); err != nil {
+			return err
+		}
+		if err := validateValueType(rule.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateValueType(valueType ValueType) error {
+	switch valueType {
+	case ValueTypeAuto, ValueTypeLong, ValueTypeDouble:
+		return nil
+	default:
+		return fmt.Errorf("unknown value type %q", valueType)
+	}
+}
+
+func validateSpecialValues(special *SpecialValues) error {
+	stale := &special.StaleMarkers
+	switch stale.Action {
+	case "":
+		stale.Action = StaleMarkerDrop
+	case StaleMarkerDrop, StaleMarkerDatapoint, StaleMarkerTag:
+	default:
+		return fmt.Errorf("unknown stale marker action %q", stale.Action)
+	}
+	if stale.MetricSuffix == "" {
+		stale.MetricSuffix = defaultStaleSuffix
+	}
+	if stale.Tag == "" {
+		stale.Tag = defaultStaleTag
+	}
+
+	for _, value := range []*SpecialValue{&special.NaN, &special.PositiveInf, &special.NegativeInf} {
+		switch value.Action {
+		case "":
+			value.Action = SpecialValueDrop
+		case SpecialValueDrop, SpecialValueSentinel:
+		default:
+			return fmt.Errorf("unknown special value action %q", value.Action)
+		}
+	}
+	return nil
+}
+
+func validateSchema(schema *Schema) error {
+	if schema.Mode == "" {
+		schema.Mode = SchemaPass
+	}
+	if schema.UnknownMetrics == "" {
+		schema.UnknownMetrics = SchemaPass
+	}
+	if err := validateSchemaMode(schema.Mode); err != nil {
+		return err
+	}
+	if schema.UnknownMetrics == SchemaStrip {
+		return fmt.Errorf("unknown-metrics only supports pass and drop")
+	}
+	if err := validateSchemaMode(schema.UnknownMetrics); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(schema.Metrics))
+	for _, metric := range schema.Metrics {
+		if metric.Name == "" {
+			return fmt.Errorf("schema metrics require name")
+		}
+		if names[metric.Name] {
+			return fmt.Errorf("duplicate schema of metric %s", metric.Name)
+		}
+		names[metric.Name] = true
+
+		if metric.Mode == "" {
+			metric.Mode = schema.Mode
+		}
+		if err := validateSchemaMode(metric.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateSchemaMode(mode SchemaMode) error {
+	switch mode {
+	case SchemaPass, SchemaStrip, SchemaDrop:
+		return nil
+	default:
+		return fmt.Errorf("unknown schema mode %q", mode)
+	}
+}
+
+func validateCardinalityLimits(limits *CardinalityLimits) error {
+	if limits.MaxSeries == 0 {
+		limits.MaxSeries = defaultMaxSeries
+	}
+	if limits.SeriesTTL == 0 {
+		limits.SeriesTTL = defaultSeriesTTL
+	}
+	if limits.TopOffenders == 0 {
+		limits.TopOffenders = defaultTopOffenders
+	}
+	if limits.MaxSeries < 0 || limits.MaxSeriesPerMetric < 0 || limits.TopOffenders < 0 {
+		return fmt.Errorf("cardinality limits must not be negative")
+	}
+	for name, maxSeries := range limits.Metrics {
+		if maxSeries < 0 {
+			return fmt.Errorf("cardinality limit of metric %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+func validateAnalytics(analytics *Analytics) error {
+	if analytics.Window == 0 {
+		analytics.Window = defaultAnalyticsWindow
+	}
+	if analytics.Buckets == 0 {
+		analytics.Buckets = defaultAnalyticsBuckets
+	}
+	if analytics.Precision == 0 {
+		analytics.Precision = defaultAnalyticsPrecision
+	}
+	if analytics.MaxKeys == 0 {
+		analytics.MaxKeys = defaultAnalyticsMaxKeys
+	}
+	if analytics.TopN == 0 {
+		analytics.TopN = defaultTopOffenders
+	}
+
+	if analytics.Buckets < 0 || analytics.Window/time.Duration(analytics.Buckets) < time.Second {
+		return fmt.Errorf("analytics buckets must be positive and at least 1s long")
+	}
+	if analytics.Precision < 4 || analytics.Precision > 16 {
+		return fmt.Errorf("analytics precision must be between 4 and 16")
+	}
+	if analytics.MaxKeys < 0 || analytics.TopN < 0 {
+		return fmt.Errorf("analytics max-keys and top-n must not be negative")
+	}
+	return nil
+}
+
+// validateMatchers checks relabel configs used to select series. Only keep
+// and drop are allowed, keep being the default.
+func validateMatchers(matchers []*RelabelConfig) error {
+	for _, m := range matchers {
+		if m.Action == "" {
+			m.Action = RelabelKeep
+		}
+		if m.Action != RelabelKeep && m.Action != RelabelDrop {
+			return fmt.Errorf("matchers only support keep and drop actions, got %q", m.Action)
+		}
+		if m.Regex.Regexp == nil && m.Regex.err == nil {
+			return fmt.Errorf("matchers require regex")
+		}
+	}
+	return nil
+}
+
+// AbsFilename returns the path of the config file ParseCfgFile reads: the
+// file itself, or the file relative to the directory of the executable or
+// to the home directory
+func AbsFilename(cfgFile string) (string, error) {
 	cwd, err := getCurrentWorkingDirectory()
 	if err != nil {
 		return "", err
@@ -268,3 +1220,55 @@ func ValidateFile(cfgFile string) (bool, error) {
 
 	return true, nil
 }
+
+// walkRegexps calls visit with the YAML path of every regex of the value
+// which failed to compile
+func walkRegexps(v reflect.Value, path string, visit func(path string, re Regexp)) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			walkRegexps(v.Elem(), path, visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkRegexps(v.Index(i), fmt.Sprintf("%s[%d]", path, i), visit)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			walkRegexps(v.MapIndex(key), fmt.Sprintf("%s.%v", path, key.Interface()), visit)
+		}
+	case reflect.Struct:
+		if re, ok := v.Interface().(Regexp); ok {
+			if re.err != nil {
+				visit(path, re)
+			}
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			walkRegexps(v.Field(i), name, visit)
+		}
+	}
+}
+
+// Equal tells if two configs, or sections of configs, are the same. They are
+// compared by their YAML, as compiled regexes can't be compared.
+func Equal(a, b interface{}) bool {
+	bufA, errA := yaml.Marshal(a)
+	bufB, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(bufA, bufB)
+}
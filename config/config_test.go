@@ -3,6 +3,8 @@ package config
 import (
 	"errors"
 	"github.com/prometheus/common/model"
+	"math"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -14,11 +16,423 @@ func TestParseCfgFile(t *testing.T) {
 		err      error
 		mrc      []*RelabelConfig
 		timeout  time.Duration
+		histMode HistogramMode
+		metadata *Metadata
+		rates    *CounterRates
+		resets   *CounterResets
+		aggs     *Aggregations
+		coll     *Collisions
+		ha       *HADedup
+		down     *Downsampling
+		change   *ChangeOnly
+		info     *InfoMetrics
+		types    *ValueTypes
+		ttl      *TTLs
+		special  *SpecialValues
+		ts       *Timestamps
+		sanitize *Sanitize
+		schema   *Schema
+		limits   *CardinalityLimits
+		stats    *Analytics
 	}{
 		{
 			name:     "valid yaml file",
 			fileName: "testdata/valid.yaml",
 		},
+		{
+			name:     "valid yaml with default native histogram mode",
+			fileName: "testdata/valid.yaml",
+			histMode: HistogramModeSeries,
+		},
+		{
+			name:     "valid yaml with native histogram mode",
+			fileName: "testdata/native_histograms.yaml",
+			histMode: HistogramModeKairosDB,
+		},
+		{
+			name:     "invalid native histogram mode",
+			fileName: "testdata/invalid_histogram_mode.yaml",
+			err:      errors.New(`unknown native histogram mode "summary"`),
+		},
+		{
+			name:     "metadata with default service",
+			fileName: "testdata/metadata.yaml",
+			metadata: &Metadata{Enabled: true, Service: "prometheus", TypeTag: "metric_type"},
+		},
+		{
+			name:     "counter rates with defaults",
+			fileName: "testdata/counter_rates.yaml",
+			rates: &CounterRates{
+				SeriesTTL: 5 * time.Minute,
+				Rules: []*RateRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp(".*_total"),
+								Action:       RelabelKeep,
+							},
+						},
+						Output:         RateOutputBoth,
+						RateSuffix:     "_rate",
+						IncreaseSuffix: "_increase",
+					},
+				},
+			},
+		},
+		{
+			name:     "counter rates with labeldrop matcher",
+			fileName: "testdata/counter_rates_invalid_matcher.yaml",
+			err:      errors.New(`matchers only support keep and drop actions, got "labeldrop"`),
+		},
+		{
+			name:     "counter resets with defaults",
+			fileName: "testdata/counter_resets.yaml",
+			resets: &CounterResets{
+				StateFile:    "/var/lib/prom-to-kairosdb/counters.json",
+				SaveInterval: 10 * time.Second,
+				SeriesTTL:    15 * time.Minute,
+				OffsetTTL:    7 * 24 * time.Hour,
+				Rules: []*NormalizeRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp(".*_total"),
+								Action:       RelabelKeep,
+							},
+						},
+						Suffix: "_normalized",
+					},
+				},
+			},
+		},
+		{
+			name:     "counter resets offset ttl shorter than series ttl",
+			fileName: "testdata/counter_resets_short_offset_ttl.yaml",
+			err:      errors.New("counter-resets offset-ttl must not be shorter than series-ttl"),
+		},
+		{
+			name:     "aggregations with default interval",
+			fileName: "testdata/aggregations.yaml",
+			aggs: &Aggregations{
+				GracePeriod: 30 * time.Second,
+				Rules: []*AggregationRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp("container_cpu_usage_seconds_total"),
+								Action:       RelabelKeep,
+							},
+						},
+						Metric:     "namespace_job:container_cpu_usage_seconds_total:sum",
+						Function:   AggregationSum,
+						By:         model.LabelNames{"namespace", "job"},
+						Interval:   time.Minute,
+						DropInputs: true,
+					},
+				},
+			},
+		},
+		{
+			name:     "aggregation interval below 1ms",
+			fileName: "testdata/aggregation_short_interval.yaml",
+			err:      errors.New("aggregation interval of metric namespace:cpu:sum must be at least 1ms"),
+		},
+		{
+			name:     "unknown aggregation function",
+			fileName: "testdata/invalid_aggregation_function.yaml",
+			err:      errors.New(`unknown aggregation function "rate" of metric namespace:container_cpu_usage_seconds_total:rate`),
+		},
+		{
+			name:     "collisions with default policy",
+			fileName: "testdata/collisions.yaml",
+			coll: &Collisions{
+				Enabled:   true,
+				Policy:    CollisionLast,
+				SeriesTTL: 15 * time.Minute,
+				Rules: []*CollisionRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp("container_.*"),
+								Action:       RelabelKeep,
+							},
+						},
+						Policy: CollisionSum,
+					},
+				},
+			},
+		},
+		{
+			name:     "unknown collision policy",
+			fileName: "testdata/invalid_collision_policy.yaml",
+			err:      errors.New(`unknown collision policy "min"`),
+		},
+		{
+			name:     "ha dedup with defaults",
+			fileName: "testdata/ha_dedup.yaml",
+			ha: &HADedup{
+				Enabled:         true,
+				ClusterLabel:    "cluster",
+				ReplicaLabel:    "__replica__",
+				FailoverTimeout: 30 * time.Second,
+			},
+		},
+		{
+			name:     "downsampling with default function",
+			fileName: "testdata/downsampling.yaml",
+			down: &Downsampling{
+				SeriesTTL: 15 * time.Minute,
+				Rules: []*DownsamplingRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{"job"},
+								Regex:        MustNewRegexp("node"),
+								Action:       RelabelKeep,
+							},
+						},
+						Interval: time.Minute,
+						Function: DownsamplingLast,
+					},
+				},
+			},
+		},
+		{
+			name:     "downsampling without interval",
+			fileName: "testdata/downsampling_no_interval.yaml",
+			err:      errors.New("downsampling rules require an interval of at least 1ms"),
+		},
+		{
+			name:     "downsampling interval below 1ms",
+			fileName: "testdata/downsampling_short_interval.yaml",
+			err:      errors.New("downsampling rules require an interval of at least 1ms"),
+		},
+		{
+			name:     "negative change-only max-series",
+			fileName: "testdata/change_only_negative_max_series.yaml",
+			err:      errors.New("change-only max-series must not be negative"),
+		},
+		{
+			name:     "change only with default heartbeat",
+			fileName: "testdata/change_only.yaml",
+			change: &ChangeOnly{
+				MaxSeries: 1000,
+				SeriesTTL: 15 * time.Minute,
+				Rules: []*ChangeRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp("up|.*_info"),
+								Action:       RelabelKeep,
+							},
+						},
+						Heartbeat: 5 * time.Minute,
+					},
+				},
+			},
+		},
+		{
+			name:     "info metrics",
+			fileName: "testdata/info_metrics.yaml",
+			info: &InfoMetrics{
+				Rules: []*InfoRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp("kube_pod_info"),
+								Action:       RelabelKeep,
+							},
+						},
+						ValueLabels:       model.LabelNames{"node", "pod_ip"},
+						IdentifyingLabels: model.LabelNames{"namespace", "pod"},
+					},
+				},
+			},
+		},
+		{
+			name:     "info metrics without value labels",
+			fileName: "testdata/info_metrics_no_value_labels.yaml",
+			err:      errors.New("info metric rules require value-labels"),
+		},
+		{
+			name:     "value types",
+			fileName: "testdata/value_types.yaml",
+			types: &ValueTypes{
+				Default: ValueTypeAuto,
+				Rules: []*ValueTypeRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp(".*_seconds.*"),
+								Action:       RelabelKeep,
+							},
+						},
+						Type: ValueTypeDouble,
+					},
+				},
+			},
+		},
+		{
+			name:     "value type rule without type",
+			fileName: "testdata/invalid_value_type.yaml",
+			err:      errors.New(`unknown value type ""`),
+		},
+		{
+			name:     "ttl rules with default class",
+			fileName: "testdata/ttl.yaml",
+			ttl: &TTLs{
+				Default: model.Duration(90 * 24 * time.Hour),
+				Rules: []*TTLRule{
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp("debug_.*"),
+								Action:       RelabelKeep,
+							},
+						},
+						TTL:   model.Duration(7 * 24 * time.Hour),
+						Class: "debug",
+					},
+					{
+						Match: []*RelabelConfig{
+							{
+								SourceLabels: model.LabelNames{model.MetricNameLabel},
+								Regex:        MustNewRegexp("slo_.*"),
+								Action:       RelabelKeep,
+							},
+						},
+						TTL:   model.Duration(400 * 24 * time.Hour),
+						Class: "400d",
+					},
+				},
+			},
+		},
+		{
+			name:     "ttl rule without ttl",
+			fileName: "testdata/ttl_no_ttl.yaml",
+			err:      errors.New("ttl rules require a ttl of at least 1s"),
+		},
+		{
+			name:     "special values with defaults",
+			fileName: "testdata/special_values.yaml",
+			special: &SpecialValues{
+				StaleMarkers: StaleMarkers{Action: StaleMarkerTag, MetricSuffix: "_stale", Tag: "stale"},
+				NaN:          SpecialValue{Action: SpecialValueDrop},
+				PositiveInf:  SpecialValue{Action: SpecialValueSentinel, Sentinel: math.MaxFloat64},
+				NegativeInf:  SpecialValue{Action: SpecialValueDrop},
+			},
+		},
+		{
+			name:     "unknown stale marker action",
+			fileName: "testdata/invalid_stale_marker_action.yaml",
+			err:      errors.New(`unknown stale marker action "keep"`),
+		},
+		{
+			name:     "timestamps with default prefix",
+			fileName: "testdata/timestamps.yaml",
+			ts: &Timestamps{
+				MaxSampleAge:     24 * time.Hour,
+				MaxFutureSkew:    10 * time.Minute,
+				Action:           TimestampQuarantine,
+				QuarantinePrefix: "quarantine.",
+			},
+		},
+		{
+			name:     "unknown timestamp action",
+			fileName: "testdata/invalid_timestamp_action.yaml",
+			err:      errors.New(`unknown timestamp action "drop"`),
+		},
+		{
+			name:     "negative max-future-skew",
+			fileName: "testdata/negative_max_future_skew.yaml",
+			err:      errors.New("timestamps max-sample-age and max-future-skew must not be negative"),
+		},
+		{
+			name:     "sanitize with default replacement",
+			fileName: "testdata/sanitize.yaml",
+			sanitize: &Sanitize{
+				MetricNames: SanitizeRule{InvalidChars: MustNewRegexp("[^A-Za-z0-9._/-]"), Replacement: "_"},
+				TagKeys:     SanitizeRule{Replacement: "_"},
+				TagValues:   SanitizeRule{InvalidChars: MustNewRegexp(`\s`), Replacement: "-", MaxLength: 256},
+			},
+		},
+		{
+			name:     "sanitize max length too short for the hash",
+			fileName: "testdata/sanitize_short_max_length.yaml",
+			err:      errors.New("sanitize max-length must be larger than 9"),
+		},
+		{
+			name:     "schema with default modes",
+			fileName: "testdata/schema.yaml",
+			schema: &Schema{
+				Mode:           SchemaStrip,
+				UnknownMetrics: SchemaPass,
+				Metrics: []*MetricSchema{
+					{
+						Name: "http_requests_total",
+						Mode: SchemaStrip,
+						Tags: map[string]Regexp{"job": {}, "code": MustNewRegexp("^[1-5][0-9][0-9]$")},
+					},
+					{
+						Name: "up",
+						Mode: SchemaDrop,
+						Tags: map[string]Regexp{"job": {}, "instance": {}},
+					},
+				},
+			},
+		},
+		{
+			name:     "unknown schema mode",
+			fileName: "testdata/invalid_schema_mode.yaml",
+			err:      errors.New(`unknown schema mode "keep"`),
+		},
+		{
+			name:     "duplicate metric schema",
+			fileName: "testdata/schema_duplicate_metric.yaml",
+			err:      errors.New("duplicate schema of metric up"),
+		},
+		{
+			name:     "cardinality limits with defaults",
+			fileName: "testdata/cardinality_limits.yaml",
+			limits: &CardinalityLimits{
+				Enabled:            true,
+				MaxSeries:          defaultMaxSeries,
+				MaxSeriesPerMetric: 10000,
+				Metrics:            map[string]int{"http_requests_total": 50000},
+				SeriesTTL:          defaultSeriesTTL,
+				TopOffenders:       defaultTopOffenders,
+			},
+		},
+		{
+			name:     "negative cardinality limit",
+			fileName: "testdata/cardinality_limits_negative.yaml",
+			err:      errors.New("cardinality limit of metric up must not be negative"),
+		},
+		{
+			name:     "analytics with defaults",
+			fileName: "testdata/analytics.yaml",
+			stats: &Analytics{
+				Enabled:   true,
+				Window:    time.Hour,
+				Buckets:   defaultAnalyticsBuckets,
+				Precision: defaultAnalyticsPrecision,
+				MaxKeys:   defaultAnalyticsMaxKeys,
+				TopN:      defaultTopOffenders,
+			},
+		},
+		{
+			name:     "analytics precision out of range",
+			fileName: "testdata/analytics_invalid_precision.yaml",
+			err:      errors.New("analytics precision must be between 4 and 16"),
+		},
 		{
 			name:     "valid yaml with default timeout",
 			fileName: "testdata/default_timeout.yaml",
@@ -141,5 +555,77 @@ func TestParseCfgFile(t *testing.T) {
 			t.Errorf("case '%s'. Expected timeout: %v, got %v", c.name, c.timeout, cfg.Timeout)
 		}
 
+		if c.histMode != "" && c.histMode != cfg.NativeHistograms.Mode {
+			t.Errorf("case '%s'. Expected native histogram mode: %v, got %v", c.name, c.histMode, cfg.NativeHistograms.Mode)
+		}
+
+		if c.rates != nil && !reflect.DeepEqual(*c.rates, cfg.CounterRates) {
+			t.Errorf("case '%s'. Expected counter rates: %+v, got %+v", c.name, *c.rates, cfg.CounterRates)
+		}
+
+		if c.resets != nil && !reflect.DeepEqual(*c.resets, cfg.CounterResets) {
+			t.Errorf("case '%s'. Expected counter resets: %+v, got %+v", c.name, *c.resets, cfg.CounterResets)
+		}
+
+		if c.aggs != nil && !reflect.DeepEqual(*c.aggs, cfg.Aggregations) {
+			t.Errorf("case '%s'. Expected aggregations: %+v, got %+v", c.name, *c.aggs, cfg.Aggregations)
+		}
+
+		if c.coll != nil && !reflect.DeepEqual(*c.coll, cfg.Collisions) {
+			t.Errorf("case '%s'. Expected collisions: %+v, got %+v", c.name, *c.coll, cfg.Collisions)
+		}
+
+		if c.ha != nil && *c.ha != cfg.HADedup {
+			t.Errorf("case '%s'. Expected ha dedup: %+v, got %+v", c.name, *c.ha, cfg.HADedup)
+		}
+
+		if c.down != nil && !reflect.DeepEqual(*c.down, cfg.Downsampling) {
+			t.Errorf("case '%s'. Expected downsampling: %+v, got %+v", c.name, *c.down, cfg.Downsampling)
+		}
+
+		if c.change != nil && !reflect.DeepEqual(*c.change, cfg.ChangeOnly) {
+			t.Errorf("case '%s'. Expected change only: %+v, got %+v", c.name, *c.change, cfg.ChangeOnly)
+		}
+
+		if c.info != nil && !reflect.DeepEqual(*c.info, cfg.InfoMetrics) {
+			t.Errorf("case '%s'. Expected info metrics: %+v, got %+v", c.name, *c.info, cfg.InfoMetrics)
+		}
+
+		if c.types != nil && !reflect.DeepEqual(*c.types, cfg.ValueTypes) {
+			t.Errorf("case '%s'. Expected value types: %+v, got %+v", c.name, *c.types, cfg.ValueTypes)
+		}
+
+		if c.ttl != nil && !reflect.DeepEqual(*c.ttl, cfg.TTL) {
+			t.Errorf("case '%s'. Expected ttl: %+v, got %+v", c.name, *c.ttl, cfg.TTL)
+		}
+
+		if c.special != nil && *c.special != cfg.SpecialValues {
+			t.Errorf("case '%s'. Expected special values: %+v, got %+v", c.name, *c.special, cfg.SpecialValues)
+		}
+
+		if c.ts != nil && *c.ts != cfg.Timestamps {
+			t.Errorf("case '%s'. Expected timestamps: %+v, got %+v", c.name, *c.ts, cfg.Timestamps)
+		}
+
+		if c.sanitize != nil && !reflect.DeepEqual(*c.sanitize, cfg.Sanitize) {
+			t.Errorf("case '%s'. Expected sanitize: %+v, got %+v", c.name, *c.sanitize, cfg.Sanitize)
+		}
+
+		if c.schema != nil && !reflect.DeepEqual(*c.schema, cfg.Schema) {
+			t.Errorf("case '%s'. Expected schema: %+v, got %+v", c.name, *c.schema, cfg.Schema)
+		}
+
+		if c.limits != nil && !reflect.DeepEqual(*c.limits, cfg.CardinalityLimits) {
+			t.Errorf("case '%s'. Expected cardinality limits: %+v, got %+v", c.name, *c.limits, cfg.CardinalityLimits)
+		}
+
+		if c.stats != nil && *c.stats != cfg.Analytics {
+			t.Errorf("case '%s'. Expected analytics: %+v, got %+v", c.name, *c.stats, cfg.Analytics)
+		}
+
+		if c.metadata != nil && *c.metadata != cfg.Metadata {
+			t.Errorf("case '%s'. Expected metadata: %+v, got %+v", c.name, *c.metadata, cfg.Metadata)
+		}
+
 	}
 }
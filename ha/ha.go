@@ -0,0 +1,136 @@
+package ha
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+)
+
+var (
+	electedReplica = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ha_elected_replica",
+			Help: "The replica elected per HA cluster, set to 1 for the elected replica.",
+		},
+		[]string{"cluster", "replica"},
+	)
+	failovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ha_failovers_total",
+			Help: "Total number of failovers to another replica per HA cluster.",
+		},
+		[]string{"cluster"},
+	)
+	dedupedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ha_deduped_samples_total",
+			Help: "Total number of samples and histograms dropped because they were sent by a replica which is not elected.",
+		},
+		[]string{"cluster", "replica"},
+	)
+)
+
+func RegisterPrometheusMetrics() {
+	prometheus.MustRegister(electedReplica)
+	prometheus.MustRegister(failovers)
+	prometheus.MustRegister(dedupedSamples)
+}
+
+// Tracker elects one replica per cluster of Prometheus HA pairs, like the
+// HA tracker of Cortex. The cluster and replica are taken from the labels of
+// the first series of a request, as they are external labels of Prometheus.
+type Tracker struct {
+	mtx     sync.Mutex
+	cfg     config.HADedup
+	elected map[string]*replica
+	now     func() time.Time
+}
+
+type replica struct {
+	name     string
+	lastSeen time.Time
+}
+
+// NewTracker returns a Tracker for the configured labels and failover timeout
+func NewTracker(cfg config.HADedup) *Tracker {
+	return &Tracker{
+		cfg:     cfg,
+		elected: make(map[string]*replica),
+		now:     time.Now,
+	}
+}
+
+// Dedup tells if the request is written. The replica label is removed from
+// the series of accepted requests. Requests without cluster and replica
+// label are always accepted.
+func (t *Tracker) Dedup(req *remote.WriteRequest) bool {
+	if len(req.Timeseries) == 0 {
+		return true
+	}
+
+	var cluster, name string
+	for _, l := range req.Timeseries[0].Labels {
+		switch l.Name {
+		case t.cfg.ClusterLabel:
+			cluster = l.Value
+		case t.cfg.ReplicaLabel:
+			name = l.Value
+		}
+	}
+	if cluster == "" || name == "" {
+		return true
+	}
+
+	if !t.accept(cluster, name) {
+		var samples int
+		for _, ts := range req.Timeseries {
+			samples += len(ts.Samples) + len(ts.Histograms)
+		}
+		dedupedSamples.WithLabelValues(cluster, name).Add(float64(samples))
+		return false
+	}
+
+	for i := range req.Timeseries {
+		req.Timeseries[i].Labels = removeLabel(req.Timeseries[i].Labels, t.cfg.ReplicaLabel)
+	}
+	return true
+}
+
+func (t *Tracker) accept(cluster, name string) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := t.now()
+	elected, ok := t.elected[cluster]
+	switch {
+	case ok && elected.name == name:
+		elected.lastSeen = now
+		return true
+	case ok && now.Sub(elected.lastSeen) <= t.cfg.FailoverTimeout:
+		return false
+	case ok:
+		logrus.Infof("failing over cluster [%s] from replica [%s] to [%s]", cluster, elected.name, name)
+		failovers.WithLabelValues(cluster).Inc()
+		electedReplica.DeleteLabelValues(cluster, elected.name)
+	default:
+		logrus.Infof("electing replica [%s] of cluster [%s]", name, cluster)
+	}
+
+	t.elected[cluster] = &replica{name: name, lastSeen: now}
+	electedReplica.WithLabelValues(cluster, name).Set(1)
+	return true
+}
+
+func removeLabel(labels []remote.Label, name string) []remote.Label {
+	result := make([]remote.Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name != name {
+			result = append(result, l)
+		}
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+package ha
+
+import (
+	"testing"
+	"time"
+
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func request(cluster, replica string) *remote.WriteRequest {
+	labels := []remote.Label{{Name: "__name__", Value: "up"}}
+	if cluster != "" {
+		labels = append(labels, remote.Label{Name: "cluster", Value: cluster})
+	}
+	if replica != "" {
+		labels = append(labels, remote.Label{Name: "replica", Value: replica})
+	}
+	return &remote.WriteRequest{
+		Timeseries: []remote.TimeSeries{
+			{Labels: labels, Samples: []remote.Sample{{Value: 1, Timestamp: 1000}}},
+		},
+	}
+}
+
+func TestDedup(t *testing.T) {
+	type write struct {
+		after    time.Duration
+		cluster  string
+		replica  string
+		accepted bool
+	}
+
+	cases := []struct {
+		name   string
+		writes []write
+	}{
+		{
+			name: "first replica is elected",
+			writes: []write{
+				{cluster: "eu", replica: "a", accepted: true},
+				{after: time.Second, cluster: "eu", replica: "b", accepted: false},
+				{after: time.Second, cluster: "eu", replica: "a", accepted: true},
+			},
+		},
+		{
+			name: "clusters are elected separately",
+			writes: []write{
+				{cluster: "eu", replica: "a", accepted: true},
+				{cluster: "us", replica: "b", accepted: true},
+				{cluster: "us", replica: "a", accepted: false},
+			},
+		},
+		{
+			name: "failover after timeout",
+			writes: []write{
+				{cluster: "eu", replica: "a", accepted: true},
+				{after: 20 * time.Second, cluster: "eu", replica: "b", accepted: false},
+				{after: 20 * time.Second, cluster: "eu", replica: "b", accepted: true},
+				{cluster: "eu", replica: "a", accepted: false},
+			},
+		},
+		{
+			name: "requests without ha labels",
+			writes: []write{
+				{cluster: "eu", replica: "a", accepted: true},
+				{cluster: "eu", accepted: true},
+				{replica: "b", accepted: true},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		now := time.Unix(0, 0)
+		tracker := NewTracker(config.HADedup{ClusterLabel: "cluster", ReplicaLabel: "replica", FailoverTimeout: 30 * time.Second})
+		tracker.now = func() time.Time { return now }
+
+		for i, w := range c.writes {
+			now = now.Add(w.after)
+			assert.Equal(t, w.accepted, tracker.Dedup(request(w.cluster, w.replica)), "case '%s' write %d", c.name, i)
+		}
+	}
+}
+
+func TestDedupRemovesReplicaLabel(t *testing.T) {
+	tracker := NewTracker(config.HADedup{ClusterLabel: "cluster", ReplicaLabel: "replica", FailoverTimeout: 30 * time.Second})
+
+	req := request("eu", "a")
+	assert.True(t, tracker.Dedup(req))
+	assert.Equal(t, []remote.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "eu"}}, req.Timeseries[0].Labels)
+}
@@ -0,0 +1,60 @@
+package kairosdb
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/analytics"
+)
+
+// observeSamples adds the series of the samples before relabeling to the
+// analytics
+func (c *Client) observeSamples(samples model.Samples) {
+	if !c.analytics.Enabled() {
+		return
+	}
+
+	metrics := make([]model.Metric, len(samples))
+	for i, sample := range samples {
+		metrics[i] = sample.Metric
+	}
+	c.analytics.View(analytics.PreRelabel).ObserveMetrics(metrics)
+}
+
+// observeHistograms adds the series of the native histograms before
+// relabeling to the analytics
+func (c *Client) observeHistograms(histograms []*HistogramSample) {
+	if !c.analytics.Enabled() {
+		return
+	}
+
+	metrics := make([]model.Metric, len(histograms))
+	for i, hs := range histograms {
+		metrics[i] = hs.Metric
+	}
+	c.analytics.View(analytics.PreRelabel).ObserveMetrics(metrics)
+}
+
+// observePostRelabel adds the series of the datapoints to the analytics
+func (c *Client) observePostRelabel(datapoints []*DataPoint) {
+	if !c.analytics.Enabled() {
+		return
+	}
+
+	series := make([]analytics.Series, len(datapoints))
+	for i, datapoint := range datapoints {
+		series[i] = analytics.Series{
+			Name:        datapoint.Name,
+			Tags:        datapoint.Tags,
+			Fingerprint: datapointFingerprint(datapoint),
+		}
+	}
+	c.analytics.View(analytics.PostRelabel).ObserveSeries(series)
+}
+
+// Analytics returns the estimates of the series before and after relabeling,
+// nil if analytics are not enabled
+func (c *Client) Analytics() *analytics.Analytics {
+	if !c.analytics.Enabled() {
+		return nil
+	}
+	return c.analytics
+}
@@ -0,0 +1,313 @@
+package kairosdb
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+const (
+	globalLimit = "global"
+	metricLimit = "metric"
+
+	// maxEvictionInterval bounds how often inactive series are looked for,
+	// as it takes a pass over all active series
+	maxEvictionInterval = time.Minute
+)
+
+var (
+	cardinalityActiveSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cardinality_active_series",
+			Help: "Number of active KairosDB series tracked by the cardinality limits.",
+		},
+		[]string{"remote"},
+	)
+	cardinalityTopSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cardinality_top_offender_series",
+			Help: "Number of active KairosDB series of the metrics with the most series.",
+		},
+		[]string{"remote", "metric"},
+	)
+	cardinalityTopLimitedSamples = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cardinality_top_limited_samples",
+			Help: "Number of samples of new series dropped by the cardinality limits since the metric had active series, of the metrics with the most dropped samples.",
+		},
+		[]string{"remote", "metric"},
+	)
+	cardinalityLimitedSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cardinality_limited_samples_total",
+			Help: "Total number of samples of new series dropped by the global or per metric cardinality limit.",
+		},
+		[]string{"remote", "limit"},
+	)
+)
+
+// CardinalityReport is the state of the cardinality limits
+type CardinalityReport struct {
+	ActiveSeries int                 `json:"active_series"`
+	MaxSeries    int                 `json:"max_series"`
+	TopOffenders []MetricCardinality `json:"top_offenders"`
+}
+
+// MetricCardinality is the number of active series of a metric and the
+// number of samples dropped since it had active series, an upper bound for
+// metrics without active series
+type MetricCardinality struct {
+	Metric         string `json:"metric"`
+	ActiveSeries   int    `json:"active_series"`
+	MaxSeries      int    `json:"max_series,omitempty"`
+	LimitedSamples int    `json:"limited_samples"`
+}
+
+// cardinalityLimiter tracks the active series by the hash of their name and
+// tags. Its memory is bounded by the global limit: the metrics with active
+// series are bounded by it, the metrics with only limited samples by the
+// number of top offenders.
+type cardinalityLimiter struct {
+	mtx          sync.Mutex
+	cfg          config.CardinalityLimits
+	series       map[uint64]*limitedSeries
+	metrics      map[string]*MetricCardinality
+	limited      map[string]*MetricCardinality
+	lastEviction time.Time
+	now          func() time.Time
+}
+
+type limitedSeries struct {
+	metric   *MetricCardinality
+	lastSeen time.Time
+}
+
+func newCardinalityLimiter(cfg config.CardinalityLimits) *cardinalityLimiter {
+	return &cardinalityLimiter{
+		cfg:     cfg,
+		series:  make(map[uint64]*limitedSeries),
+		metrics: make(map[string]*MetricCardinality),
+		limited: make(map[string]*MetricCardinality),
+		now:     time.Now,
+	}
+}
+
+// limit returns the datapoints of active series and of new series within the
+// limits
+func (l *cardinalityLimiter) limit(datapoints []*DataPoint, remote string) []*DataPoint {
+	if !l.cfg.Enabled {
+		return datapoints
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	l.evict(now, remote)
+
+	result := datapoints[:0]
+	for _, datapoint := range datapoints {
+		fp := datapointFingerprint(datapoint)
+		if series, ok := l.series[fp]; ok {
+			series.lastSeen = now
+			result = append(result, datapoint)
+			continue
+		}
+
+		metric, ok := l.metrics[datapoint.Name]
+		if !ok {
+			metric, ok = l.limited[datapoint.Name]
+		}
+		if !ok {
+			metric = &MetricCardinality{Metric: datapoint.Name, MaxSeries: l.maxSeries(datapoint.Name)}
+		}
+
+		limit := ""
+		switch {
+		case len(l.series) >= l.cfg.MaxSeries:
+			limit = globalLimit
+		case metric.MaxSeries > 0 && metric.ActiveSeries >= metric.MaxSeries:
+			limit = metricLimit
+		}
+		if limit != "" {
+			if metric.LimitedSamples == 0 {
+				logrus.Warnf("dropping new series of metric [%s] over the %s cardinality limit", datapoint.Name, limit)
+			}
+			metric.LimitedSamples++
+			if metric.ActiveSeries == 0 {
+				l.trackLimited(metric)
+			}
+			count(cardinalityLimitedSamples, remote, limit)
+			continue
+		}
+
+		if metric.ActiveSeries == 0 {
+			delete(l.limited, datapoint.Name)
+			l.metrics[datapoint.Name] = metric
+		}
+		metric.ActiveSeries++
+		l.series[fp] = &limitedSeries{metric: metric, lastSeen: now}
+		result = append(result, datapoint)
+	}
+
+	cardinalityActiveSeries.WithLabelValues(remote).Set(float64(len(l.series)))
+	return result
+}
+
+func (l *cardinalityLimiter) maxSeries(name string) int {
+	if maxSeries, ok := l.cfg.Metrics[name]; ok {
+		return maxSeries
+	}
+	return l.cfg.MaxSeriesPerMetric
+}
+
+// evict removes the series idle for longer than the series TTL and updates
+// the top offenders
+func (l *cardinalityLimiter) evict(now time.Time, remote string) {
+	interval := l.cfg.SeriesTTL
+	if interval > maxEvictionInterval {
+		interval = maxEvictionInterval
+	}
+	if now.Sub(l.lastEviction) < interval {
+		return
+	}
+	l.lastEviction = now
+
+	for fp, series := range l.series {
+		if now.Sub(series.lastSeen) > l.cfg.SeriesTTL {
+			series.metric.ActiveSeries--
+			delete(l.series, fp)
+		}
+	}
+	for name, metric := range l.metrics {
+		if metric.ActiveSeries == 0 {
+			delete(l.metrics, name)
+			if metric.LimitedSamples > 0 {
+				l.trackLimited(metric)
+			}
+		}
+	}
+
+	cardinalityTopSeries.Reset()
+	for _, metric := range l.topOffenders(l.cfg.TopOffenders) {
+		cardinalityTopSeries.WithLabelValues(remote, metric.Metric).Set(float64(metric.ActiveSeries))
+	}
+	cardinalityTopLimitedSamples.Reset()
+	for _, metric := range l.mostLimited(l.cfg.TopOffenders) {
+		cardinalityTopLimitedSamples.WithLabelValues(remote, metric.Metric).Set(float64(metric.LimitedSamples))
+	}
+}
+
+// trackLimited keeps a metric without active series among the limited
+// metrics, at most as many as top offenders are reported. When they are
+// full, the metric with the fewest limited samples is replaced and the new
+// metric takes over its count, like in the space saving algorithm, so the
+// counts of these metrics are upper bounds.
+func (l *cardinalityLimiter) trackLimited(metric *MetricCardinality) {
+	if _, ok := l.limited[metric.Metric]; ok {
+		return
+	}
+	if len(l.limited) < l.cfg.TopOffenders {
+		l.limited[metric.Metric] = metric
+		return
+	}
+
+	var min *MetricCardinality
+	for _, limited := range l.limited {
+		if min == nil || limited.LimitedSamples < min.LimitedSamples {
+			min = limited
+		}
+	}
+	if min == nil {
+		return
+	}
+	delete(l.limited, min.Metric)
+	if metric.LimitedSamples <= min.LimitedSamples {
+		metric.LimitedSamples = min.LimitedSamples + 1
+	}
+	l.limited[metric.Metric] = metric
+}
+
+// topOffenders returns the n metrics with the most active series, then the
+// most limited samples
+func (l *cardinalityLimiter) topOffenders(n int) []MetricCardinality {
+	return l.top(n, func(a, b *MetricCardinality) bool {
+		if a.ActiveSeries != b.ActiveSeries {
+			return a.ActiveSeries > b.ActiveSeries
+		}
+		return a.LimitedSamples > b.LimitedSamples
+	})
+}
+
+// mostLimited returns the n metrics with limited samples with the most
+// limited samples
+func (l *cardinalityLimiter) mostLimited(n int) []MetricCardinality {
+	metrics := l.top(n, func(a, b *MetricCardinality) bool {
+		return a.LimitedSamples > b.LimitedSamples
+	})
+	for i, metric := range metrics {
+		if metric.LimitedSamples == 0 {
+			return metrics[:i]
+		}
+	}
+	return metrics
+}
+
+// top returns the n first metrics ordered by before, then by name
+func (l *cardinalityLimiter) top(n int, before func(a, b *MetricCardinality) bool) []MetricCardinality {
+	metrics := make([]MetricCardinality, 0, len(l.metrics)+len(l.limited))
+	for _, metric := range l.metrics {
+		metrics = append(metrics, *metric)
+	}
+	for _, metric := range l.limited {
+		metrics = append(metrics, *metric)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if before(&metrics[i], &metrics[j]) {
+			return true
+		}
+		if before(&metrics[j], &metrics[i]) {
+			return false
+		}
+		return metrics[i].Metric < metrics[j].Metric
+	})
+	if len(metrics) > n {
+		metrics = metrics[:n]
+	}
+	return metrics
+}
+
+func (l *cardinalityLimiter) report(n int) CardinalityReport {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return CardinalityReport{
+		ActiveSeries: len(l.series),
+		MaxSeries:    l.cfg.MaxSeries,
+		TopOffenders: l.topOffenders(n),
+	}
+}
+
+// datapointFingerprint hashes the name and the tags of the datapoint
+func datapointFingerprint(datapoint *DataPoint) uint64 {
+	keys := make([]string, 0, len(datapoint.Tags))
+	for key := range datapoint.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	h.Write([]byte(datapoint.Name))
+	for _, key := range keys {
+		h.Write([]byte{0xff})
+		h.Write([]byte(key))
+		h.Write([]byte{0xff})
+		h.Write([]byte(datapoint.Tags[key]))
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,104 @@
+package kairosdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newCardinalityLimiter(config.CardinalityLimits{
+		Enabled:            true,
+		MaxSeries:          4,
+		MaxSeriesPerMetric: 2,
+		Metrics:            map[string]int{"up": 3},
+		SeriesTTL:          time.Minute,
+		TopOffenders:       10,
+	})
+	l.now = func() time.Time { return now }
+
+	datapoint := func(name, id string) *DataPoint {
+		return &DataPoint{Name: name, Timestamp: 1000, Value: 1, Tags: map[string]string{"id": id}}
+	}
+
+	// a new series over the per metric limit is dropped, the active ones keep flowing
+	batch := []*DataPoint{datapoint("requests", "1"), datapoint("requests", "2"), datapoint("requests", "3"), datapoint("requests", "1")}
+	assert.Equal(t, []*DataPoint{datapoint("requests", "1"), datapoint("requests", "2"), datapoint("requests", "1")}, l.limit(batch, "kairosdb"))
+
+	// per metric override, then the global limit
+	batch = []*DataPoint{datapoint("up", "1"), datapoint("up", "2"), datapoint("up", "3")}
+	assert.Equal(t, []*DataPoint{datapoint("up", "1"), datapoint("up", "2")}, l.limit(batch, "kairosdb"))
+
+	assert.Equal(t, CardinalityReport{
+		ActiveSeries: 4,
+		MaxSeries:    4,
+		TopOffenders: []MetricCardinality{
+			{Metric: "requests", ActiveSeries: 2, MaxSeries: 2, LimitedSamples: 1},
+			{Metric: "up", ActiveSeries: 2, MaxSeries: 3, LimitedSamples: 1},
+		},
+	}, l.report(10))
+	assert.Len(t, l.report(1).TopOffenders, 1)
+
+	// idle series are evicted, making room for new ones
+	now = now.Add(30 * time.Second)
+	assert.Len(t, l.limit([]*DataPoint{datapoint("up", "1")}, "kairosdb"), 1)
+	now = now.Add(45 * time.Second)
+	assert.Equal(t, []*DataPoint{datapoint("requests", "3")}, l.limit([]*DataPoint{datapoint("requests", "3")}, "kairosdb"))
+	assert.Equal(t, 2, l.report(10).ActiveSeries)
+}
+
+func TestCardinalityLimiterLimitedMetrics(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newCardinalityLimiter(config.CardinalityLimits{
+		Enabled:      true,
+		MaxSeries:    1,
+		SeriesTTL:    time.Minute,
+		TopOffenders: 2,
+	})
+	l.now = func() time.Time { return now }
+
+	batch := []*DataPoint{{Name: "up"}}
+	for _, name := range []string{"a", "a", "a", "b", "c"} {
+		batch = append(batch, &DataPoint{Name: name})
+	}
+	assert.Equal(t, []*DataPoint{{Name: "up"}}, l.limit(batch, "kairosdb"))
+
+	// the metrics with only limited samples are bounded by the top offenders,
+	// c replaces b taking over its count
+	assert.Len(t, l.limited, 2)
+	assert.Equal(t, []MetricCardinality{
+		{Metric: "up", ActiveSeries: 1},
+		{Metric: "a", LimitedSamples: 3},
+		{Metric: "c", LimitedSamples: 2},
+	}, l.report(10).TopOffenders)
+
+	// evicting the idle series keeps the limited metrics
+	now = now.Add(2 * time.Minute)
+	l.limit(nil, "kairosdb")
+	assert.Equal(t, []MetricCardinality{
+		{Metric: "a", LimitedSamples: 3},
+		{Metric: "c", LimitedSamples: 2},
+	}, l.report(10).TopOffenders)
+	assert.Equal(t, []MetricCardinality{{Metric: "a", LimitedSamples: 3}}, l.mostLimited(1))
+
+	// a limited metric becomes active once there is room
+	assert.Equal(t, []*DataPoint{{Name: "a"}}, l.limit([]*DataPoint{{Name: "a"}}, "kairosdb"))
+	assert.Equal(t, MetricCardinality{Metric: "a", ActiveSeries: 1, LimitedSamples: 3}, *l.metrics["a"])
+	assert.NotContains(t, l.limited, "a")
+}
+
+func TestCardinalityLimiterDisabled(t *testing.T) {
+	l := newCardinalityLimiter(config.CardinalityLimits{MaxSeries: 1})
+	batch := []*DataPoint{{Name: "up"}, {Name: "down"}}
+	assert.Equal(t, batch, l.limit(batch, "kairosdb"))
+}
+
+func TestDatapointFingerprint(t *testing.T) {
+	fp := datapointFingerprint(&DataPoint{Name: "up", Tags: map[string]string{"a": "b", "c": "d"}})
+	assert.Equal(t, fp, datapointFingerprint(&DataPoint{Name: "up", Tags: map[string]string{"c": "d", "a": "b"}}))
+	assert.NotEqual(t, fp, datapointFingerprint(&DataPoint{Name: "up", Tags: map[string]string{"a": "bc", "": "d"}}))
+	assert.NotEqual(t, fp, datapointFingerprint(&DataPoint{Name: "down", Tags: map[string]string{"a": "b", "c": "d"}}))
+}
@@ -14,7 +14,10 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/analytics"
 	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/processor"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
 	"golang.org/x/net/context/ctxhttp"
 )
 
@@ -55,6 +58,20 @@ var (
 		},
 		[]string{"remote"},
 	)
+	sentMetadata = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sent_metadata_total",
+			Help: "Total number of metric metadata values written to remote storage.",
+		},
+		[]string{"remote"},
+	)
+	failedMetadata = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "failed_metadata_total",
+			Help: "Total number of metric metadata values which failed on write to remote storage.",
+		},
+		[]string{"remote"},
+	)
 )
 
 func RegisterPrometheusMetrics() {
@@ -63,45 +80,279 @@ func RegisterPrometheusMetrics() {
 	prometheus.MustRegister(unknownStatusSamples)
 	prometheus.MustRegister(sentBatchDuration)
 	prometheus.MustRegister(filteredSamples)
+	prometheus.MustRegister(sentMetadata)
+	prometheus.MustRegister(failedMetadata)
+	prometheus.MustRegister(collidingSamples)
+	prometheus.MustRegister(ttlDatapoints)
+	prometheus.MustRegister(specialValues)
+	prometheus.MustRegister(invalidTimestamps)
+	prometheus.MustRegister(sanitizedValues)
+	prometheus.MustRegister(schemaViolations)
+	prometheus.MustRegister(cardinalityActiveSeries)
+	prometheus.MustRegister(cardinalityTopSeries)
+	prometheus.MustRegister(cardinalityTopLimitedSamples)
+	prometheus.MustRegister(cardinalityLimitedSamples)
 }
 
 const (
 	postEndpoint    = "/api/v1/datapoints"
 	contentTypeJSON = "application/json"
+
+	// noRemote is passed as remote to not count samples in the metrics of a
+	// client, like samples which are only explained
+	noRemote = ""
 )
 
+// count increments the counter of the remote with the label values, unless
+// the remote is noRemote
+func count(counter *prometheus.CounterVec, remote string, labelValues ...string) {
+	if remote == noRemote {
+		return
+	}
+	counter.WithLabelValues(append([]string{remote}, labelValues...)...).Inc()
+}
+
 // Client struct defined how to connect to kairosdb
 type Client struct {
-	cfg     *config.Config
-	url     config.URL
-	timeout time.Duration
+	cfg         *config.Config
+	url         config.URL
+	timeout     time.Duration
+	metadata    *metadataCache
+	pipeline    *processor.Pipeline
+	relabeler   *relabel.Relabeler
+	schema      schemaIndex
+	collisions  *collisionTracker
+	timestamps  *timestampValidator
+	cardinality *cardinalityLimiter
+	analytics   *analytics.Analytics
 }
 
 // NewClient returns a new client for KairosDB
 func NewClient(cfg *config.Config) *Client {
-	return &Client{
-		cfg:     cfg,
-		url:     cfg.KairosdbURL,
-		timeout: cfg.Timeout,
+	c := &Client{
+		cfg:         cfg,
+		url:         cfg.KairosdbURL,
+		timeout:     cfg.Timeout,
+		metadata:    newMetadataCache(),
+		relabeler:   relabel.NewRelabeler(cfg.MetricRelabelConfigs),
+		schema:      newSchemaIndex(cfg.Schema),
+		collisions:  newCollisionTracker(cfg.Collisions),
+		timestamps:  newTimestampValidator(cfg.Timestamps),
+		cardinality: newCardinalityLimiter(cfg.CardinalityLimits),
+		analytics:   analytics.NewAnalytics(cfg.Analytics),
+	}
+	c.pipeline = processor.NewPipeline(cfg, c.name())
+	return c
+}
+
+// Reload returns a client for the config. Like the metadata cache, the
+// stateful components of the client are kept with their state unless their
+// section of the config changed. The samples held back by the processors
+// which are dropped, like open aggregation windows, are written with the
+// current config.
+func (c *Client) Reload(cfg *config.Config) *Client {
+	pipeline, flushed := c.pipeline.Reload(c.cfg, cfg)
+	if len(flushed) > 0 {
+		logrus.Infof("writing %d samples flushed by the processors of the previous config", len(flushed))
+		c.sendProcessed(flushed, nil)
+	}
+
+	client := &Client{
+		cfg:         cfg,
+		url:         cfg.KairosdbURL,
+		timeout:     cfg.Timeout,
+		metadata:    c.metadata,
+		pipeline:    pipeline,
+		relabeler:   c.relabeler,
+		schema:      newSchemaIndex(cfg.Schema),
+		collisions:  c.collisions,
+		timestamps:  c.timestamps,
+		cardinality: c.cardinality,
+		analytics:   c.analytics,
 	}
+	if !config.Equal(c.cfg.MetricRelabelConfigs, cfg.MetricRelabelConfigs) {
+		c.relabeler.Retire()
+		client.relabeler = relabel.NewRelabeler(cfg.MetricRelabelConfigs)
+	}
+	if !config.Equal(c.cfg.Collisions, cfg.Collisions) {
+		client.collisions = newCollisionTracker(cfg.Collisions)
+	}
+	if !config.Equal(c.cfg.Timestamps, cfg.Timestamps) {
+		client.timestamps = newTimestampValidator(cfg.Timestamps)
+	}
+	if !config.Equal(c.cfg.CardinalityLimits, cfg.CardinalityLimits) {
+		client.cardinality = newCardinalityLimiter(cfg.CardinalityLimits)
+	}
+	if !config.Equal(c.cfg.Analytics, cfg.Analytics) {
+		client.analytics = analytics.NewAnalytics(cfg.Analytics)
+	}
+	return client
+}
+
+// Flush writes the samples held back by the processors, like open
+// aggregation windows, and saves the counter reset offsets, before the
+// adapter exits
+func (c *Client) Flush() error {
+	flushed := c.pipeline.Flush()
+	if len(flushed) == 0 {
+		return nil
+	}
+	logrus.Infof("writing %d samples flushed by the processors", len(flushed))
+	_, _, err := c.sendProcessed(flushed, nil)
+	return err
 }
 
-// Send - Apply RelabelConfigs, massage the data and write the samples to KairosDB
-func (c *Client) Send(samples model.Samples) (err error) {
+// Send - Apply RelabelConfigs, massage the data and write the samples to KairosDB.
+// It returns the number of datapoints written, which excludes the samples
+// filtered out, limited or held back by the processors.
+func (c *Client) Send(samples model.Samples) (int, error) {
+	samples = c.timestamps.samples(samples, c.name())
+	_, written, err := c.sendValidated(samples, nil)
+	return written, err
+}
+
+// sendValidated tags and writes samples whose timestamps were validated
+// through the pipeline. It returns the datapoints sent like sendDatapoints.
+func (c *Client) sendValidated(samples model.Samples, sources map[*DataPoint]*model.Sample) ([]*DataPoint, int, error) {
+	if c.cfg.Metadata.TypeTag != "" {
+		c.metadata.tagType(samples, c.cfg.Metadata.TypeTag)
+	}
+
+	return c.sendProcessed(c.pipeline.Process(samples), sources)
+}
+
+// sendProcessed relabels and writes samples which went through the pipeline.
+// The sample each datapoint was converted from is added to sources if it is
+// not nil.
+func (c *Client) sendProcessed(samples model.Samples, sources map[*DataPoint]*model.Sample) ([]*DataPoint, int, error) {
+	c.observeSamples(samples)
+
 	logrus.Debugf("datapoints prior to filtering: %d", len(samples))
-	datapoints := FilterAndProcessSamples(samples, c.cfg)
+	datapoints := filterAndProcessSamples(samples, c.cfg, c.schema, c.collisions, c.relabeler.ProcessBatch, sources, c.name())
 	logrus.Debugf("datapoints after filtering: %d", len(datapoints))
+	c.observePostRelabel(datapoints)
+
+	return c.sendDatapoints(len(samples), datapoints)
+}
+
+// MetricRelabelConfigs returns the metric relabel configs applied to the
+// samples
+func (c *Client) MetricRelabelConfigs() []*config.RelabelConfig {
+	return c.cfg.MetricRelabelConfigs
+}
+
+// RelabelStats returns the statistics of the metric relabel configs since
+// they were loaded
+func (c *Client) RelabelStats() []relabel.RuleStats {
+	return c.relabeler.Stats()
+}
+
+// CardinalityReport returns the active series and the n metrics with the
+// most series, the configured top offenders if n is 0. It is nil if the
+// cardinality limits are not enabled.
+func (c *Client) CardinalityReport(n int) *CardinalityReport {
+	if !c.cfg.CardinalityLimits.Enabled {
+		return nil
+	}
+	if n == 0 {
+		n = c.cfg.CardinalityLimits.TopOffenders
+	}
+	report := c.cardinality.report(n)
+	return &report
+}
+
+// SendHistograms writes native histograms to KairosDB, either as classic
+// histogram series or as KairosDB histogram datapoints, and returns the
+// number of histograms written
+func (c *Client) SendHistograms(histograms []*HistogramSample) (int, error) {
+	histograms = c.timestamps.histograms(histograms, c.name())
+	if c.cfg.NativeHistograms.Mode != config.HistogramModeKairosDB {
+		return c.sendHistogramSeries(histograms)
+	}
+	c.observeHistograms(histograms)
+
+	logrus.Debugf("histograms prior to filtering: %d", len(histograms))
+	datapoints := filterAndProcessHistograms(histograms, c.cfg, c.schema, c.relabeler.ProcessBatch, c.name())
+	logrus.Debugf("histograms after filtering: %d", len(datapoints))
+	c.observePostRelabel(datapoints)
+
+	return c.send(len(histograms), datapoints)
+}
+
+// sendHistogramSeries writes native histograms as classic histogram series
+// and returns the number of histograms whose datapoints were written. The
+// series dropped on purpose, like by relabeling, don't count against a
+// histogram. As KairosDB does not tell which datapoints it rejected, every
+// rejected datapoint is taken to be of another histogram.
+func (c *Client) sendHistogramSeries(histograms []*HistogramSample) (int, error) {
+	var samples model.Samples
+	origins := make(map[*model.Sample]*HistogramSample)
+	for _, hs := range histograms {
+		for _, sample := range HistogramsToSamples([]*HistogramSample{hs}) {
+			origins[sample] = hs
+			samples = append(samples, sample)
+		}
+	}
 
-	filteredSamplesCount := len(samples) - len(datapoints)
+	sources := make(map[*DataPoint]*model.Sample, len(samples))
+	sent, written, err := c.sendValidated(samples, sources)
+
+	// datapoints derived by the processors, like rates, have no histogram
+	sentHistograms := make(map[*HistogramSample]bool)
+	for _, datapoint := range sent {
+		if hs, ok := origins[sources[datapoint]]; ok {
+			sentHistograms[hs] = true
+		}
+	}
+	if written = len(sentHistograms) - (len(sent) - written); written < 0 {
+		written = 0
+	}
+	return written, err
+}
+
+// ExemplarsEnabled tells if exemplars are written to KairosDB
+func (c *Client) ExemplarsEnabled() bool {
+	return c.cfg.Exemplars.Enabled
+}
+
+// SendExemplars writes exemplars as datapoints of their own metric to KairosDB
+// and returns the number of exemplars written
+func (c *Client) SendExemplars(exemplars []*ExemplarSample) (int, error) {
+	exemplars = c.timestamps.exemplars(exemplars, c.name())
+	logrus.Debugf("exemplars prior to filtering: %d", len(exemplars))
+	datapoints := filterAndProcessExemplars(exemplars, c.cfg, c.schema, c.relabeler.ProcessBatchUncounted, c.name())
+	logrus.Debugf("exemplars after filtering: %d", len(datapoints))
+
+	return c.send(len(exemplars), datapoints)
+}
+
+// send writes the datapoints and returns how many of them were written
+func (c *Client) send(received int, datapoints []*DataPoint) (int, error) {
+	_, written, err := c.sendDatapoints(received, datapoints)
+	return written, err
+}
+
+// sendDatapoints writes the datapoints like send and also returns the ones
+// sent to KairosDB, which excludes the ones over the cardinality limits
+func (c *Client) sendDatapoints(received int, datapoints []*DataPoint) (sent []*DataPoint, written int, err error) {
+	filteredSamplesCount := received - len(datapoints)
 	filteredSamples.WithLabelValues(c.name()).Add(float64(filteredSamplesCount))
 
 	if len(datapoints) == 0 {
 		logrus.Debugf("empty set of datapoints after filtering; nothing to send.")
-		return nil
+		return nil, 0, nil
 	}
 
+	sanitize(datapoints, c.cfg.Sanitize, c.name())
+	if datapoints = c.cardinality.limit(datapoints, c.name()); len(datapoints) == 0 {
+		logrus.Debugf("all datapoints over the cardinality limits; nothing to send.")
+		return nil, 0, nil
+	}
+	sent = datapoints
+	c.countTTLClasses(datapoints)
+
 	begin := time.Now()
-	err = c.write(datapoints)
+	written, err = c.write(datapoints)
 	if err != nil {
 		logrus.Errorf("failed writing metrics to downstream. error: %s", err)
 	}
@@ -112,19 +363,20 @@ func (c *Client) Send(samples model.Samples) (err error) {
 	return
 }
 
-// Write sends a batch of datapoints to KairosDB via its HTTP API.
-func (c *Client) write(datapoints []*DataPoint) error {
+// Write sends a batch of datapoints to KairosDB via its HTTP API and returns
+// the number of datapoints KairosDB accepted.
+func (c *Client) write(datapoints []*DataPoint) (int, error) {
 	totalRequests := len(datapoints)
 
 	c.url.Path = postEndpoint
 	buf, err := json.Marshal(datapoints)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	logrus.Debugf("pushing %d datapoints", totalRequests)
 	if c.cfg.DryRun {
-		return nil
+		return totalRequests, nil
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
@@ -133,20 +385,20 @@ func (c *Client) write(datapoints []*DataPoint) error {
 
 	if err != nil {
 		failedSamples.WithLabelValues(c.name()).Add(float64(totalRequests))
-		return err
+		return 0, err
 	}
 
 	defer resp.Body.Close()
 
 	if resp == nil {
 		failedSamples.WithLabelValues(c.name()).Add(float64(totalRequests))
-		return fmt.Errorf("no response received")
+		return 0, fmt.Errorf("no response received")
 	}
 
 	if resp.StatusCode == http.StatusNoContent {
 		logrus.Infof("pushed %d datapoints successfully", totalRequests)
 		sentSamples.WithLabelValues(c.name()).Add(float64(totalRequests))
-		return nil
+		return totalRequests, nil
 	}
 
 	// API returns status code 400 on error, encoding error details in the
@@ -155,7 +407,7 @@ func (c *Client) write(datapoints []*DataPoint) error {
 	if err != nil {
 		logrus.Errorf("%s", err)
 		unknownStatusSamples.WithLabelValues(c.name()).Add(float64(totalRequests))
-		return err
+		return 0, err
 	}
 
 	var r map[string][]interface{}
@@ -163,7 +415,7 @@ func (c *Client) write(datapoints []*DataPoint) error {
 		logrus.Errorf("response received is : %s", string(respbuf))
 		logrus.Errorf("%s", err)
 		unknownStatusSamples.WithLabelValues(c.name()).Add(float64(totalRequests))
-		return err
+		return 0, err
 	}
 
 	failed := len(r["errors"])
@@ -174,13 +426,13 @@ func (c *Client) write(datapoints []*DataPoint) error {
 	if successful < 0 {
 		logrus.Errorf("response from kairosdb %v", r)
 		logrus.Errorf("req to kairosdb %v", string(buf))
-		return fmt.Errorf("number of failed datapoints [%d] is greater than total datapoints [%d]", failed, totalRequests)
+		return 0, fmt.Errorf("number of failed datapoints [%d] is greater than total datapoints [%d]", failed, totalRequests)
 	}
 
 	sentSamples.WithLabelValues(c.name()).Add(float64(successful))
 	failedSamples.WithLabelValues(c.name()).Add(float64(failed))
 
-	return fmt.Errorf("failed to write [%d] samples of [%d]", failed, totalRequests)
+	return successful, fmt.Errorf("failed to write [%d] samples of [%d]", failed, totalRequests)
 }
 
 func (c *Client) name() string {
@@ -0,0 +1,184 @@
+package kairosdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientReload(t *testing.T) {
+	cfg := &config.Config{
+		Timeout:           time.Second,
+		CardinalityLimits: config.CardinalityLimits{Enabled: true, MaxSeries: 10},
+	}
+	client := NewClient(cfg)
+
+	reloaded := client.Reload(&config.Config{
+		Timeout:           2 * time.Second,
+		CardinalityLimits: config.CardinalityLimits{Enabled: true, MaxSeries: 10},
+	})
+	assert.Equal(t, 2*time.Second, reloaded.timeout)
+	assert.True(t, client.metadata == reloaded.metadata)
+	assert.True(t, client.cardinality == reloaded.cardinality)
+	assert.True(t, client.collisions == reloaded.collisions)
+
+	reloaded = reloaded.Reload(&config.Config{
+		Timeout:           2 * time.Second,
+		CardinalityLimits: config.CardinalityLimits{Enabled: true, MaxSeries: 20},
+	})
+	assert.False(t, client.cardinality == reloaded.cardinality)
+	assert.Equal(t, 20, reloaded.cardinality.cfg.MaxSeries)
+	assert.True(t, client.collisions == reloaded.collisions)
+	assert.True(t, client.relabeler == reloaded.relabeler)
+
+	// the statistics of changed relabel configs start from zero
+	reloaded.relabeler.ProcessBatch([]model.Metric{{model.MetricNameLabel: "up"}})
+	reloaded = reloaded.Reload(&config.Config{
+		Timeout:           2 * time.Second,
+		CardinalityLimits: config.CardinalityLimits{Enabled: true, MaxSeries: 20},
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("pod"), Action: config.RelabelLabelDrop},
+		},
+	})
+	assert.Equal(t, make([]relabel.RuleStats, 1), reloaded.RelabelStats())
+
+	// the schema is indexed once per config
+	reloaded = reloaded.Reload(&config.Config{
+		Timeout: 2 * time.Second,
+		Schema:  config.Schema{Metrics: []*config.MetricSchema{{Name: "up"}}},
+	})
+	assert.Contains(t, reloaded.schema, "up")
+}
+
+func TestClientReloadFlushesProcessors(t *testing.T) {
+	var written []*DataPoint
+	kairos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var datapoints []*DataPoint
+		json.Unmarshal(body, &datapoints)
+		written = append(written, datapoints...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer kairos.Close()
+
+	u, _ := url.Parse(kairos.URL)
+	downsampling := func(interval time.Duration) config.Downsampling {
+		return config.Downsampling{
+			SeriesTTL: time.Hour,
+			Rules: []*config.DownsamplingRule{{
+				Match: []*config.RelabelConfig{{
+					SourceLabels: model.LabelNames{model.MetricNameLabel},
+					Regex:        config.MustNewRegexp("^requests_total$"),
+					Action:       config.RelabelKeep,
+				}},
+				Interval: interval,
+				Function: config.DownsamplingLast,
+			}},
+		}
+	}
+	client := NewClient(&config.Config{
+		KairosdbURL:  config.URL{URL: u},
+		Timeout:      time.Second,
+		Downsampling: downsampling(time.Hour),
+	})
+
+	now := model.Now()
+	client.Send(model.Samples{
+		{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Value: 1, Timestamp: now},
+		{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Value: 2, Timestamp: now},
+	})
+	assert.Empty(t, written, "the window is open")
+
+	reloaded := client.Reload(&config.Config{
+		KairosdbURL:  config.URL{URL: u},
+		Timeout:      time.Second,
+		Downsampling: downsampling(2 * time.Hour),
+	})
+	if assert.Len(t, written, 1, "the open window must be written on reload") {
+		assert.Equal(t, "requests_total", written[0].Name)
+		assert.Equal(t, 2.0, written[0].Value)
+	}
+
+	reloaded.Send(model.Samples{
+		{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Value: 3, Timestamp: now + 1},
+	})
+	assert.Len(t, written, 1, "the window is open")
+	assert.NoError(t, reloaded.Flush())
+	if assert.Len(t, written, 2, "the open window must be written on flush") {
+		assert.Equal(t, 3.0, written[1].Value)
+	}
+}
+
+func TestClientSendHistogramSeries(t *testing.T) {
+	histograms := []*HistogramSample{
+		{Metric: histogramMetric, Histogram: integerHistogram, Timestamp: 1000},
+		{Metric: model.Metric{model.MetricNameLabel: "request_duration_seconds", "job": "db"}, Histogram: floatHistogram, Timestamp: 1000},
+	}
+
+	cases := []struct {
+		name     string
+		relabel  []*config.RelabelConfig
+		status   int
+		response string
+		written  int
+	}{
+		{
+			name:    "written",
+			status:  http.StatusNoContent,
+			written: 2,
+		},
+		{
+			name: "histogram dropped by relabeling",
+			relabel: []*config.RelabelConfig{
+				{SourceLabels: model.LabelNames{"job"}, Regex: config.MustNewRegexp("db"), Action: config.RelabelDrop},
+			},
+			status:  http.StatusNoContent,
+			written: 1,
+		},
+		{
+			name: "series dropped by relabeling",
+			relabel: []*config.RelabelConfig{
+				{SourceLabels: model.LabelNames{model.MetricNameLabel}, Regex: config.MustNewRegexp(".*_sum"), Action: config.RelabelDrop},
+			},
+			status:  http.StatusNoContent,
+			written: 2,
+		},
+		{
+			name:     "datapoint rejected",
+			status:   http.StatusBadRequest,
+			response: `{"errors": ["datapoint rejected"]}`,
+			written:  1,
+		},
+		{
+			name:    "write failed",
+			status:  http.StatusInternalServerError,
+			written: 0,
+		},
+	}
+
+	for _, c := range cases {
+		kairos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			w.Write([]byte(c.response))
+		}))
+		u, _ := url.Parse(kairos.URL)
+		client := NewClient(&config.Config{
+			KairosdbURL:          config.URL{URL: u},
+			Timeout:              time.Second,
+			MetricRelabelConfigs: c.relabel,
+		})
+
+		written, _ := client.SendHistograms(histograms)
+		assert.Equal(t, c.written, written, c.name)
+		kairos.Close()
+	}
+}
@@ -0,0 +1,188 @@
+package kairosdb
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+var collidingSamples = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "colliding_samples_total",
+		Help: "Total number of samples of distinct series which collided with another series after relabeling.",
+	},
+	[]string{"remote", "metric"},
+)
+
+// collisionKey is a KairosDB series at a timestamp
+type collisionKey struct {
+	series    model.Fingerprint
+	timestamp int64
+}
+
+// collidingPoint is the value of a KairosDB series at a timestamp and the
+// values of the Prometheus series which were written to it. The values are
+// only kept per series once a second series collided, so points without
+// collisions don't allocate.
+type collidingPoint struct {
+	policy    config.CollisionPolicy
+	source    model.Fingerprint
+	value     float64
+	values    map[model.Fingerprint]float64
+	last      float64
+	datapoint *DataPoint
+	batch     int
+	lastSeen  time.Time
+}
+
+// collisionTracker detects Prometheus series which are written to the same
+// KairosDB series and timestamp, where KairosDB would keep only the value
+// written last. Within a batch all timestamps are tracked, across batches
+// only the latest timestamp of every KairosDB series.
+type collisionTracker struct {
+	mtx          sync.Mutex
+	cfg          config.Collisions
+	points       map[collisionKey]collidingPoint
+	latest       map[model.Fingerprint]int64
+	batch        int
+	batchStart   time.Time
+	lastEviction time.Time
+	now          func() time.Time
+}
+
+func newCollisionTracker(cfg config.Collisions) *collisionTracker {
+	return &collisionTracker{
+		cfg:    cfg,
+		points: make(map[collisionKey]collidingPoint),
+		latest: make(map[model.Fingerprint]int64),
+		now:    time.Now,
+	}
+}
+
+// policy returns the collision policy of the metric as received
+func (c *collisionTracker) policy(metric model.Metric) config.CollisionPolicy {
+	for _, rule := range c.cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule.Policy
+		}
+	}
+	if c.cfg.Policy == "" {
+		return config.CollisionLast
+	}
+	return c.cfg.Policy
+}
+
+// resolve returns the datapoint to write for the sample of the source
+// series, or nil if there is none. A collision within the batch updates the
+// datapoint already written, one with an earlier batch writes the resolved
+// value again, overwriting the previous one in KairosDB.
+func (c *collisionTracker) resolve(source model.Fingerprint, policy config.CollisionPolicy, metric model.Metric, datapoint *DataPoint, remote string) *DataPoint {
+	key := collisionKey{series: metric.Fingerprint(), timestamp: datapoint.Timestamp}
+	point, ok := c.points[key]
+	if !ok {
+		c.points[key] = collidingPoint{
+			policy:    policy,
+			source:    source,
+			value:     datapoint.Value,
+			last:      datapoint.Value,
+			datapoint: datapoint,
+			batch:     c.batch,
+			lastSeen:  c.batchStart,
+		}
+		if latest, ok := c.latest[key.series]; !ok || key.timestamp > latest {
+			c.latest[key.series] = key.timestamp
+		}
+		return datapoint
+	}
+	point.lastSeen = c.batchStart
+
+	if point.values == nil && source != point.source {
+		point.values = map[model.Fingerprint]float64{point.source: point.value}
+	}
+	if point.values == nil {
+		point.value = datapoint.Value
+	} else {
+		if _, ok := point.values[source]; !ok {
+			count(collidingSamples, remote, datapoint.Name)
+			if point.policy == config.CollisionReject {
+				c.points[key] = point
+				return nil
+			}
+		}
+		point.values[source] = datapoint.Value
+	}
+	point.last = datapoint.Value
+
+	if point.batch == c.batch {
+		point.datapoint.Value = point.resolve()
+		c.points[key] = point
+		return nil
+	}
+	point.batch = c.batch
+	point.datapoint = datapoint
+	datapoint.Value = point.resolve()
+	c.points[key] = point
+	return datapoint
+}
+
+func (p *collidingPoint) resolve() float64 {
+	if p.values == nil {
+		return p.value
+	}
+	switch p.policy {
+	case config.CollisionSum, config.CollisionAvg:
+		var sum float64
+		for _, value := range p.values {
+			sum += value
+		}
+		if p.policy == config.CollisionAvg {
+			return sum / float64(len(p.values))
+		}
+		return sum
+	case config.CollisionMax:
+		max := math.Inf(-1)
+		for _, value := range p.values {
+			max = math.Max(max, value)
+		}
+		return max
+	case config.CollisionReject:
+		for _, value := range p.values {
+			return value
+		}
+	}
+	return p.last
+}
+
+// beginBatch locks the tracker for a batch of samples
+func (c *collisionTracker) beginBatch() {
+	c.mtx.Lock()
+	c.batchStart = c.now()
+}
+
+// endBatch forgets all but the latest timestamp of every KairosDB series,
+// evicts the series which have been idle for longer than the TTL and
+// unlocks the tracker.
+func (c *collisionTracker) endBatch() {
+	defer c.mtx.Unlock()
+
+	now := c.batchStart
+	evict := now.Sub(c.lastEviction) >= c.cfg.SeriesTTL
+	if evict {
+		c.lastEviction = now
+	}
+
+	for key, point := range c.points {
+		if key.timestamp < c.latest[key.series] {
+			delete(c.points, key)
+		} else if evict && now.Sub(point.lastSeen) > c.cfg.SeriesTTL {
+			delete(c.points, key)
+			delete(c.latest, key.series)
+		}
+	}
+	c.batch++
+}
@@ -0,0 +1,164 @@
+package kairosdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func podSample(pod string, value float64, ts model.Time) *model.Sample {
+	return &model.Sample{
+		Metric:    model.Metric{model.MetricNameLabel: "cpu", "namespace": "web", "pod": model.LabelValue(pod)},
+		Value:     model.SampleValue(value),
+		Timestamp: ts,
+	}
+}
+
+// seriesSamples returns samples sharing the metric, like the samples of a
+// remote write time series
+func seriesSamples(metric model.Metric, values ...float64) model.Samples {
+	samples := make(model.Samples, len(values))
+	for i, value := range values {
+		samples[i] = &model.Sample{Metric: metric, Value: model.SampleValue(value), Timestamp: model.Time(1000 * (i + 1))}
+	}
+	return samples
+}
+
+func cpuDataPoint(value float64, ts int64) *DataPoint {
+	return &DataPoint{Name: "cpu", Timestamp: ts, Value: value, Tags: map[string]string{"namespace": "web"}}
+}
+
+func TestFilterAndProcessSamplesCollisions(t *testing.T) {
+	cases := []struct {
+		name       string
+		policy     config.CollisionPolicy
+		batches    []model.Samples
+		datapoints []*DataPoint
+	}{
+		{
+			name:   "sum within a batch",
+			policy: config.CollisionSum,
+			batches: []model.Samples{
+				{podSample("a", 1, 1000), podSample("a", 2, 2000), podSample("b", 3, 1000), podSample("b", 4, 2000)},
+			},
+			datapoints: []*DataPoint{cpuDataPoint(4, 1000), cpuDataPoint(6, 2000)},
+		},
+		{
+			name:   "sum of time series with several samples",
+			policy: config.CollisionSum,
+			batches: []model.Samples{
+				append(
+					seriesSamples(podSample("a", 0, 0).Metric, 1, 2),
+					seriesSamples(podSample("b", 0, 0).Metric, 2, 3)...,
+				),
+			},
+			datapoints: []*DataPoint{cpuDataPoint(3, 1000), cpuDataPoint(5, 2000)},
+		},
+		{
+			name:   "avg across batches",
+			policy: config.CollisionAvg,
+			batches: []model.Samples{
+				{podSample("a", 1, 1000)},
+				{podSample("b", 3, 1000)},
+			},
+			datapoints: []*DataPoint{cpuDataPoint(2, 1000)},
+		},
+		{
+			name:   "max",
+			policy: config.CollisionMax,
+			batches: []model.Samples{
+				{podSample("a", 5, 1000), podSample("b", 3, 1000)},
+			},
+			datapoints: []*DataPoint{cpuDataPoint(5, 1000)},
+		},
+		{
+			name:   "last",
+			policy: config.CollisionLast,
+			batches: []model.Samples{
+				{podSample("a", 5, 1000), podSample("b", 3, 1000)},
+			},
+			datapoints: []*DataPoint{cpuDataPoint(3, 1000)},
+		},
+		{
+			name:   "reject within a batch",
+			policy: config.CollisionReject,
+			batches: []model.Samples{
+				{podSample("a", 5, 1000), podSample("b", 3, 1000)},
+			},
+			datapoints: []*DataPoint{cpuDataPoint(5, 1000)},
+		},
+		{
+			name:   "reject across batches",
+			policy: config.CollisionReject,
+			batches: []model.Samples{
+				{podSample("a", 5, 1000)},
+				{podSample("b", 3, 1000)},
+			},
+			datapoints: nil,
+		},
+		{
+			name:   "no collision of the same series",
+			policy: config.CollisionSum,
+			batches: []model.Samples{
+				{podSample("a", 5, 1000)},
+				{podSample("a", 6, 1000), podSample("b", 1, 2000)},
+			},
+			datapoints: []*DataPoint{cpuDataPoint(6, 1000), cpuDataPoint(1, 2000)},
+		},
+	}
+
+	for _, c := range cases {
+		cfg := &config.Config{
+			MetricRelabelConfigs: []*config.RelabelConfig{
+				{Regex: config.MustNewRegexp("pod"), Action: config.RelabelLabelDrop},
+			},
+			Collisions: config.Collisions{Enabled: true, Policy: c.policy, SeriesTTL: time.Minute},
+		}
+		collisions := newCollisionTracker(cfg.Collisions)
+
+		var actual []*DataPoint
+		for _, batch := range c.batches {
+			actual = filterAndProcessSamples(batch, cfg, newSchemaIndex(cfg.Schema), collisions, processRelabelConfigs(cfg), nil, "kairosdb")
+		}
+		assert.Equal(t, c.datapoints, actual, c.name)
+	}
+}
+
+func TestCollisionsDisabled(t *testing.T) {
+	cfg := &config.Config{
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("pod"), Action: config.RelabelLabelDrop},
+		},
+		Collisions: config.Collisions{Policy: config.CollisionSum, SeriesTTL: time.Minute},
+	}
+	collisions := newCollisionTracker(cfg.Collisions)
+
+	batch := model.Samples{podSample("a", 5, 1000), podSample("b", 3, 1000)}
+	actual := filterAndProcessSamples(batch, cfg, newSchemaIndex(cfg.Schema), collisions, processRelabelConfigs(cfg), nil, "kairosdb")
+	assert.Equal(t, []*DataPoint{cpuDataPoint(5, 1000), cpuDataPoint(3, 1000)}, actual)
+	assert.Empty(t, collisions.points, "collisions must not be tracked when disabled")
+}
+
+func TestCollisionPolicyRules(t *testing.T) {
+	collisions := newCollisionTracker(config.Collisions{
+		Policy: config.CollisionLast,
+		Rules: []*config.CollisionRule{
+			{
+				Match: []*config.RelabelConfig{
+					{
+						SourceLabels: model.LabelNames{model.MetricNameLabel},
+						Regex:        config.MustNewRegexp("cpu"),
+						Action:       config.RelabelKeep,
+					},
+				},
+				Policy: config.CollisionSum,
+			},
+		},
+	})
+
+	assert.Equal(t, config.CollisionSum, collisions.policy(model.Metric{model.MetricNameLabel: "cpu"}))
+	assert.Equal(t, config.CollisionLast, collisions.policy(model.Metric{model.MetricNameLabel: "memory"}))
+}
@@ -1,6 +1,7 @@
 package kairosdb
 
 import (
+	"encoding/json"
 	"math"
 
 	"github.com/prometheus/common/model"
@@ -10,10 +11,44 @@ import (
 
 // DataPoint represents the kairosdb DataPoint
 type DataPoint struct {
-	Name      string            `json:"name"`
-	Timestamp int64             `json:"timestamp"`
-	Value     float64           `json:"value"`
-	Tags      map[string]string `json:"tags"`
+	Name        string            `json:"name"`
+	Timestamp   int64             `json:"timestamp"`
+	Value       float64           `json:"value"`
+	Tags        map[string]string `json:"tags"`
+	Type        string            `json:"type,omitempty"`
+	TTL         int64             `json:"ttl,omitempty"`
+	Histogram   *HistogramValue   `json:"-"`
+	StringValue string            `json:"-"`
+
+	// ttlClass is the class datapoints are counted in per TTL, empty
+	// without TTL
+	ttlClass string
+}
+
+// MarshalJSON writes the histogram as value of histogram typed datapoints,
+// the string as value of string typed datapoints and the value of long typed
+// datapoints as integer
+func (d DataPoint) MarshalJSON() ([]byte, error) {
+	type plain DataPoint
+	switch d.Type {
+	case longType:
+		return json.Marshal(struct {
+			plain
+			Value int64 `json:"value"`
+		}{plain(d), int64(d.Value)})
+	case histogramType:
+		return json.Marshal(struct {
+			plain
+			Value *HistogramValue `json:"value"`
+		}{plain(d), d.Histogram})
+	case stringType:
+		return json.Marshal(struct {
+			plain
+			Value string `json:"value"`
+		}{plain(d), d.StringValue})
+	default:
+		return json.Marshal(plain(d))
+	}
 }
 
 // ValidValue filters out values which are not supported by KairosDB
@@ -25,28 +60,128 @@ func ValidValue(value float64) bool {
 	return true
 }
 
-func FilterAndProcessSamples(samples model.Samples, cfg *config.Config) (datapoints []*DataPoint) {
-	for _, sample := range samples {
+// FilterAndProcessSamples relabels the samples and converts them to
+// datapoints, without counting them. With collisions enabled, samples of
+// distinct series colliding within the batch are resolved according to the
+// collision policy. The value type is set once the values are resolved.
+func FilterAndProcessSamples(samples model.Samples, cfg *config.Config) []*DataPoint {
+	return filterAndProcessSamples(samples, cfg, newSchemaIndex(cfg.Schema), newCollisionTracker(cfg.Collisions), processRelabelConfigs(cfg), nil, noRemote)
+}
+
+// relabelFunc applies the metric relabel configs to a batch of metrics in
+// place, setting the dropped ones to nil
+type relabelFunc func([]model.Metric)
+
+// processRelabelConfigs returns a relabelFunc applying the metric relabel
+// configs without counting them in the rule statistics
+func processRelabelConfigs(cfg *config.Config) relabelFunc {
+	return func(metrics []model.Metric) {
+		relabel.ProcessBatch(metrics, cfg.MetricRelabelConfigs...)
+	}
+}
+
+func filterAndProcessSamples(samples model.Samples, cfg *config.Config, schema schemaIndex, collisions *collisionTracker, relabelMetrics relabelFunc, sources map[*DataPoint]*model.Sample, remote string) (datapoints []*DataPoint) {
+	// collisions are only tracked if enabled, as the tracker serializes the
+	// batches
+	tracking := collisions.cfg.Enabled
+	if tracking {
+		collisions.beginBatch()
+		defer collisions.endBatch()
+	}
+
+	// relabeling modifies the metrics, which the samples of a series share
+	relabeled := make([]model.Metric, len(samples))
+	for i, sample := range samples {
+		relabeled[i] = sample.Metric.Clone()
+	}
+	relabelMetrics(relabeled)
+
+	types := make(map[*DataPoint]config.ValueType)
+	for i, sample := range samples {
 		metric := sample.Metric
 		value := float64(sample.Value)
 		timestamp := int64(sample.Timestamp)
 
-		metric = relabel.Process(metric, cfg.MetricRelabelConfigs...)
-		if metric == nil {
+		var source model.Fingerprint
+		var policy config.CollisionPolicy
+		if tracking {
+			source = metric.Fingerprint()
+			policy = collisions.policy(metric)
+		}
+		info := infoRule(metric, cfg.InfoMetrics)
+		dataType := valueType(metric, cfg.ValueTypes)
+		ttl, ttlClass := ttl(metric, cfg.TTL)
+		var str string
+		if info != nil {
+			str = infoValue(metric, info)
+		}
+
+		if metric = relabeled[i]; metric == nil {
+			continue
+		}
+		if metric = schema.apply(metric, cfg.Schema, remote); metric == nil {
 			continue
 		}
 
+		var stale bool
 		if !ValidValue(value) {
-			continue
+			var ok bool
+			if value, stale, ok = specialValue(value, cfg.SpecialValues, remote); !ok {
+				continue
+			}
 		}
 
+		name := string(metric[model.MetricNameLabel])
 		tags := tagsFromMetric(metric)
-		datapoints = append(datapoints, &DataPoint{
-			Name:      string(metric[model.MetricNameLabel]),
+		if stale {
+			switch cfg.SpecialValues.StaleMarkers.Action {
+			case config.StaleMarkerDatapoint:
+				name += cfg.SpecialValues.StaleMarkers.MetricSuffix
+			case config.StaleMarkerTag:
+				tags[cfg.SpecialValues.StaleMarkers.Tag] = "true"
+			}
+		}
+
+		if info != nil && !stale {
+			// string values can't be resolved by the collision policies
+			datapoint := &DataPoint{
+				Name:        name,
+				Timestamp:   timestamp,
+				Tags:        infoTags(tags, info),
+				Type:        stringType,
+				StringValue: str,
+				TTL:         ttl,
+				ttlClass:    ttlClass,
+			}
+			datapoints = append(datapoints, datapoint)
+			if sources != nil {
+				sources[datapoint] = sample
+			}
+			continue
+		}
+
+		datapoint := &DataPoint{
+			Name:      name,
 			Timestamp: timestamp,
 			Value:     value,
 			Tags:      tags,
-		})
+			TTL:       ttl,
+			ttlClass:  ttlClass,
+		}
+		if tracking {
+			datapoint = collisions.resolve(source, policy, metric, datapoint, remote)
+		}
+		if datapoint != nil {
+			datapoints = append(datapoints, datapoint)
+			types[datapoint] = dataType
+			if sources != nil {
+				sources[datapoint] = sample
+			}
+		}
+	}
+
+	for _, datapoint := range datapoints {
+		setValueType(datapoint, types[datapoint])
 	}
 	return
 }
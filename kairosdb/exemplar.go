@@ -0,0 +1,67 @@
+package kairosdb
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+// ExemplarSample is an exemplar of a single series
+type ExemplarSample struct {
+	Metric    model.Metric
+	Labels    model.LabelSet
+	Value     model.SampleValue
+	Timestamp model.Time
+}
+
+// FilterAndProcessExemplars converts exemplars to datapoints of the metric
+// name with the exemplar suffix. The relabel configs are applied to the
+// series only, so the exemplar labels, like trace_id, always become tags.
+// Series labels take precedence over exemplar labels of the same name. The
+// value type and TTL are the ones of the series.
+func FilterAndProcessExemplars(exemplars []*ExemplarSample, cfg *config.Config) []*DataPoint {
+	return filterAndProcessExemplars(exemplars, cfg, newSchemaIndex(cfg.Schema), processRelabelConfigs(cfg), noRemote)
+}
+
+func filterAndProcessExemplars(exemplars []*ExemplarSample, cfg *config.Config, schema schemaIndex, relabelMetrics relabelFunc, remote string) (datapoints []*DataPoint) {
+	relabeled := make([]model.Metric, len(exemplars))
+	for i, exemplar := range exemplars {
+		relabeled[i] = exemplar.Metric.Clone()
+	}
+	relabelMetrics(relabeled)
+
+	for i, exemplar := range exemplars {
+		metric := relabeled[i]
+		if metric == nil {
+			continue
+		}
+		if metric = schema.apply(metric, cfg.Schema, remote); metric == nil {
+			continue
+		}
+
+		value := float64(exemplar.Value)
+		if !ValidValue(value) {
+			continue
+		}
+
+		tags := tagsFromMetric(metric)
+		for labelName, labelValue := range exemplar.Labels {
+			if _, ok := tags[string(labelName)]; ok || labelValue == "" {
+				continue
+			}
+			tags[string(labelName)] = string(labelValue)
+		}
+
+		ttl, ttlClass := ttl(exemplar.Metric, cfg.TTL)
+		datapoint := &DataPoint{
+			Name:      string(metric[model.MetricNameLabel]) + cfg.Exemplars.MetricSuffix,
+			Timestamp: int64(exemplar.Timestamp),
+			Value:     value,
+			Tags:      tags,
+			TTL:       ttl,
+			ttlClass:  ttlClass,
+		}
+		setValueType(datapoint, valueType(exemplar.Metric, cfg.ValueTypes))
+		datapoints = append(datapoints, datapoint)
+	}
+	return
+}
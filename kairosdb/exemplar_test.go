@@ -0,0 +1,93 @@
+package kairosdb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterAndProcessExemplars(t *testing.T) {
+	cfg, err := config.ParseCfgFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("failed to parse config file: %s", err)
+	}
+
+	metric := model.Metric{
+		model.MetricNameLabel: "request_duration_seconds_bucket",
+		"job":                 "api",
+		"le":                  "0.5",
+	}
+
+	cases := []struct {
+		name       string
+		exemplars  []*ExemplarSample
+		datapoints []*DataPoint
+	}{
+		{
+			name: "exemplar labels become tags",
+			exemplars: []*ExemplarSample{
+				{
+					Metric:    metric,
+					Labels:    model.LabelSet{"trace_id": "abc123", "span_id": "def456"},
+					Value:     0.27,
+					Timestamp: 1000,
+				},
+			},
+			datapoints: []*DataPoint{
+				{
+					Name:      "my-prefix.request_duration_seconds_bucket_exemplar",
+					Timestamp: 1000,
+					Value:     0.27,
+					Tags: map[string]string{
+						"job":      "api",
+						"le":       "0.5",
+						"trace_id": "abc123",
+						"span_id":  "def456",
+					},
+				},
+			},
+		},
+		{
+			name: "series labels take precedence",
+			exemplars: []*ExemplarSample{
+				{
+					Metric:    metric,
+					Labels:    model.LabelSet{"trace_id": "abc123", "job": "other"},
+					Value:     0.27,
+					Timestamp: 1000,
+				},
+			},
+			datapoints: []*DataPoint{
+				{
+					Name:      "my-prefix.request_duration_seconds_bucket_exemplar",
+					Timestamp: 1000,
+					Value:     0.27,
+					Tags: map[string]string{
+						"job":      "api",
+						"le":       "0.5",
+						"trace_id": "abc123",
+					},
+				},
+			},
+		},
+		{
+			name: "invalid value",
+			exemplars: []*ExemplarSample{
+				{
+					Metric:    metric,
+					Labels:    model.LabelSet{"trace_id": "abc123"},
+					Value:     model.SampleValue(math.NaN()),
+					Timestamp: 1000,
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		actual := FilterAndProcessExemplars(c.exemplars, cfg)
+		assert.Equal(t, c.datapoints, actual, c.name)
+	}
+}
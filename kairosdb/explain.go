@@ -0,0 +1,100 @@
+package kairosdb
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+// Explanation tells how a sample is relabeled and which datapoints would be
+// written for it
+type Explanation struct {
+	Series     model.Metric  `json:"series"`
+	Steps      []ExplainStep `json:"steps"`
+	DroppedBy  *int          `json:"dropped_by,omitempty"`
+	DroppedIn  string        `json:"dropped_in,omitempty"`
+	ChangedBy  []int         `json:"changed_by"`
+	DataPoints []*DataPoint  `json:"datapoints"`
+}
+
+// Where a series can be dropped, reported in the dropped_in field of an
+// explanation
+const (
+	droppedInRelabel       = "metric_relabel_configs"
+	droppedInSchema        = "schema"
+	droppedInSpecialValues = "special_values"
+)
+
+// ExplainStep is the series after a metric relabel rule, omitted if the rule
+// dropped it
+type ExplainStep struct {
+	Rule    int                  `json:"rule"`
+	Action  config.RelabelAction `json:"action"`
+	Matched bool                 `json:"matched"`
+	Result  string               `json:"result"`
+	Series  model.Metric         `json:"series,omitempty"`
+}
+
+// Explain relabels the sample and converts it to the datapoints which would
+// be written, without writing them. Processors, timestamp validation and
+// cardinality limits are not applied, as they depend on the samples received
+// before. Nothing is counted, neither in the relabel rule statistics nor in
+// the metrics of the client.
+func (c *Client) Explain(sample *model.Sample) *Explanation {
+	samples := model.Samples{{Metric: sample.Metric.Clone(), Value: sample.Value, Timestamp: sample.Timestamp}}
+	if c.cfg.Metadata.TypeTag != "" {
+		c.metadata.tagType(samples, c.cfg.Metadata.TypeTag)
+	}
+
+	e := &Explanation{
+		Series:     samples[0].Metric.Clone(),
+		Steps:      []ExplainStep{},
+		ChangedBy:  []int{},
+		DataPoints: []*DataPoint{},
+	}
+
+	for _, step := range relabel.Explain(samples[0].Metric, c.cfg.MetricRelabelConfigs...) {
+		e.Steps = append(e.Steps, ExplainStep{
+			Rule:    step.Rule,
+			Action:  step.Config.Action,
+			Matched: step.Matched,
+			Result:  step.Result,
+			Series:  step.Metric,
+		})
+		switch {
+		case step.Metric == nil:
+			rule := step.Rule
+			e.DroppedBy = &rule
+			e.DroppedIn = droppedInRelabel
+		case step.Result == relabel.ChangedResult:
+			e.ChangedBy = append(e.ChangedBy, step.Rule)
+		}
+	}
+
+	// after the steps, as relabeling modifies the metric of the sample
+	datapoints := filterAndProcessSamples(samples, c.cfg, c.schema, newCollisionTracker(c.cfg.Collisions), processRelabelConfigs(c.cfg), nil, noRemote)
+	sanitize(datapoints, c.cfg.Sanitize, noRemote)
+	e.DataPoints = append(e.DataPoints, datapoints...)
+	if len(datapoints) == 0 && e.DroppedBy == nil {
+		e.DroppedIn = c.droppedIn(e, float64(sample.Value))
+	}
+	return e
+}
+
+// droppedIn tells why a series which was not dropped by relabeling has no
+// datapoints
+func (c *Client) droppedIn(e *Explanation, value float64) string {
+	metric := e.Series
+	if len(e.Steps) > 0 {
+		metric = e.Steps[len(e.Steps)-1].Series
+	}
+	if c.schema.apply(metric.Clone(), c.cfg.Schema, noRemote) == nil {
+		return droppedInSchema
+	}
+	if !ValidValue(value) {
+		if _, _, ok := specialValue(value, c.cfg.SpecialValues, noRemote); !ok {
+			return droppedInSpecialValues
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package kairosdb
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainDroppedIn(t *testing.T) {
+	u, _ := url.Parse("http://localhost:8080")
+	cfg := &config.Config{
+		KairosdbURL: config.URL{URL: u},
+		Timeout:     time.Second,
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{SourceLabels: model.LabelNames{"job"}, Regex: config.MustNewRegexp("db"), Action: config.RelabelDrop},
+		},
+		Schema: config.Schema{
+			UnknownMetrics: config.SchemaDrop,
+			Metrics:        []*config.MetricSchema{{Name: "up", Mode: config.SchemaPass}},
+		},
+		SpecialValues: config.SpecialValues{NaN: config.SpecialValue{Action: config.SpecialValueDrop}},
+	}
+	client := NewClient(cfg)
+
+	cases := []struct {
+		name      string
+		sample    *model.Sample
+		droppedIn string
+	}{
+		{
+			name:   "written",
+			sample: &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up", "job": "api"}, Value: 1, Timestamp: 1000},
+		},
+		{
+			name:      "dropped by relabeling",
+			sample:    &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up", "job": "db"}, Value: 1, Timestamp: 1000},
+			droppedIn: droppedInRelabel,
+		},
+		{
+			name:      "dropped by schema",
+			sample:    &model.Sample{Metric: model.Metric{model.MetricNameLabel: "down", "job": "api"}, Value: 1, Timestamp: 1000},
+			droppedIn: droppedInSchema,
+		},
+		{
+			name:      "dropped by special values",
+			sample:    &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up", "job": "api"}, Value: model.SampleValue(math.NaN()), Timestamp: 1000},
+			droppedIn: droppedInSpecialValues,
+		},
+	}
+
+	counters := []interface {
+		Write(*dto.Metric) error
+	}{
+		schemaViolations.WithLabelValues("kairosdb", "", "", unknownMetricReason, string(config.SchemaDrop)),
+		specialValues.WithLabelValues("kairosdb", "NaN", string(config.SpecialValueDrop)),
+	}
+	before := make([]float64, len(counters))
+	for i, counter := range counters {
+		var metric dto.Metric
+		counter.Write(&metric)
+		before[i] = metric.GetCounter().GetValue()
+	}
+
+	for _, c := range cases {
+		e := client.Explain(c.sample)
+		assert.Equal(t, c.droppedIn, e.DroppedIn, c.name)
+		assert.Equal(t, c.droppedIn == "", len(e.DataPoints) > 0, c.name)
+	}
+
+	// explaining doesn't count the dropped samples
+	for i, counter := range counters {
+		var metric dto.Metric
+		counter.Write(&metric)
+		assert.Equal(t, before[i], metric.GetCounter().GetValue())
+	}
+}
@@ -0,0 +1,170 @@
+package kairosdb
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+)
+
+const (
+	histogramType = "histogram"
+
+	bucketSuffix = "_bucket"
+	sumSuffix    = "_sum"
+	countSuffix  = "_count"
+
+	// maxPrecision keeps all mantissa bits, used for custom bucket layouts
+	maxPrecision = 52
+)
+
+// HistogramSample is a native histogram of a single series
+type HistogramSample struct {
+	Metric    model.Metric
+	Timestamp model.Time
+	Histogram *remote.Histogram
+}
+
+// HistogramValue is the value of a KairosDB histogram datapoint. Bins map
+// the upper bound of each populated bucket to its count.
+type HistogramValue struct {
+	Bins      map[string]int64 `json:"bins"`
+	Min       float64          `json:"min"`
+	Max       float64          `json:"max"`
+	Sum       float64          `json:"sum"`
+	Precision int              `json:"precision"`
+}
+
+// HistogramsToSamples expands native histograms into the _bucket, _sum and
+// _count series of a classic Prometheus histogram. Bucket series are
+// cumulative and carry their upper bound in the le label.
+func HistogramsToSamples(histograms []*HistogramSample) model.Samples {
+	var samples model.Samples
+	for _, hs := range histograms {
+		buckets, err := hs.Histogram.Buckets()
+		if err != nil {
+			logrus.Debugf("dropping native histogram of metric [%s]: %s", hs.Metric, err)
+			continue
+		}
+
+		name := hs.Metric[model.MetricNameLabel]
+		var cumulative float64
+		for _, b := range buckets {
+			cumulative += b.Count
+			if math.IsInf(b.Upper, 1) {
+				continue
+			}
+			samples = append(samples, histogramSample(hs, name+bucketSuffix, formatBound(b.Upper), cumulative))
+		}
+		samples = append(samples,
+			histogramSample(hs, name+bucketSuffix, formatBound(math.Inf(1)), hs.Histogram.Count()),
+			histogramSample(hs, name+sumSuffix, "", hs.Histogram.Sum),
+			histogramSample(hs, name+countSuffix, "", hs.Histogram.Count()),
+		)
+	}
+	return samples
+}
+
+func histogramSample(hs *HistogramSample, name model.LabelValue, le string, value float64) *model.Sample {
+	metric := hs.Metric.Clone()
+	metric[model.MetricNameLabel] = name
+	if le != "" {
+		metric[model.BucketLabel] = model.LabelValue(le)
+	}
+	return &model.Sample{
+		Metric:    metric,
+		Value:     model.SampleValue(value),
+		Timestamp: hs.Timestamp,
+	}
+}
+
+// FilterAndProcessHistograms applies the relabel configs to native histograms
+// and converts them to datapoints of the KairosDB histogram type.
+func FilterAndProcessHistograms(histograms []*HistogramSample, cfg *config.Config) []*DataPoint {
+	return filterAndProcessHistograms(histograms, cfg, newSchemaIndex(cfg.Schema), processRelabelConfigs(cfg), noRemote)
+}
+
+func filterAndProcessHistograms(histograms []*HistogramSample, cfg *config.Config, schema schemaIndex, relabelMetrics relabelFunc, remote string) (datapoints []*DataPoint) {
+	relabeled := make([]model.Metric, len(histograms))
+	for i, hs := range histograms {
+		relabeled[i] = hs.Metric.Clone()
+	}
+	relabelMetrics(relabeled)
+
+	for i, hs := range histograms {
+		ttl, ttlClass := ttl(hs.Metric, cfg.TTL)
+		metric := relabeled[i]
+		if metric == nil {
+			continue
+		}
+		if metric = schema.apply(metric, cfg.Schema, remote); metric == nil {
+			continue
+		}
+
+		value, err := histogramValue(hs.Histogram)
+		if err != nil {
+			logrus.Debugf("dropping native histogram of metric [%s]: %s", metric, err)
+			continue
+		}
+
+		datapoints = append(datapoints, &DataPoint{
+			Name:      string(metric[model.MetricNameLabel]),
+			Timestamp: int64(hs.Timestamp),
+			Type:      histogramType,
+			Histogram: value,
+			Tags:      tagsFromMetric(metric),
+			TTL:       ttl,
+			ttlClass:  ttlClass,
+		})
+	}
+	return
+}
+
+func histogramValue(h *remote.Histogram) (*HistogramValue, error) {
+	buckets, err := h.Buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	value := &HistogramValue{
+		Bins: make(map[string]int64, len(buckets)),
+		Sum:  h.Sum,
+	}
+	switch {
+	case h.Schema == remote.CustomBucketsSchema:
+		value.Precision = maxPrecision
+	case h.Schema > 0:
+		value.Precision = int(h.Schema)
+	}
+
+	first := true
+	for _, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+
+		// buckets unbounded on one side are represented by their finite bound
+		lower, upper := b.Lower, b.Upper
+		if math.IsInf(lower, -1) {
+			lower = upper
+		}
+		if math.IsInf(upper, 1) {
+			upper = lower
+		}
+		value.Bins[formatBound(upper)] += int64(math.Floor(b.Count + 0.5))
+
+		if first {
+			value.Min = lower
+			first = false
+		}
+		value.Max = upper
+	}
+	return value, nil
+}
+
+func formatBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'f', -1, 64)
+}
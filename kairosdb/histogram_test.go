@@ -0,0 +1,201 @@
+package kairosdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	histogramMetric = model.Metric{
+		model.MetricNameLabel: "request_duration_seconds",
+		"job":                 "api",
+	}
+
+	// integerHistogram has a negative bucket (-2,-1], a zero bucket and the
+	// positive buckets (0.5,1], (1,2], (4,8] and (8,16].
+	integerHistogram = &remote.Histogram{
+		CountInt:       9,
+		Sum:            18.4,
+		Schema:         0,
+		ZeroThreshold:  0.001,
+		ZeroCountInt:   2,
+		NegativeSpans:  []remote.BucketSpan{{Offset: 1, Length: 1}},
+		NegativeDeltas: []int64{2},
+		PositiveSpans:  []remote.BucketSpan{{Offset: 0, Length: 2}, {Offset: 1, Length: 2}},
+		PositiveDeltas: []int64{1, 1, -1, 0},
+	}
+
+	// floatHistogram has the positive buckets (2^-1,2^-0.5], (2^-0.5,1] and (1,2^0.5].
+	floatHistogram = &remote.Histogram{
+		IsFloat:        true,
+		CountFloat:     4,
+		Sum:            3.25,
+		Schema:         1,
+		PositiveSpans:  []remote.BucketSpan{{Offset: -1, Length: 3}},
+		PositiveCounts: []float64{0.5, 2, 1.5},
+	}
+)
+
+func histogramSeries(suffix, le string, value float64, ts model.Time) *model.Sample {
+	metric := histogramMetric.Clone()
+	metric[model.MetricNameLabel] += model.LabelValue(suffix)
+	if le != "" {
+		metric[model.BucketLabel] = model.LabelValue(le)
+	}
+	return &model.Sample{Metric: metric, Value: model.SampleValue(value), Timestamp: ts}
+}
+
+func TestHistogramsToSamples(t *testing.T) {
+	cases := []struct {
+		name       string
+		histograms []*HistogramSample
+		samples    model.Samples
+	}{
+		{
+			name: "integer histogram",
+			histograms: []*HistogramSample{
+				{Metric: histogramMetric, Timestamp: 1000, Histogram: integerHistogram},
+			},
+			samples: model.Samples{
+				histogramSeries("_bucket", "-1", 2, 1000),
+				histogramSeries("_bucket", "0.001", 4, 1000),
+				histogramSeries("_bucket", "1", 5, 1000),
+				histogramSeries("_bucket", "2", 7, 1000),
+				histogramSeries("_bucket", "8", 8, 1000),
+				histogramSeries("_bucket", "16", 9, 1000),
+				histogramSeries("_bucket", "+Inf", 9, 1000),
+				histogramSeries("_sum", "", 18.4, 1000),
+				histogramSeries("_count", "", 9, 1000),
+			},
+		},
+		{
+			name: "float histogram",
+			histograms: []*HistogramSample{
+				{Metric: histogramMetric, Timestamp: 1000, Histogram: floatHistogram},
+			},
+			samples: model.Samples{
+				histogramSeries("_bucket", "0.7071067811865476", 0.5, 1000),
+				histogramSeries("_bucket", "1", 2.5, 1000),
+				histogramSeries("_bucket", "1.4142135623730951", 4, 1000),
+				histogramSeries("_bucket", "+Inf", 4, 1000),
+				histogramSeries("_sum", "", 3.25, 1000),
+				histogramSeries("_count", "", 4, 1000),
+			},
+		},
+		{
+			name: "spans not matching the bucket counts",
+			histograms: []*HistogramSample{
+				{
+					Metric:    histogramMetric,
+					Timestamp: 1000,
+					Histogram: &remote.Histogram{
+						CountInt:       1,
+						PositiveSpans:  []remote.BucketSpan{{Offset: 0, Length: 2}},
+						PositiveDeltas: []int64{1},
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		actual := HistogramsToSamples(c.histograms)
+		assert.Equal(t, c.samples, actual, c.name)
+	}
+}
+
+func TestFilterAndProcessHistograms(t *testing.T) {
+	cfg, err := config.ParseCfgFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("failed to parse config file: %s", err)
+	}
+
+	cases := []struct {
+		name       string
+		histograms []*HistogramSample
+		datapoints []*DataPoint
+	}{
+		{
+			name: "integer histogram",
+			histograms: []*HistogramSample{
+				{Metric: histogramMetric, Timestamp: 1000, Histogram: integerHistogram},
+			},
+			datapoints: []*DataPoint{
+				{
+					Name:      "my-prefix.request_duration_seconds",
+					Timestamp: 1000,
+					Type:      "histogram",
+					Tags:      map[string]string{"job": "api"},
+					Histogram: &HistogramValue{
+						Bins: map[string]int64{
+							"-1": 2, "0.001": 2, "1": 1, "2": 2, "8": 1, "16": 1,
+						},
+						Min: -2,
+						Max: 16,
+						Sum: 18.4,
+					},
+				},
+			},
+		},
+		{
+			name: "float histogram",
+			histograms: []*HistogramSample{
+				{Metric: histogramMetric, Timestamp: 1000, Histogram: floatHistogram},
+			},
+			datapoints: []*DataPoint{
+				{
+					Name:      "my-prefix.request_duration_seconds",
+					Timestamp: 1000,
+					Type:      "histogram",
+					Tags:      map[string]string{"job": "api"},
+					Histogram: &HistogramValue{
+						Bins: map[string]int64{
+							"0.7071067811865476": 1, "1": 2, "1.4142135623730951": 2,
+						},
+						Min:       0.5,
+						Max:       1.4142135623730951,
+						Sum:       3.25,
+						Precision: 1,
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		actual := FilterAndProcessHistograms(c.histograms, cfg)
+		assert.Equal(t, c.datapoints, actual, c.name)
+	}
+
+	assert.Equal(t, model.LabelValue("request_duration_seconds"), histogramMetric[model.MetricNameLabel], "input metric must not be relabeled in place")
+}
+
+func TestHistogramDataPointJSON(t *testing.T) {
+	datapoint := &DataPoint{
+		Name:      "request_duration_seconds",
+		Timestamp: 1000,
+		Type:      "histogram",
+		Tags:      map[string]string{"job": "api"},
+		Histogram: &HistogramValue{
+			Bins: map[string]int64{"2": 1},
+			Min:  1,
+			Max:  2,
+			Sum:  1.5,
+		},
+	}
+
+	actual, err := json.Marshal(datapoint)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"name": "request_duration_seconds",
+		"timestamp": 1000,
+		"type": "histogram",
+		"tags": {"job": "api"},
+		"value": {"bins": {"2": 1}, "min": 1, "max": 2, "sum": 1.5, "precision": 0}
+	}`, string(actual))
+}
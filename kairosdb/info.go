@@ -0,0 +1,51 @@
+package kairosdb
+
+import (
+	"encoding/json"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const stringType = "string"
+
+// infoRule returns the first info metric rule matching the metric as received
+func infoRule(metric model.Metric, cfg config.InfoMetrics) *config.InfoRule {
+	for _, rule := range cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// infoValue encodes the value labels of the metric as JSON object. Missing
+// labels are encoded as empty string.
+func infoValue(metric model.Metric, rule *config.InfoRule) string {
+	values := make(map[string]string, len(rule.ValueLabels))
+	for _, name := range rule.ValueLabels {
+		values[string(name)] = string(metric[name])
+	}
+	buf, _ := json.Marshal(values)
+	return string(buf)
+}
+
+// infoTags removes the value labels from the tags and keeps only the
+// identifying labels, if there are any
+func infoTags(tags map[string]string, rule *config.InfoRule) map[string]string {
+	for _, name := range rule.ValueLabels {
+		delete(tags, string(name))
+	}
+	if len(rule.IdentifyingLabels) == 0 {
+		return tags
+	}
+
+	identifying := make(map[string]string, len(rule.IdentifyingLabels))
+	for _, name := range rule.IdentifyingLabels {
+		if value, ok := tags[string(name)]; ok {
+			identifying[string(name)] = value
+		}
+	}
+	return identifying
+}
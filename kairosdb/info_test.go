@@ -0,0 +1,141 @@
+package kairosdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterAndProcessSamplesInfoMetrics(t *testing.T) {
+	cfg := &config.Config{
+		InfoMetrics: config.InfoMetrics{
+			Rules: []*config.InfoRule{
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{model.MetricNameLabel},
+							Regex:        config.MustNewRegexp("kube_pod_info"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					ValueLabels:       model.LabelNames{"node", "pod_ip", "host_ip"},
+					IdentifyingLabels: model.LabelNames{"namespace", "pod"},
+				},
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{model.MetricNameLabel},
+							Regex:        config.MustNewRegexp("build_info"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					ValueLabels: model.LabelNames{"version"},
+				},
+			},
+		},
+	}
+
+	samples := model.Samples{
+		{
+			Metric: model.Metric{
+				model.MetricNameLabel: "kube_pod_info",
+				"namespace":           "web",
+				"pod":                 "web-1",
+				"node":                "node-1",
+				"pod_ip":              "10.0.0.1",
+				"created_by":          "web",
+			},
+			Value:     1,
+			Timestamp: 1000,
+		},
+		{
+			Metric:    model.Metric{model.MetricNameLabel: "build_info", "job": "api", "version": "1.2.3"},
+			Value:     1,
+			Timestamp: 1000,
+		},
+		{
+			Metric:    model.Metric{model.MetricNameLabel: "up", "job": "api"},
+			Value:     1,
+			Timestamp: 1000,
+		},
+	}
+
+	expected := []*DataPoint{
+		{
+			Name:        "kube_pod_info",
+			Timestamp:   1000,
+			Tags:        map[string]string{"namespace": "web", "pod": "web-1"},
+			Type:        "string",
+			StringValue: `{"host_ip":"","node":"node-1","pod_ip":"10.0.0.1"}`,
+		},
+		{
+			Name:        "build_info",
+			Timestamp:   1000,
+			Tags:        map[string]string{"job": "api"},
+			Type:        "string",
+			StringValue: `{"version":"1.2.3"}`,
+		},
+		{
+			Name:      "up",
+			Timestamp: 1000,
+			Value:     1,
+			Tags:      map[string]string{"job": "api"},
+		},
+	}
+
+	assert.Equal(t, expected, FilterAndProcessSamples(samples, cfg))
+}
+
+func TestStringDataPointJSON(t *testing.T) {
+	datapoint := &DataPoint{
+		Name:        "build_info",
+		Timestamp:   1000,
+		Type:        "string",
+		Tags:        map[string]string{"job": "api"},
+		StringValue: `{"version":"1.2.3"}`,
+	}
+
+	actual, err := json.Marshal(datapoint)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"name": "build_info",
+		"timestamp": 1000,
+		"type": "string",
+		"tags": {"job": "api"},
+		"value": "{\"version\":\"1.2.3\"}"
+	}`, string(actual))
+}
+
+func TestFilterAndProcessSamplesInfoMetricOfSeries(t *testing.T) {
+	cfg := &config.Config{
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("version"), Action: config.RelabelLabelDrop},
+		},
+		InfoMetrics: config.InfoMetrics{
+			Rules: []*config.InfoRule{
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{model.MetricNameLabel},
+							Regex:        config.MustNewRegexp("build_info"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					ValueLabels: model.LabelNames{"version"},
+				},
+			},
+		},
+	}
+
+	// the samples of a series share the metric, whose value label is dropped
+	samples := seriesSamples(model.Metric{model.MetricNameLabel: "build_info", "version": "1.2.3"}, 1, 1)
+
+	datapoints := FilterAndProcessSamples(samples, cfg)
+	assert.Len(t, datapoints, 2)
+	for _, datapoint := range datapoints {
+		assert.Equal(t, `{"version":"1.2.3"}`, datapoint.StringValue)
+	}
+}
@@ -0,0 +1,200 @@
+package kairosdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+	"golang.org/x/net/context/ctxhttp"
+)
+
+const (
+	metadataEndpoint = "/api/v1/metadata"
+	contentTypeText  = "text/plain"
+)
+
+// familySuffixes are stripped from series names to find their metric family
+var familySuffixes = []string{"_bucket", "_sum", "_count", "_total", "_info", "_created", "_gsum", "_gcount"}
+
+// metadataCache remembers the metadata written to KairosDB, so it is only
+// written again when it changes, and the type of every metric family.
+type metadataCache struct {
+	sync.RWMutex
+	written map[string]map[string]string
+	types   map[string]remote.MetricType
+	// accessed atomically, set while metadata is written in the background
+	writing int32
+}
+
+// metadataWrite is a metadata value of a metric to write
+type metadataWrite struct {
+	name, key, value string
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{
+		written: make(map[string]map[string]string),
+		types:   make(map[string]remote.MetricType),
+	}
+}
+
+// SendMetadata records the types of metric families and stores their
+// metadata through the KairosDB metadata API in the background, so a slow
+// API doesn't delay the samples. Only values which changed since the last
+// write are written. While metadata is being written, the metadata of other
+// requests is skipped, Prometheus sends it again periodically.
+func (c *Client) SendMetadata(metadata []remote.MetricMetadata) {
+	writes := c.metadataWrites(metadata)
+	if len(writes) == 0 || !atomic.CompareAndSwapInt32(&c.metadata.writing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.metadata.writing, 0)
+		c.writeMetadataValues(writes)
+	}()
+}
+
+// metadataWrites records the types of the metric families and returns the
+// metadata values which changed. The metric families are named like their
+// datapoints, relabeled as series with only the metric name and sanitized.
+// Metric families dropped by relabeling are skipped.
+func (c *Client) metadataWrites(metadata []remote.MetricMetadata) []metadataWrite {
+	var writes []metadataWrite
+	for _, md := range metadata {
+		if c.cfg.Metadata.TypeTag != "" {
+			c.metadata.setType(md.MetricFamilyName, md.Type)
+		}
+		if !c.cfg.Metadata.Enabled {
+			continue
+		}
+
+		metric := c.relabeler.ProcessUncounted(model.Metric{model.MetricNameLabel: model.LabelValue(md.MetricFamilyName)})
+		if metric == nil {
+			continue
+		}
+		name := sanitizeValue(string(metric[model.MetricNameLabel]), c.cfg.Sanitize.MetricNames, metricNameRule, "", noRemote)
+
+		values := map[string]string{
+			"type": md.Type.String(),
+			"help": md.Help,
+			"unit": md.Unit,
+		}
+		for key, value := range values {
+			if value == "" || c.metadata.get(name, key) == value {
+				continue
+			}
+			writes = append(writes, metadataWrite{name: name, key: key, value: value})
+		}
+	}
+	return writes
+}
+
+// writeMetadataValues writes the metadata values and returns the last error
+func (c *Client) writeMetadataValues(writes []metadataWrite) error {
+	var lastErr error
+	for _, w := range writes {
+		if err := c.writeMetadata(w.name, w.key, w.value); err != nil {
+			logrus.Errorf("failed writing metadata [%s] of metric [%s]. error: %s", w.key, w.name, err)
+			failedMetadata.WithLabelValues(c.name()).Inc()
+			lastErr = err
+			continue
+		}
+		sentMetadata.WithLabelValues(c.name()).Inc()
+		c.metadata.set(w.name, w.key, w.value)
+	}
+	return lastErr
+}
+
+func (c *Client) writeMetadata(name, key, value string) error {
+	logrus.Debugf("writing metadata [%s] of metric [%s]: %s", key, name, value)
+	if c.cfg.DryRun {
+		return nil
+	}
+
+	// /api/v1/metadata/{service}/{serviceKey}/{key}
+	u := *c.url.URL
+	u.Path = metadataEndpoint
+	u.RawPath = metadataEndpoint
+	for _, part := range []string{c.cfg.Metadata.Service, name, key} {
+		u.Path += "/" + part
+		u.RawPath += "/" + url.PathEscape(part)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), strings.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeText)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	resp, err := ctxhttp.Do(ctx, http.DefaultClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// tagType adds the type of their metric family as tag to the samples
+func (m *metadataCache) tagType(samples model.Samples, tag string) {
+	for _, sample := range samples {
+		metricType := m.familyType(string(sample.Metric[model.MetricNameLabel]))
+		if metricType == remote.MetricTypeUnknown {
+			continue
+		}
+
+		metric := sample.Metric.Clone()
+		metric[model.LabelName(tag)] = model.LabelValue(metricType.String())
+		sample.Metric = metric
+	}
+}
+
+func (m *metadataCache) familyType(name string) remote.MetricType {
+	m.RLock()
+	defer m.RUnlock()
+
+	if metricType, ok := m.types[name]; ok {
+		return metricType
+	}
+	for _, suffix := range familySuffixes {
+		if strings.HasSuffix(name, suffix) {
+			if metricType, ok := m.types[strings.TrimSuffix(name, suffix)]; ok {
+				return metricType
+			}
+		}
+	}
+	return remote.MetricTypeUnknown
+}
+
+func (m *metadataCache) setType(family string, metricType remote.MetricType) {
+	m.Lock()
+	defer m.Unlock()
+	m.types[family] = metricType
+}
+
+func (m *metadataCache) get(name, key string) string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.written[name][key]
+}
+
+func (m *metadataCache) set(name, key, value string) {
+	m.Lock()
+	defer m.Unlock()
+	if m.written[name] == nil {
+		m.written[name] = make(map[string]string)
+	}
+	m.written[name][key] = value
+}
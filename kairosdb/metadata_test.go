@@ -0,0 +1,193 @@
+package kairosdb
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMetadata(t *testing.T) {
+	var writes []string
+	kairos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		writes = append(writes, r.Method+" "+r.URL.EscapedPath()+" "+string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer kairos.Close()
+
+	u, _ := url.Parse(kairos.URL)
+	client := NewClient(&config.Config{
+		KairosdbURL: config.URL{URL: u},
+		Timeout:     time.Second,
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: model.LabelNames{model.MetricNameLabel},
+				Regex:        config.MustNewRegexp("debug_.*"),
+				Action:       config.RelabelDrop,
+			},
+			{
+				SourceLabels: model.LabelNames{model.MetricNameLabel},
+				Regex:        config.MustNewRegexp(".*"),
+				Action:       config.RelabelAddPrefix,
+				Prefix:       "my-prefix.",
+			},
+		},
+		Sanitize: config.Sanitize{
+			MetricNames: config.SanitizeRule{InvalidChars: config.MustNewRegexp(`\s`), Replacement: "_"},
+		},
+		Metadata: config.Metadata{
+			Enabled: true,
+			Service: "prometheus",
+		},
+	})
+
+	cases := []struct {
+		name     string
+		metadata []remote.MetricMetadata
+		writes   []string
+	}{
+		{
+			name: "new metadata is written",
+			metadata: []remote.MetricMetadata{
+				{Type: remote.MetricTypeCounter, MetricFamilyName: "http_requests_total", Help: "Total requests."},
+			},
+			writes: []string{
+				"PUT /api/v1/metadata/prometheus/my-prefix.http_requests_total/help Total requests.",
+				"PUT /api/v1/metadata/prometheus/my-prefix.http_requests_total/type counter",
+			},
+		},
+		{
+			name: "unchanged metadata is not written again",
+			metadata: []remote.MetricMetadata{
+				{Type: remote.MetricTypeCounter, MetricFamilyName: "http_requests_total", Help: "Total requests."},
+			},
+		},
+		{
+			name: "only changed values are written",
+			metadata: []remote.MetricMetadata{
+				{Type: remote.MetricTypeCounter, MetricFamilyName: "http_requests_total", Help: "Total HTTP requests."},
+			},
+			writes: []string{
+				"PUT /api/v1/metadata/prometheus/my-prefix.http_requests_total/help Total HTTP requests.",
+			},
+		},
+		{
+			name: "metric names are escaped",
+			metadata: []remote.MetricMetadata{
+				{Type: remote.MetricTypeGauge, MetricFamilyName: "disk/usage", Unit: "bytes"},
+			},
+			writes: []string{
+				"PUT /api/v1/metadata/prometheus/my-prefix.disk%2Fusage/type gauge",
+				"PUT /api/v1/metadata/prometheus/my-prefix.disk%2Fusage/unit bytes",
+			},
+		},
+		{
+			name: "metric names are sanitized",
+			metadata: []remote.MetricMetadata{
+				{Type: remote.MetricTypeGauge, MetricFamilyName: "disk usage"},
+			},
+			writes: []string{
+				"PUT /api/v1/metadata/prometheus/my-prefix.disk_usage/type gauge",
+			},
+		},
+		{
+			name: "metric families dropped by relabeling are skipped",
+			metadata: []remote.MetricMetadata{
+				{Type: remote.MetricTypeGauge, MetricFamilyName: "debug_goroutines"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		writes = nil
+		err := client.writeMetadataValues(client.metadataWrites(c.metadata))
+		assert.Nil(t, err, c.name)
+		assert.ElementsMatch(t, c.writes, writes, c.name)
+	}
+}
+
+func TestSendMetadataInBackground(t *testing.T) {
+	release := make(chan struct{})
+	written := make(chan string, 2)
+	kairos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		written <- r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer kairos.Close()
+
+	u, _ := url.Parse(kairos.URL)
+	client := NewClient(&config.Config{
+		KairosdbURL: config.URL{URL: u},
+		Timeout:     time.Second,
+		Metadata:    config.Metadata{Enabled: true, Service: "prometheus"},
+	})
+
+	// returns while the metadata API doesn't answer
+	client.SendMetadata([]remote.MetricMetadata{{Type: remote.MetricTypeGauge, MetricFamilyName: "up"}})
+	// skipped, as metadata is being written
+	client.SendMetadata([]remote.MetricMetadata{{Type: remote.MetricTypeGauge, MetricFamilyName: "down"}})
+	close(release)
+
+	select {
+	case path := <-written:
+		assert.Equal(t, "/api/v1/metadata/prometheus/up/type", path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("metadata not written")
+	}
+	select {
+	case path := <-written:
+		t.Fatalf("unexpected write of %s", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTagType(t *testing.T) {
+	cache := newMetadataCache()
+	cache.setType("http_requests_total", remote.MetricTypeCounter)
+	cache.setType("http_request_duration_seconds", remote.MetricTypeHistogram)
+	cache.setType("build", remote.MetricTypeInfo)
+
+	cases := []struct {
+		name    string
+		metric  model.Metric
+		tagType string
+	}{
+		{
+			name:    "family name",
+			metric:  model.Metric{model.MetricNameLabel: "http_requests_total"},
+			tagType: "counter",
+		},
+		{
+			name:    "histogram bucket",
+			metric:  model.Metric{model.MetricNameLabel: "http_request_duration_seconds_bucket", "le": "1"},
+			tagType: "histogram",
+		},
+		{
+			name:    "info metric",
+			metric:  model.Metric{model.MetricNameLabel: "build_info"},
+			tagType: "info",
+		},
+		{
+			name:   "unknown family",
+			metric: model.Metric{model.MetricNameLabel: "up"},
+		},
+	}
+
+	for _, c := range cases {
+		sample := &model.Sample{Metric: c.metric}
+		cache.tagType(model.Samples{sample}, "metric_type")
+
+		assert.Equal(t, model.LabelValue(c.tagType), sample.Metric["metric_type"], c.name)
+		_, tagged := c.metric["metric_type"]
+		assert.False(t, tagged, "case '%s'. input metric must not be modified", c.name)
+	}
+}
@@ -0,0 +1,129 @@
+package kairosdb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+const (
+	metricNameRule = "metric_name"
+	tagKeyRule     = "tag_key"
+	tagValueRule   = "tag_value"
+
+	charactersReason   = "characters"
+	lengthReason       = "length"
+	keyCollisionReason = "key_collision"
+)
+
+var sanitizedValues = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "sanitized_values_total",
+		Help: "Total number of metric names, tag keys and tag values sanitized, by rule and reason.",
+	},
+	[]string{"remote", "rule", "reason"},
+)
+
+// sanitizedTag is a tag whose key or value was sanitized
+type sanitizedTag struct {
+	key   string
+	value string
+	// sanitizedKey is the key the tag is written with
+	sanitizedKey string
+}
+
+// Sanitize applies the sanitize rules to the names and tags of the
+// datapoints, without counting them.
+func Sanitize(datapoints []*DataPoint, cfg config.Sanitize) {
+	sanitize(datapoints, cfg, noRemote)
+}
+
+// sanitize applies the sanitize rules to the names and tags of the datapoints
+func sanitize(datapoints []*DataPoint, cfg config.Sanitize, remote string) {
+	for _, datapoint := range datapoints {
+		job := datapoint.Tags["job"]
+		datapoint.Name = sanitizeValue(datapoint.Name, cfg.MetricNames, metricNameRule, job, remote)
+
+		var changed []sanitizedTag
+		for key, value := range datapoint.Tags {
+			sanitizedKey := sanitizeValue(key, cfg.TagKeys, tagKeyRule, job, remote)
+			sanitizedValue := sanitizeValue(value, cfg.TagValues, tagValueRule, job, remote)
+			if sanitizedKey != key || sanitizedValue != value {
+				changed = append(changed, sanitizedTag{key: key, value: sanitizedValue, sanitizedKey: sanitizedKey})
+			}
+		}
+		if changed != nil {
+			datapoint.Tags = sanitizedTags(datapoint.Tags, changed, job, remote)
+		}
+	}
+}
+
+// sanitizedTags returns a copy of the tags, as they may be shared, with the
+// changed tags replaced. Of the tags whose keys are sanitized to the same key,
+// a tag whose key was valid wins, else the tag of the smallest key, so the
+// same tag is kept for every datapoint. The others are dropped and counted.
+func sanitizedTags(tags map[string]string, changed []sanitizedTag, job, remote string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		result[key] = value
+	}
+	for _, tag := range changed {
+		delete(result, tag.key)
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		iValid, jValid := changed[i].sanitizedKey == changed[i].key, changed[j].sanitizedKey == changed[j].key
+		if iValid != jValid {
+			return iValid
+		}
+		return changed[i].key < changed[j].key
+	})
+	for _, tag := range changed {
+		if _, ok := result[tag.sanitizedKey]; ok {
+			count(sanitizedValues, remote, tagKeyRule, keyCollisionReason)
+			logrus.Debugf("dropped tag [%s] of job [%s], as another tag is written with its sanitized key [%s]", tag.key, job, tag.sanitizedKey)
+			continue
+		}
+		result[tag.sanitizedKey] = tag.value
+	}
+	return result
+}
+
+func sanitizeValue(value string, rule config.SanitizeRule, ruleName, job, remote string) string {
+	sanitized := value
+	if rule.InvalidChars.Regexp != nil {
+		sanitized = rule.InvalidChars.ReplaceAllString(sanitized, rule.Replacement)
+		if sanitized != value {
+			count(sanitizedValues, remote, ruleName, charactersReason)
+		}
+	}
+
+	if rule.MaxLength > 0 && len(sanitized) > rule.MaxLength {
+		sanitized = truncate(sanitized, rule.MaxLength, rule.Replacement)
+		count(sanitizedValues, remote, ruleName, lengthReason)
+	}
+
+	if sanitized != value {
+		logrus.Debugf("sanitized %s [%s] of job [%s] to [%s]", ruleName, value, job, sanitized)
+	}
+	return sanitized
+}
+
+// truncate shortens the value to maxLength bytes, replacing its end with the
+// separator and a hash of the whole value
+func truncate(value string, maxLength int, separator string) string {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	suffix := fmt.Sprintf("%s%0*x", separator, config.HashSuffixLength, h.Sum32())
+
+	end := maxLength - len(suffix)
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end] + suffix
+}
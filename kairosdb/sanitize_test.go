@@ -0,0 +1,79 @@
+package kairosdb
+
+import (
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	cfg := config.Sanitize{
+		MetricNames: config.SanitizeRule{InvalidChars: config.MustNewRegexp(`[^A-Za-z0-9._/-]`), Replacement: "_"},
+		TagKeys:     config.SanitizeRule{InvalidChars: config.MustNewRegexp(`[^A-Za-z0-9._/-]`), Replacement: "_"},
+		TagValues:   config.SanitizeRule{InvalidChars: config.MustNewRegexp(`\s`), Replacement: "-", MaxLength: 20},
+	}
+
+	cases := []struct {
+		name     string
+		in       *DataPoint
+		expected *DataPoint
+	}{
+		{
+			name:     "valid",
+			in:       &DataPoint{Name: "up", Tags: map[string]string{"job": "api"}},
+			expected: &DataPoint{Name: "up", Tags: map[string]string{"job": "api"}},
+		},
+		{
+			name:     "invalid characters",
+			in:       &DataPoint{Name: "up:total", Tags: map[string]string{"job": "api", "a:b": "x y"}},
+			expected: &DataPoint{Name: "up_total", Tags: map[string]string{"job": "api", "a_b": "x-y"}},
+		},
+		{
+			name:     "key sanitized to a valid key",
+			in:       &DataPoint{Name: "up", Tags: map[string]string{"a:b": "x", "a_b": "y z"}},
+			expected: &DataPoint{Name: "up", Tags: map[string]string{"a_b": "y-z"}},
+		},
+		{
+			name:     "keys sanitized to the same key",
+			in:       &DataPoint{Name: "up", Tags: map[string]string{"a b": "x", "a:b": "y"}},
+			expected: &DataPoint{Name: "up", Tags: map[string]string{"a_b": "x"}},
+		},
+		{
+			name:     "too long tag value",
+			in:       &DataPoint{Name: "up", Tags: map[string]string{"path": strings.Repeat("a", 30)}},
+			expected: &DataPoint{Name: "up", Tags: map[string]string{"path": "aaaaaaaaaaa-f262b5c3"}},
+		},
+		{
+			name:     "truncated on rune boundary",
+			in:       &DataPoint{Name: "up", Tags: map[string]string{"path": strings.Repeat("é", 15)}},
+			expected: &DataPoint{Name: "up", Tags: map[string]string{"path": "ééééé-0162b419"}},
+		},
+	}
+
+	collisions := sanitizedValues.WithLabelValues("kairosdb", tagKeyRule, keyCollisionReason)
+	var before dto.Metric
+	collisions.Write(&before)
+
+	for _, c := range cases {
+		sanitize([]*DataPoint{c.in}, cfg, "kairosdb")
+		assert.Equal(t, c.expected, c.in, c.name)
+	}
+
+	// every dropped tag is counted
+	var after dto.Metric
+	collisions.Write(&after)
+	assert.Equal(t, before.GetCounter().GetValue()+2, after.GetCounter().GetValue())
+}
+
+func TestSanitizeCopiesSharedTags(t *testing.T) {
+	tags := map[string]string{"a b": "c"}
+	datapoints := []*DataPoint{{Name: "up", Tags: tags}, {Name: "down", Tags: tags}}
+
+	sanitize(datapoints, config.Sanitize{TagKeys: config.SanitizeRule{InvalidChars: config.MustNewRegexp(`\s`), Replacement: "_"}}, "kairosdb")
+	assert.Equal(t, map[string]string{"a_b": "c"}, datapoints[0].Tags)
+	assert.Equal(t, map[string]string{"a_b": "c"}, datapoints[1].Tags)
+	assert.Equal(t, map[string]string{"a b": "c"}, tags)
+}
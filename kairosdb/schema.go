@@ -0,0 +1,86 @@
+package kairosdb
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+const (
+	unknownMetricReason = "unknown_metric"
+	unknownTagReason    = "unknown_tag"
+	invalidValueReason  = "invalid_value"
+)
+
+var schemaViolations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "schema_violations_total",
+		Help: "Total number of samples violating the schema, by metric, tag, reason and mode they were handled with. The metric is empty for unknown metrics.",
+	},
+	[]string{"remote", "metric", "tag", "reason", "mode"},
+)
+
+// schemaIndex is the schema of every metric by its name, built once per
+// config
+type schemaIndex map[string]*config.MetricSchema
+
+func newSchemaIndex(schema config.Schema) schemaIndex {
+	index := make(schemaIndex, len(schema.Metrics))
+	for _, metric := range schema.Metrics {
+		index[metric.Name] = metric
+	}
+	return index
+}
+
+// apply checks the relabeled metric against its schema. Violating tags are
+// removed in strip mode. nil is returned if the series is dropped.
+func (s schemaIndex) apply(metric model.Metric, cfg config.Schema, remote string) model.Metric {
+	if len(s) == 0 {
+		return metric
+	}
+
+	name := string(metric[model.MetricNameLabel])
+	schema, ok := s[name]
+	if !ok {
+		// unknown metrics are not labeled, as their names are unbounded
+		count(schemaViolations, remote, "", "", unknownMetricReason, string(cfg.UnknownMetrics))
+		if cfg.UnknownMetrics == config.SchemaDrop {
+			logrus.Debugf("dropping series %s of metric without schema", metric)
+			return nil
+		}
+		return metric
+	}
+
+	var violations []model.LabelName
+	for labelName, labelValue := range metric {
+		if labelName == model.MetricNameLabel || labelValue == "" {
+			continue
+		}
+
+		pattern, ok := schema.Tags[string(labelName)]
+		reason := unknownTagReason
+		if ok {
+			if pattern.Regexp == nil || pattern.MatchString(string(labelValue)) {
+				continue
+			}
+			reason = invalidValueReason
+		}
+		count(schemaViolations, remote, name, string(labelName), reason, string(schema.Mode))
+		violations = append(violations, labelName)
+	}
+	if len(violations) == 0 {
+		return metric
+	}
+
+	switch schema.Mode {
+	case config.SchemaDrop:
+		logrus.Debugf("dropping series %s violating the schema with tags %v", metric, violations)
+		return nil
+	case config.SchemaStrip:
+		for _, labelName := range violations {
+			delete(metric, labelName)
+		}
+	}
+	return metric
+}
@@ -0,0 +1,104 @@
+package kairosdb
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterAndProcessSamplesSchema(t *testing.T) {
+	schema := func(mode, unknownMetrics config.SchemaMode) config.Schema {
+		return config.Schema{
+			Mode:           mode,
+			UnknownMetrics: unknownMetrics,
+			Metrics: []*config.MetricSchema{
+				{
+					Name: "http_requests_total",
+					Mode: mode,
+					Tags: map[string]config.Regexp{"job": {}, "code": config.MustNewRegexp("^[1-5][0-9][0-9]$")},
+				},
+			},
+		}
+	}
+	sample := func(metric model.Metric) model.Samples {
+		return model.Samples{{Metric: metric, Value: 1, Timestamp: 1000}}
+	}
+	datapoint := func(name string, tags map[string]string) []*DataPoint {
+		return []*DataPoint{{Name: name, Timestamp: 1000, Value: 1, Tags: tags}}
+	}
+
+	cases := []struct {
+		name       string
+		schema     config.Schema
+		metric     model.Metric
+		datapoints []*DataPoint
+	}{
+		{
+			name:       "without schema",
+			metric:     model.Metric{model.MetricNameLabel: "up", "job": "api"},
+			datapoints: datapoint("up", map[string]string{"job": "api"}),
+		},
+		{
+			name:       "valid series",
+			schema:     schema(config.SchemaDrop, config.SchemaDrop),
+			metric:     model.Metric{model.MetricNameLabel: "http_requests_total", "job": "api", "code": "200"},
+			datapoints: datapoint("http_requests_total", map[string]string{"job": "api", "code": "200"}),
+		},
+		{
+			name:       "unknown metric passed",
+			schema:     schema(config.SchemaDrop, config.SchemaPass),
+			metric:     model.Metric{model.MetricNameLabel: "up", "job": "api"},
+			datapoints: datapoint("up", map[string]string{"job": "api"}),
+		},
+		{
+			name:   "unknown metric dropped",
+			schema: schema(config.SchemaPass, config.SchemaDrop),
+			metric: model.Metric{model.MetricNameLabel: "up", "job": "api"},
+		},
+		{
+			name:       "violating tags passed",
+			schema:     schema(config.SchemaPass, config.SchemaPass),
+			metric:     model.Metric{model.MetricNameLabel: "http_requests_total", "job": "api", "code": "abc", "pod": "web-1"},
+			datapoints: datapoint("http_requests_total", map[string]string{"job": "api", "code": "abc", "pod": "web-1"}),
+		},
+		{
+			name:       "violating tags stripped",
+			schema:     schema(config.SchemaStrip, config.SchemaPass),
+			metric:     model.Metric{model.MetricNameLabel: "http_requests_total", "job": "api", "code": "abc", "pod": "web-1"},
+			datapoints: datapoint("http_requests_total", map[string]string{"job": "api"}),
+		},
+		{
+			name:   "violating tags dropped",
+			schema: schema(config.SchemaDrop, config.SchemaPass),
+			metric: model.Metric{model.MetricNameLabel: "http_requests_total", "job": "api", "pod": "web-1"},
+		},
+	}
+
+	for _, c := range cases {
+		cfg := &config.Config{Schema: c.schema}
+		assert.Equal(t, c.datapoints, FilterAndProcessSamples(sample(c.metric), cfg), c.name)
+	}
+}
+
+func TestSchemaUnknownMetricsNotLabeled(t *testing.T) {
+	cfg := config.Schema{
+		UnknownMetrics: config.SchemaPass,
+		Metrics:        []*config.MetricSchema{{Name: "up", Mode: config.SchemaStrip}},
+	}
+	unknown := schemaViolations.WithLabelValues("kairosdb", "", "", unknownMetricReason, string(config.SchemaPass))
+	var before dto.Metric
+	unknown.Write(&before)
+
+	schema := newSchemaIndex(cfg)
+	for _, name := range []model.LabelValue{"a", "b"} {
+		metric := model.Metric{model.MetricNameLabel: name}
+		assert.Equal(t, metric, schema.apply(metric.Clone(), cfg, "kairosdb"))
+	}
+
+	var after dto.Metric
+	unknown.Write(&after)
+	assert.Equal(t, before.GetCounter().GetValue()+2, after.GetCounter().GetValue())
+}
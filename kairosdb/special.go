@@ -0,0 +1,54 @@
+package kairosdb
+
+import (
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/processor"
+)
+
+var specialValues = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "special_values_total",
+		Help: "Total number of stale markers, NaN and infinite values received, by how they were handled.",
+	},
+	[]string{"remote", "value", "action"},
+)
+
+// specialValue returns the value to write instead of a stale marker, NaN or
+// infinite value, and whether it is a stale marker. The value is not
+// written if ok is false.
+func specialValue(value float64, cfg config.SpecialValues, remote string) (replacement float64, stale bool, ok bool) {
+	if processor.IsStaleMarker(value) {
+		action := cfg.StaleMarkers.Action
+		if action == "" {
+			action = config.StaleMarkerDrop
+		}
+		count(specialValues, remote, "stale", string(action))
+
+		switch action {
+		case config.StaleMarkerDatapoint:
+			return 1, true, true
+		case config.StaleMarkerTag:
+			return 0, true, true
+		default:
+			return 0, true, false
+		}
+	}
+
+	kind, special := "NaN", cfg.NaN
+	switch {
+	case math.IsInf(value, 1):
+		kind, special = "+Inf", cfg.PositiveInf
+	case math.IsInf(value, -1):
+		kind, special = "-Inf", cfg.NegativeInf
+	}
+
+	if special.Action != config.SpecialValueSentinel {
+		count(specialValues, remote, kind, string(config.SpecialValueDrop))
+		return 0, false, false
+	}
+	count(specialValues, remote, kind, string(config.SpecialValueSentinel))
+	return special.Sentinel, false, true
+}
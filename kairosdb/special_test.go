@@ -0,0 +1,82 @@
+package kairosdb
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/processor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterAndProcessSamplesSpecialValues(t *testing.T) {
+	staleMarker := math.Float64frombits(processor.StaleNaN)
+	sample := func(value float64) model.Samples {
+		return model.Samples{{Metric: model.Metric{model.MetricNameLabel: "up", "job": "api"}, Value: model.SampleValue(value), Timestamp: 1000}}
+	}
+	datapoint := func(name string, value float64, tags map[string]string) []*DataPoint {
+		return []*DataPoint{{Name: name, Timestamp: 1000, Value: value, Tags: tags}}
+	}
+	sentinels := config.SpecialValues{
+		NaN:         config.SpecialValue{Action: config.SpecialValueSentinel, Sentinel: -1},
+		PositiveInf: config.SpecialValue{Action: config.SpecialValueSentinel, Sentinel: math.MaxFloat64},
+		NegativeInf: config.SpecialValue{Action: config.SpecialValueSentinel, Sentinel: -math.MaxFloat64},
+	}
+
+	cases := []struct {
+		name       string
+		special    config.SpecialValues
+		value      float64
+		datapoints []*DataPoint
+	}{
+		{
+			name:  "stale marker dropped by default",
+			value: staleMarker,
+		},
+		{
+			name:       "stale marker datapoint",
+			special:    config.SpecialValues{StaleMarkers: config.StaleMarkers{Action: config.StaleMarkerDatapoint, MetricSuffix: "_stale"}},
+			value:      staleMarker,
+			datapoints: datapoint("up_stale", 1, map[string]string{"job": "api"}),
+		},
+		{
+			name:       "stale marker tag",
+			special:    config.SpecialValues{StaleMarkers: config.StaleMarkers{Action: config.StaleMarkerTag, Tag: "stale"}},
+			value:      staleMarker,
+			datapoints: datapoint("up", 0, map[string]string{"job": "api", "stale": "true"}),
+		},
+		{
+			name:    "NaN is no stale marker",
+			special: config.SpecialValues{StaleMarkers: config.StaleMarkers{Action: config.StaleMarkerTag, Tag: "stale"}},
+			value:   math.NaN(),
+		},
+		{
+			name:  "infinity dropped by default",
+			value: math.Inf(1),
+		},
+		{
+			name:       "NaN sentinel",
+			special:    sentinels,
+			value:      math.NaN(),
+			datapoints: datapoint("up", -1, map[string]string{"job": "api"}),
+		},
+		{
+			name:       "positive infinity sentinel",
+			special:    sentinels,
+			value:      math.Inf(1),
+			datapoints: datapoint("up", math.MaxFloat64, map[string]string{"job": "api"}),
+		},
+		{
+			name:       "negative infinity sentinel",
+			special:    sentinels,
+			value:      math.Inf(-1),
+			datapoints: datapoint("up", -math.MaxFloat64, map[string]string{"job": "api"}),
+		},
+	}
+
+	for _, c := range cases {
+		cfg := &config.Config{SpecialValues: c.special}
+		assert.Equal(t, c.datapoints, FilterAndProcessSamples(sample(c.value), cfg), c.name)
+	}
+}
@@ -0,0 +1,151 @@
+package kairosdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+const (
+	tooOldReason = "too_old"
+	tooNewReason = "too_new"
+
+	// invalidTimestampLogInterval limits how often a sample with an invalid
+	// timestamp is logged per reason
+	invalidTimestampLogInterval = time.Minute
+)
+
+var invalidTimestamps = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "invalid_timestamp_samples_total",
+		Help: "Total number of samples with a timestamp outside of the accepted window, by reason and action.",
+	},
+	[]string{"remote", "reason", "action"},
+)
+
+// timestampValidator checks sample timestamps against the window around
+// the receive time. Offending samples are logged once per interval and reason.
+type timestampValidator struct {
+	mtx        sync.Mutex
+	cfg        config.Timestamps
+	lastLogged map[string]time.Time
+	suppressed map[string]int
+	now        func() time.Time
+}
+
+func newTimestampValidator(cfg config.Timestamps) *timestampValidator {
+	return &timestampValidator{
+		cfg:        cfg,
+		lastLogged: make(map[string]time.Time),
+		suppressed: make(map[string]int),
+		now:        time.Now,
+	}
+}
+
+func (v *timestampValidator) enabled() bool {
+	return v.cfg.MaxSampleAge > 0 || v.cfg.MaxFutureSkew > 0
+}
+
+// samples returns the samples with the invalid timestamps handled
+func (v *timestampValidator) samples(samples model.Samples, remote string) model.Samples {
+	if !v.enabled() {
+		return samples
+	}
+
+	received := v.now()
+	result := make(model.Samples, 0, len(samples))
+	for _, sample := range samples {
+		metric, timestamp, ok := v.validate(sample.Metric, sample.Timestamp, received, remote)
+		if !ok {
+			continue
+		}
+		if timestamp != sample.Timestamp || !metric.Equal(sample.Metric) {
+			sample = &model.Sample{Metric: metric, Value: sample.Value, Timestamp: timestamp}
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// histograms returns the histograms with the invalid timestamps handled
+func (v *timestampValidator) histograms(histograms []*HistogramSample, remote string) []*HistogramSample {
+	if !v.enabled() {
+		return histograms
+	}
+
+	received := v.now()
+	result := make([]*HistogramSample, 0, len(histograms))
+	for _, hs := range histograms {
+		metric, timestamp, ok := v.validate(hs.Metric, hs.Timestamp, received, remote)
+		if !ok {
+			continue
+		}
+		result = append(result, &HistogramSample{Metric: metric, Timestamp: timestamp, Histogram: hs.Histogram})
+	}
+	return result
+}
+
+// exemplars returns the exemplars with the invalid timestamps handled
+func (v *timestampValidator) exemplars(exemplars []*ExemplarSample, remote string) []*ExemplarSample {
+	if !v.enabled() {
+		return exemplars
+	}
+
+	received := v.now()
+	result := make([]*ExemplarSample, 0, len(exemplars))
+	for _, e := range exemplars {
+		metric, timestamp, ok := v.validate(e.Metric, e.Timestamp, received, remote)
+		if !ok {
+			continue
+		}
+		result = append(result, &ExemplarSample{Metric: metric, Labels: e.Labels, Value: e.Value, Timestamp: timestamp})
+	}
+	return result
+}
+
+// validate returns the metric and timestamp to write, ok is false if the
+// sample is rejected
+func (v *timestampValidator) validate(metric model.Metric, timestamp model.Time, received time.Time, remote string) (model.Metric, model.Time, bool) {
+	var reason string
+	t := timestamp.Time()
+	switch {
+	case v.cfg.MaxSampleAge > 0 && received.Sub(t) > v.cfg.MaxSampleAge:
+		reason = tooOldReason
+	case v.cfg.MaxFutureSkew > 0 && t.Sub(received) > v.cfg.MaxFutureSkew:
+		reason = tooNewReason
+	default:
+		return metric, timestamp, true
+	}
+
+	count(invalidTimestamps, remote, reason, string(v.cfg.Action))
+	v.log(reason, metric, t, received)
+
+	switch v.cfg.Action {
+	case config.TimestampClamp:
+		return metric, model.TimeFromUnixNano(received.UnixNano()), true
+	case config.TimestampQuarantine:
+		quarantined := metric.Clone()
+		quarantined[model.MetricNameLabel] = model.LabelValue(v.cfg.QuarantinePrefix) + metric[model.MetricNameLabel]
+		return quarantined, timestamp, true
+	default:
+		return nil, 0, false
+	}
+}
+
+func (v *timestampValidator) log(reason string, metric model.Metric, t, received time.Time) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if received.Sub(v.lastLogged[reason]) < invalidTimestampLogInterval {
+		v.suppressed[reason]++
+		return
+	}
+	logrus.Warnf("sample of metric [%s] has timestamp %s %s received at %s, action %s. %d more since last logged",
+		metric, t.UTC().Format(time.RFC3339), reason, received.UTC().Format(time.RFC3339), v.cfg.Action, v.suppressed[reason])
+	v.lastLogged[reason] = received
+	v.suppressed[reason] = 0
+}
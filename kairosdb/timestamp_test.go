@@ -0,0 +1,71 @@
+package kairosdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampValidator(t *testing.T) {
+	received := time.Unix(1000000, 0)
+	now := model.TimeFromUnixNano(received.UnixNano())
+	sample := func(name model.LabelValue, ts model.Time) *model.Sample {
+		return &model.Sample{Metric: model.Metric{model.MetricNameLabel: name}, Value: 1, Timestamp: ts}
+	}
+
+	samples := model.Samples{
+		sample("up", now-model.Time(2*time.Hour/time.Millisecond)),
+		sample("up", now-model.Time(30*time.Minute/time.Millisecond)),
+		sample("up", now+model.Time(5*time.Minute/time.Millisecond)),
+		sample("up", now+model.Time(20*time.Minute/time.Millisecond)),
+	}
+
+	cases := []struct {
+		name     string
+		cfg      config.Timestamps
+		expected model.Samples
+	}{
+		{
+			name:     "disabled",
+			expected: samples,
+		},
+		{
+			name: "reject",
+			cfg:  config.Timestamps{MaxSampleAge: time.Hour, MaxFutureSkew: 10 * time.Minute, Action: config.TimestampReject},
+			expected: model.Samples{
+				samples[1],
+				samples[2],
+			},
+		},
+		{
+			name: "clamp",
+			cfg:  config.Timestamps{MaxSampleAge: time.Hour, MaxFutureSkew: 10 * time.Minute, Action: config.TimestampClamp},
+			expected: model.Samples{
+				sample("up", now),
+				samples[1],
+				samples[2],
+				sample("up", now),
+			},
+		},
+		{
+			name: "quarantine too new only",
+			cfg:  config.Timestamps{MaxFutureSkew: 10 * time.Minute, Action: config.TimestampQuarantine, QuarantinePrefix: "quarantine."},
+			expected: model.Samples{
+				samples[0],
+				samples[1],
+				samples[2],
+				sample("quarantine.up", samples[3].Timestamp),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		validator := newTimestampValidator(c.cfg)
+		validator.now = func() time.Time { return received }
+		assert.Equal(t, c.expected, validator.samples(samples, "kairosdb"), c.name)
+	}
+	assert.Equal(t, model.LabelValue("up"), samples[3].Metric[model.MetricNameLabel], "input metric must not be modified")
+}
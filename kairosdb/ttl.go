@@ -0,0 +1,53 @@
+package kairosdb
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const (
+	defaultTTLClass = "default"
+	noTTLClass      = "none"
+)
+
+var ttlDatapoints = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ttl_datapoints_total",
+		Help: "Total number of datapoints sent to remote storage per TTL class.",
+	},
+	[]string{"remote", "class"},
+)
+
+// ttl returns the TTL in seconds and the class of the first rule matching
+// the metric as received, or the default TTL
+func ttl(metric model.Metric, cfg config.TTLs) (int64, string) {
+	for _, rule := range cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return int64(time.Duration(rule.TTL) / time.Second), rule.Class
+		}
+	}
+
+	if cfg.Default == 0 {
+		return 0, ""
+	}
+	return int64(time.Duration(cfg.Default) / time.Second), defaultTTLClass
+}
+
+// countTTLClasses counts the datapoints per TTL class
+func (c *Client) countTTLClasses(datapoints []*DataPoint) {
+	counts := make(map[string]int)
+	for _, datapoint := range datapoints {
+		if datapoint.ttlClass == "" {
+			counts[noTTLClass]++
+			continue
+		}
+		counts[datapoint.ttlClass]++
+	}
+	for class, count := range counts {
+		ttlDatapoints.WithLabelValues(c.name(), class).Add(float64(count))
+	}
+}
@@ -0,0 +1,136 @@
+package kairosdb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTL(t *testing.T) {
+	cfg := config.TTLs{
+		Default: model.Duration(90 * 24 * time.Hour),
+		Rules: []*config.TTLRule{
+			{
+				Match: []*config.RelabelConfig{
+					{
+						SourceLabels: model.LabelNames{model.MetricNameLabel},
+						Regex:        config.MustNewRegexp("debug_.*"),
+						Action:       config.RelabelKeep,
+					},
+				},
+				TTL:   model.Duration(7 * 24 * time.Hour),
+				Class: "debug",
+			},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		cfg    config.TTLs
+		metric model.Metric
+		ttl    int64
+		class  string
+	}{
+		{
+			name:   "matching rule",
+			cfg:    cfg,
+			metric: model.Metric{model.MetricNameLabel: "debug_queue_length"},
+			ttl:    7 * 24 * 3600,
+			class:  "debug",
+		},
+		{
+			name:   "default",
+			cfg:    cfg,
+			metric: model.Metric{model.MetricNameLabel: "queue_length"},
+			ttl:    90 * 24 * 3600,
+			class:  "default",
+		},
+		{
+			name:   "no ttl",
+			metric: model.Metric{model.MetricNameLabel: "queue_length"},
+		},
+	}
+
+	for _, c := range cases {
+		ttl, class := ttl(c.metric, c.cfg)
+		assert.Equal(t, c.ttl, ttl, c.name)
+		assert.Equal(t, c.class, class, c.name)
+	}
+}
+
+func TestFilterAndProcessSamplesTTL(t *testing.T) {
+	cfg := &config.Config{
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{
+				SourceLabels: model.LabelNames{model.MetricNameLabel},
+				Regex:        config.MustNewRegexp(".*"),
+				Action:       config.RelabelAddPrefix,
+				Prefix:       "prod.",
+			},
+		},
+		TTL: config.TTLs{
+			Rules: []*config.TTLRule{
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{model.MetricNameLabel},
+							Regex:        config.MustNewRegexp("slo_.*"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					TTL:   model.Duration(400 * 24 * time.Hour),
+					Class: "slo",
+				},
+			},
+		},
+	}
+
+	samples := model.Samples{
+		{Metric: model.Metric{model.MetricNameLabel: "slo_errors"}, Value: 1, Timestamp: 1000},
+	}
+
+	datapoints := FilterAndProcessSamples(samples, cfg)
+	assert.Len(t, datapoints, 1)
+	assert.Equal(t, "slo", datapoints[0].ttlClass, "rules must match the metric as received")
+
+	actual, err := json.Marshal(datapoints)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"name": "prod.slo_errors", "timestamp": 1000, "value": 1, "tags": {}, "ttl": 34560000}]`, string(actual))
+}
+
+func TestFilterAndProcessSamplesTTLOfSeries(t *testing.T) {
+	cfg := &config.Config{
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("tier"), Action: config.RelabelLabelDrop},
+		},
+		TTL: config.TTLs{
+			Rules: []*config.TTLRule{
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{"tier"},
+							Regex:        config.MustNewRegexp("^gold$"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					TTL:   model.Duration(24 * time.Hour),
+					Class: "gold",
+				},
+			},
+		},
+	}
+
+	// the samples of a series share the metric, whose tier is dropped
+	samples := seriesSamples(model.Metric{model.MetricNameLabel: "up", "tier": "gold"}, 1, 1, 1)
+
+	datapoints := FilterAndProcessSamples(samples, cfg)
+	assert.Len(t, datapoints, 3)
+	for _, datapoint := range datapoints {
+		assert.Equal(t, int64(86400), datapoint.TTL)
+		assert.Equal(t, map[string]string{}, datapoint.Tags)
+	}
+}
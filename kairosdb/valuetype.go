@@ -0,0 +1,48 @@
+package kairosdb
+
+import (
+	"math"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const (
+	longType   = "long"
+	doubleType = "double"
+
+	// maxLong is 2^63, the smallest float64 which overflows int64
+	maxLong = float64(1 << 63)
+)
+
+// valueType returns the value type of the first rule matching the metric as
+// received, or the default
+func valueType(metric model.Metric, cfg config.ValueTypes) config.ValueType {
+	for _, rule := range cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule.Type
+		}
+	}
+	return cfg.Default
+}
+
+// setValueType sets the KairosDB type of the datapoint. Values which don't
+// fit into a long are written as double.
+func setValueType(datapoint *DataPoint, valueType config.ValueType) {
+	value := datapoint.Value
+	switch valueType {
+	case config.ValueTypeDouble:
+		datapoint.Type = doubleType
+	case config.ValueTypeLong:
+		value = math.Floor(value + 0.5)
+		fallthrough
+	case config.ValueTypeAuto:
+		if value == math.Trunc(value) && value >= -maxLong && value < maxLong {
+			datapoint.Type = longType
+			datapoint.Value = value
+		} else {
+			datapoint.Type = doubleType
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package kairosdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetValueType(t *testing.T) {
+	cases := []struct {
+		name      string
+		valueType config.ValueType
+		value     float64
+		expected  string
+		json      string
+	}{
+		{
+			name:     "no type",
+			value:    2,
+			expected: "",
+			json:     `{"name": "m", "timestamp": 0, "value": 2, "tags": null}`,
+		},
+		{
+			name:      "auto with integral value",
+			valueType: config.ValueTypeAuto,
+			value:     1e15,
+			expected:  "long",
+			json:      `{"name": "m", "timestamp": 0, "value": 1000000000000000, "tags": null, "type": "long"}`,
+		},
+		{
+			name:      "auto with fraction",
+			valueType: config.ValueTypeAuto,
+			value:     2.5,
+			expected:  "double",
+			json:      `{"name": "m", "timestamp": 0, "value": 2.5, "tags": null, "type": "double"}`,
+		},
+		{
+			name:      "auto with value out of long range",
+			valueType: config.ValueTypeAuto,
+			value:     1e19,
+			expected:  "double",
+			json:      `{"name": "m", "timestamp": 0, "value": 1e19, "tags": null, "type": "double"}`,
+		},
+		{
+			name:      "long rounds",
+			valueType: config.ValueTypeLong,
+			value:     2.5,
+			expected:  "long",
+			json:      `{"name": "m", "timestamp": 0, "value": 3, "tags": null, "type": "long"}`,
+		},
+		{
+			name:      "double with integral value",
+			valueType: config.ValueTypeDouble,
+			value:     2,
+			expected:  "double",
+			json:      `{"name": "m", "timestamp": 0, "value": 2, "tags": null, "type": "double"}`,
+		},
+	}
+
+	for _, c := range cases {
+		datapoint := &DataPoint{Name: "m", Value: c.value}
+		setValueType(datapoint, c.valueType)
+		assert.Equal(t, c.expected, datapoint.Type, c.name)
+
+		actual, err := json.Marshal(datapoint)
+		assert.Nil(t, err, c.name)
+		assert.JSONEq(t, c.json, string(actual), c.name)
+	}
+}
+
+func TestFilterAndProcessSamplesValueTypes(t *testing.T) {
+	cfg := &config.Config{
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("pod"), Action: config.RelabelLabelDrop},
+		},
+		Collisions: config.Collisions{Enabled: true, Policy: config.CollisionAvg},
+		ValueTypes: config.ValueTypes{
+			Default: config.ValueTypeAuto,
+			Rules: []*config.ValueTypeRule{
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{model.MetricNameLabel},
+							Regex:        config.MustNewRegexp(".*_seconds"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					Type: config.ValueTypeDouble,
+				},
+			},
+		},
+	}
+
+	samples := model.Samples{
+		{Metric: model.Metric{model.MetricNameLabel: "requests", "pod": "a"}, Value: 1, Timestamp: 1000},
+		{Metric: model.Metric{model.MetricNameLabel: "requests", "pod": "b"}, Value: 2, Timestamp: 1000},
+		{Metric: model.Metric{model.MetricNameLabel: "latency_seconds"}, Value: 1, Timestamp: 1000},
+	}
+
+	expected := []*DataPoint{
+		{Name: "requests", Timestamp: 1000, Value: 1.5, Tags: map[string]string{}, Type: "double"},
+		{Name: "latency_seconds", Timestamp: 1000, Value: 1, Tags: map[string]string{}, Type: "double"},
+	}
+	assert.Equal(t, expected, FilterAndProcessSamples(samples, cfg), "type must be set on the resolved value")
+}
+
+func TestFilterAndProcessSamplesValueTypeOfSeries(t *testing.T) {
+	cfg := &config.Config{
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("unit"), Action: config.RelabelLabelDrop},
+		},
+		ValueTypes: config.ValueTypes{
+			Default: config.ValueTypeAuto,
+			Rules: []*config.ValueTypeRule{
+				{
+					Match: []*config.RelabelConfig{
+						{
+							SourceLabels: model.LabelNames{"unit"},
+							Regex:        config.MustNewRegexp("^seconds$"),
+							Action:       config.RelabelKeep,
+						},
+					},
+					Type: config.ValueTypeDouble,
+				},
+			},
+		},
+	}
+
+	// the samples of a series share the metric, whose unit is dropped
+	samples := seriesSamples(model.Metric{model.MetricNameLabel: "latency", "unit": "seconds"}, 1, 2)
+
+	datapoints := FilterAndProcessSamples(samples, cfg)
+	assert.Len(t, datapoints, 2)
+	for _, datapoint := range datapoints {
+		assert.Equal(t, "double", datapoint.Type)
+	}
+}
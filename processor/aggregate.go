@@ -0,0 +1,183 @@
+package processor
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const aggregationProcessor = "aggregation"
+
+// Aggregator aggregates series over fixed windows, like a recording rule
+// evaluated on the received samples. A window is written once the grace
+// period passed after its end, by the clock of the adapter, so samples sent
+// concurrently by other remote write shards are still aggregated. Samples of
+// windows which were written are late and ignored.
+type Aggregator struct {
+	mtx     sync.Mutex
+	rules   []*config.AggregationRule
+	grace   model.Time
+	windows []map[windowKey]*aggregationGroup
+	now     func() time.Time
+}
+
+// windowKey identifies the window of a group of series
+type windowKey struct {
+	group model.Fingerprint
+	start model.Time
+}
+
+// aggregationGroup is a window of a group of series
+type aggregationGroup struct {
+	metric model.Metric
+	start  model.Time
+	series map[model.Fingerprint]*model.Sample
+}
+
+// NewAggregator returns an Aggregator for the configured rules
+func NewAggregator(cfg config.Aggregations) *Aggregator {
+	a := &Aggregator{
+		rules:   cfg.Rules,
+		grace:   model.Time(cfg.GracePeriod / time.Millisecond),
+		windows: make([]map[windowKey]*aggregationGroup, len(cfg.Rules)),
+		now:     time.Now,
+	}
+	for i := range a.windows {
+		a.windows[i] = make(map[windowKey]*aggregationGroup)
+	}
+	return a
+}
+
+// Process appends the aggregates of the windows whose grace period passed.
+// Samples matching a rule which drops its inputs are removed.
+func (a *Aggregator) Process(samples model.Samples) model.Samples {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := model.TimeFromUnixNano(a.now().UnixNano())
+	result := make(model.Samples, 0, len(samples))
+	for _, sample := range samples {
+		drop := false
+		for i, rule := range a.rules {
+			if !relabel.Matches(sample.Metric, rule.Match...) {
+				continue
+			}
+			drop = drop || rule.DropInputs
+			a.add(i, sample, now)
+		}
+		if !drop {
+			result = append(result, sample)
+		}
+	}
+
+	return append(result, a.flush(now)...)
+}
+
+// Flush returns the aggregates of all windows, also of the windows whose
+// grace period did not pass yet
+func (a *Aggregator) Flush() model.Samples {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.flush(model.Latest)
+}
+
+// flush removes the windows whose grace period passed at now and returns
+// their aggregates, ordered by series and time
+func (a *Aggregator) flush(now model.Time) model.Samples {
+	var aggregates model.Samples
+	active := 0
+	for i, rule := range a.rules {
+		interval := model.Time(rule.Interval / time.Millisecond)
+		for key, group := range a.windows[i] {
+			if group.start+interval+a.grace <= now {
+				aggregates = append(aggregates, group.aggregate(rule))
+				delete(a.windows[i], key)
+			}
+		}
+		active += len(a.windows[i])
+	}
+	activeSeries.WithLabelValues(aggregationProcessor).Set(float64(active))
+
+	sort.Sort(aggregates)
+	return aggregates
+}
+
+// add adds the sample to the window of its group, unless the window was
+// already written
+func (a *Aggregator) add(i int, sample *model.Sample, now model.Time) {
+	if IsStaleMarker(float64(sample.Value)) {
+		return
+	}
+
+	rule := a.rules[i]
+	interval := model.Time(rule.Interval / time.Millisecond)
+	start := sample.Timestamp - sample.Timestamp%interval
+	if start+interval+a.grace <= now {
+		lateSamples.WithLabelValues(aggregationProcessor).Inc()
+		return
+	}
+
+	metric := model.Metric{model.MetricNameLabel: model.LabelValue(rule.Metric)}
+	for _, name := range rule.By {
+		if value, ok := sample.Metric[name]; ok {
+			metric[name] = value
+		}
+	}
+	key := windowKey{group: metric.Fingerprint(), start: start}
+
+	group, ok := a.windows[i][key]
+	if !ok {
+		group = &aggregationGroup{metric: metric, start: start, series: make(map[model.Fingerprint]*model.Sample)}
+		a.windows[i][key] = group
+	}
+
+	seriesFp := sample.Metric.Fingerprint()
+	if last, ok := group.series[seriesFp]; !ok || sample.Timestamp >= last.Timestamp {
+		group.series[seriesFp] = sample
+	}
+}
+
+// aggregate returns the aggregate of the last values of the series in the
+// window, timestamped with the end of the window.
+func (g *aggregationGroup) aggregate(rule *config.AggregationRule) *model.Sample {
+	var sum float64
+	min, max := math.Inf(1), math.Inf(-1)
+	var last *model.Sample
+	for _, sample := range g.series {
+		value := float64(sample.Value)
+		sum += value
+		min = math.Min(min, value)
+		max = math.Max(max, value)
+		if last == nil || sample.Timestamp > last.Timestamp {
+			last = sample
+		}
+	}
+
+	var value float64
+	switch rule.Function {
+	case config.AggregationSum:
+		value = sum
+	case config.AggregationAvg:
+		value = sum / float64(len(g.series))
+	case config.AggregationMin:
+		value = min
+	case config.AggregationMax:
+		value = max
+	case config.AggregationCount:
+		value = float64(len(g.series))
+	case config.AggregationLast:
+		value = float64(last.Value)
+	}
+
+	return &model.Sample{
+		Metric:    g.metric,
+		Value:     model.SampleValue(value),
+		Timestamp: g.start + model.Time(rule.Interval/time.Millisecond),
+	}
+}
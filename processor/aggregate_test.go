@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func cpuSample(namespace, pod string, value float64, ts model.Time) *model.Sample {
+	return &model.Sample{
+		Metric: model.Metric{
+			model.MetricNameLabel: "container_cpu_usage_seconds_total",
+			"namespace":           model.LabelValue(namespace),
+			"pod":                 model.LabelValue(pod),
+		},
+		Value:     model.SampleValue(value),
+		Timestamp: ts,
+	}
+}
+
+func aggregateSample(namespace string, value float64, ts model.Time) *model.Sample {
+	return &model.Sample{
+		Metric:    model.Metric{model.MetricNameLabel: "namespace:cpu", "namespace": model.LabelValue(namespace)},
+		Value:     model.SampleValue(value),
+		Timestamp: ts,
+	}
+}
+
+func aggregationRule(function config.AggregationFunction) *config.AggregationRule {
+	return &config.AggregationRule{
+		Match: []*config.RelabelConfig{
+			{
+				SourceLabels: model.LabelNames{model.MetricNameLabel},
+				Regex:        config.MustNewRegexp("container_cpu_usage_seconds_total"),
+				Action:       config.RelabelKeep,
+			},
+		},
+		Metric:     "namespace:cpu",
+		Function:   function,
+		By:         model.LabelNames{"namespace"},
+		Interval:   time.Minute,
+		DropInputs: true,
+	}
+}
+
+func TestAggregatorFunctions(t *testing.T) {
+	window := model.Samples{
+		cpuSample("web", "a", 1, 1000),
+		cpuSample("web", "a", 4, 30000),
+		cpuSample("web", "b", 2, 20000),
+		cpuSample("db", "c", 7, 10000),
+	}
+
+	cases := []struct {
+		function config.AggregationFunction
+		web      float64
+		db       float64
+	}{
+		{function: config.AggregationSum, web: 6, db: 7},
+		{function: config.AggregationAvg, web: 3, db: 7},
+		{function: config.AggregationMin, web: 2, db: 7},
+		{function: config.AggregationMax, web: 4, db: 7},
+		{function: config.AggregationCount, web: 2, db: 1},
+		{function: config.AggregationLast, web: 4, db: 7},
+	}
+
+	for _, c := range cases {
+		cfg := config.Aggregations{GracePeriod: 10 * time.Second, Rules: []*config.AggregationRule{aggregationRule(c.function)}}
+		aggregator := NewAggregator(cfg)
+		now := time.Unix(60, 0)
+		aggregator.now = func() time.Time { return now }
+
+		assert.Empty(t, aggregator.Process(window), string(c.function))
+		now = time.Unix(70, 0)
+		actual := aggregator.Process(nil)
+		assert.Equal(t, model.Samples{aggregateSample("db", c.db, 60000), aggregateSample("web", c.web, 60000)}, actual, string(c.function))
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	now := time.Unix(60, 0)
+	rule := aggregationRule(config.AggregationSum)
+	rule.DropInputs = false
+	aggregator := NewAggregator(config.Aggregations{GracePeriod: 10 * time.Second, Rules: []*config.AggregationRule{rule}})
+	aggregator.now = func() time.Time { return now }
+
+	other := &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up"}, Value: 1, Timestamp: 1000}
+	actual := aggregator.Process(model.Samples{
+		cpuSample("web", "a", 1, 1000),
+		cpuSample("db", "c", 2, 1000),
+		other,
+	})
+	assert.Equal(t, model.Samples{cpuSample("web", "a", 1, 1000), cpuSample("db", "c", 2, 1000), other}, actual,
+		"inputs must be kept")
+
+	// remote write shards send the series of a group concurrently
+	now = time.Unix(65, 0)
+	actual = aggregator.Process(model.Samples{cpuSample("web", "a", 3, 65000), cpuSample("web", "b", 5, 59000)})
+	assert.Equal(t, model.Samples{cpuSample("web", "a", 3, 65000), cpuSample("web", "b", 5, 59000)}, actual,
+		"window must be written after the grace period")
+
+	now = time.Unix(70, 0)
+	actual = aggregator.Process(nil)
+	assert.Equal(t, model.Samples{aggregateSample("db", 2, 60000), aggregateSample("web", 6, 60000)}, actual,
+		"samples within the grace period must be aggregated")
+
+	actual = aggregator.Process(model.Samples{cpuSample("web", "b", 7, 58000)})
+	assert.Equal(t, model.Samples{cpuSample("web", "b", 7, 58000)}, actual, "late sample must not rewrite the window")
+	assert.Len(t, aggregator.windows[0], 1)
+
+	assert.Equal(t, model.Samples{aggregateSample("web", 3, 120000)}, aggregator.Flush())
+	assert.Empty(t, aggregator.windows[0])
+}
+
+func TestAggregatorSpecialValues(t *testing.T) {
+	aggregator := NewAggregator(config.Aggregations{Rules: []*config.AggregationRule{aggregationRule(config.AggregationMax)}})
+	aggregator.now = func() time.Time { return time.Unix(0, 0) }
+
+	// stale markers are not aggregated, infinite values like other values
+	aggregator.Process(model.Samples{
+		cpuSample("web", "a", 1, 1000),
+		cpuSample("web", "b", staleMarker, 1000),
+		cpuSample("db", "c", math.Inf(1), 1000),
+	})
+	assert.Equal(t, model.Samples{aggregateSample("db", math.Inf(1), 60000), aggregateSample("web", 1, 60000)}, aggregator.Flush())
+}
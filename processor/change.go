@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const changeProcessor = "change"
+
+var unchangedSamples = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "unchanged_samples_total",
+		Help: "Total number of samples dropped because the value of their series did not change.",
+	},
+	[]string{"remote"},
+)
+
+// ChangeFilter drops samples whose value did not change since the last
+// written sample of the series, unless the heartbeat passed. Series are kept
+// in least recently seen order, so the memory is bounded by evicting the
+// series seen least recently. An evicted series is written again.
+type ChangeFilter struct {
+	mtx    sync.Mutex
+	cfg    config.ChangeOnly
+	remote string
+	series map[model.Fingerprint]*list.Element
+	lru    *list.List
+	now    func() time.Time
+}
+
+// writtenValue is the last written sample of a series
+type writtenValue struct {
+	fp        model.Fingerprint
+	value     model.SampleValue
+	timestamp model.Time
+	lastSeen  time.Time
+}
+
+// NewChangeFilter returns a ChangeFilter for the configured rules, which
+// counts the dropped samples for the remote
+func NewChangeFilter(cfg config.ChangeOnly, remote string) *ChangeFilter {
+	return &ChangeFilter{
+		cfg:    cfg,
+		remote: remote,
+		series: make(map[model.Fingerprint]*list.Element),
+		lru:    list.New(),
+		now:    time.Now,
+	}
+}
+
+// Process returns the samples without the unchanged values of the matching series
+func (f *ChangeFilter) Process(samples model.Samples) model.Samples {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	now := f.now()
+	result := make(model.Samples, 0, len(samples))
+	for _, sample := range samples {
+		rule := f.match(sample.Metric)
+		if rule == nil {
+			result = append(result, sample)
+			continue
+		}
+
+		fp := sample.Metric.Fingerprint()
+		if IsStaleMarker(float64(sample.Value)) {
+			// the next value has to be written
+			f.remove(fp)
+			result = append(result, sample)
+			continue
+		}
+
+		if element, ok := f.series[fp]; ok {
+			written := element.Value.(*writtenValue)
+			written.lastSeen = now
+			f.lru.MoveToFront(element)
+
+			heartbeat := model.Time(rule.Heartbeat / time.Millisecond)
+			if sample.Value.Equal(written.value) && sample.Timestamp-written.timestamp < heartbeat {
+				unchangedSamples.WithLabelValues(f.remote).Inc()
+				continue
+			}
+			written.value = sample.Value
+			written.timestamp = sample.Timestamp
+			result = append(result, sample)
+			continue
+		}
+
+		f.series[fp] = f.lru.PushFront(&writtenValue{
+			fp:        fp,
+			value:     sample.Value,
+			timestamp: sample.Timestamp,
+			lastSeen:  now,
+		})
+		for f.lru.Len() > f.cfg.MaxSeries {
+			f.remove(f.lru.Back().Value.(*writtenValue).fp)
+			evictedSeries.WithLabelValues(changeProcessor).Inc()
+		}
+		result = append(result, sample)
+	}
+
+	f.evict(now)
+	return result
+}
+
+func (f *ChangeFilter) match(metric model.Metric) *config.ChangeRule {
+	for _, rule := range f.cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (f *ChangeFilter) remove(fp model.Fingerprint) {
+	if element, ok := f.series[fp]; ok {
+		f.lru.Remove(element)
+		delete(f.series, fp)
+	}
+}
+
+// evict drops the series idle for longer than the TTL, which are at the back
+// of the list
+func (f *ChangeFilter) evict(now time.Time) {
+	for element := f.lru.Back(); element != nil; element = f.lru.Back() {
+		written := element.Value.(*writtenValue)
+		if now.Sub(written.lastSeen) <= f.cfg.SeriesTTL {
+			break
+		}
+		f.remove(written.fp)
+		evictedSeries.WithLabelValues(changeProcessor).Inc()
+	}
+	activeSeries.WithLabelValues(changeProcessor).Set(float64(len(f.series)))
+}
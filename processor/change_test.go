@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func changeConfig(maxSeries int) config.ChangeOnly {
+	return config.ChangeOnly{
+		MaxSeries: maxSeries,
+		SeriesTTL: time.Minute,
+		Rules: []*config.ChangeRule{
+			{
+				Match: []*config.RelabelConfig{
+					{
+						SourceLabels: model.LabelNames{model.MetricNameLabel},
+						Regex:        config.MustNewRegexp("up|build_info"),
+						Action:       config.RelabelKeep,
+					},
+				},
+				Heartbeat: 5 * time.Minute,
+			},
+		},
+	}
+}
+
+func TestChangeFilter(t *testing.T) {
+	cases := []struct {
+		name     string
+		samples  model.Samples
+		expected model.Samples
+	}{
+		{
+			name: "unchanged values",
+			samples: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", 1, 60000),
+				counterSample("up", 0, 120000),
+				counterSample("up", 0, 180000),
+			},
+			expected: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", 0, 120000),
+			},
+		},
+		{
+			name: "heartbeat",
+			samples: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", 1, 240000),
+				counterSample("up", 1, 300000),
+				counterSample("up", 1, 360000),
+			},
+			expected: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", 1, 300000),
+			},
+		},
+		{
+			name: "stale marker",
+			samples: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", staleMarker, 60000),
+				counterSample("up", 1, 120000),
+			},
+			expected: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", staleMarker, 60000),
+				counterSample("up", 1, 120000),
+			},
+		},
+		{
+			name: "NaN values",
+			samples: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", math.NaN(), 60000),
+				counterSample("up", math.NaN(), 120000),
+				counterSample("up", 1, 180000),
+			},
+			expected: model.Samples{
+				counterSample("up", 1, 0),
+				counterSample("up", math.NaN(), 60000),
+				counterSample("up", 1, 180000),
+			},
+		},
+		{
+			name: "not matching series",
+			samples: model.Samples{
+				counterSample("temperature", 1, 0),
+				counterSample("temperature", 1, 60000),
+			},
+			expected: model.Samples{
+				counterSample("temperature", 1, 0),
+				counterSample("temperature", 1, 60000),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		filter := NewChangeFilter(changeConfig(100), "kairosdb")
+		actual := filter.Process(c.samples)
+		// Samples.Equal treats NaN values as equal
+		assert.True(t, c.expected.Equal(actual), "case '%s'. expected %v, got %v", c.name, c.expected, actual)
+	}
+}
+
+func TestChangeFilterEviction(t *testing.T) {
+	now := time.Unix(0, 0)
+	filter := NewChangeFilter(changeConfig(2), "kairosdb")
+	filter.now = func() time.Time { return now }
+
+	up := func(job string) *model.Sample {
+		return &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up", "job": model.LabelValue(job)}, Value: 1}
+	}
+
+	filter.Process(model.Samples{up("a"), up("b")})
+	now = now.Add(30 * time.Second)
+	filter.Process(model.Samples{up("a"), up("c")})
+	assert.Len(t, filter.series, 2)
+	assert.Len(t, filter.Process(model.Samples{up("b")}), 1, "least recently seen series must be evicted")
+
+	now = now.Add(61 * time.Second)
+	filter.Process(model.Samples{up("b")})
+	assert.Len(t, filter.series, 1, "idle series must be evicted")
+}
@@ -0,0 +1,201 @@
+package processor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const (
+	downsamplingProcessor = "downsampling"
+
+	// idleCheckInterval limits how often all series are checked for
+	// windows which ended without a sample of the next window
+	idleCheckInterval = time.Second
+)
+
+var downsampledSamples = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "downsampled_samples_total",
+		Help: "Total number of samples dropped by downsampling.",
+	},
+	[]string{"remote"},
+)
+
+// Downsampler writes at most one sample per series and interval. With the
+// first function the first sample of a window is written right away, with
+// the other functions a window is written once the first sample of the next
+// window arrives, or when no sample arrived for another interval after the
+// window ended. Samples of a window which was written are late and ignored.
+// Stale markers are passed through.
+type Downsampler struct {
+	mtx          sync.Mutex
+	cfg          config.Downsampling
+	remote       string
+	series       map[model.Fingerprint]*downsampledSeries
+	lastCheck    time.Time
+	lastEviction time.Time
+	now          func() time.Time
+}
+
+// downsampledSeries is the current window of a series
+type downsampledSeries struct {
+	rule     *config.DownsamplingRule
+	start    model.Time
+	pending  *model.Sample
+	sum      float64
+	count    int
+	flushed  bool
+	lastSeen time.Time
+}
+
+// NewDownsampler returns a Downsampler for the configured rules, which
+// counts the dropped samples for the remote
+func NewDownsampler(cfg config.Downsampling, remote string) *Downsampler {
+	return &Downsampler{
+		cfg:    cfg,
+		remote: remote,
+		series: make(map[model.Fingerprint]*downsampledSeries),
+		now:    time.Now,
+	}
+}
+
+// Process returns the samples with the matching series downsampled
+func (d *Downsampler) Process(samples model.Samples) model.Samples {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := d.now()
+	result := make(model.Samples, 0, len(samples))
+	for _, sample := range samples {
+		rule := d.match(sample.Metric)
+		if rule == nil || IsStaleMarker(float64(sample.Value)) {
+			result = append(result, sample)
+			continue
+		}
+
+		interval := model.Time(rule.Interval / time.Millisecond)
+		start := sample.Timestamp - sample.Timestamp%interval
+
+		fp := sample.Metric.Fingerprint()
+		state, ok := d.series[fp]
+		switch {
+		case ok && (start < state.start || start == state.start && state.flushed):
+			lateSamples.WithLabelValues(downsamplingProcessor).Inc()
+			continue
+		case ok && start == state.start:
+			if rule.Function == config.DownsamplingFirst {
+				downsampledSamples.WithLabelValues(d.remote).Inc()
+				state.lastSeen = now
+				continue
+			}
+		default:
+			if ok {
+				result = d.flush(state, result)
+			}
+			state = &downsampledSeries{rule: rule, start: start}
+			d.series[fp] = state
+			if rule.Function == config.DownsamplingFirst {
+				result = append(result, sample)
+			}
+		}
+		state.lastSeen = now
+		state.add(sample)
+	}
+
+	if now.Sub(d.lastCheck) >= idleCheckInterval {
+		d.lastCheck = now
+		result = d.flushIdle(now, result)
+	}
+	activeSeries.WithLabelValues(downsamplingProcessor).Set(float64(len(d.series)))
+	return result
+}
+
+// Flush returns the windows which were not written yet, ordered by series
+// and time
+func (d *Downsampler) Flush() model.Samples {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	var result model.Samples
+	for _, state := range d.series {
+		result = d.flush(state, result)
+	}
+	sort.Sort(result)
+	return result
+}
+
+func (d *Downsampler) match(metric model.Metric) *config.DownsamplingRule {
+	for _, rule := range d.cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// flushIdle writes the windows which ended an interval ago and evicts the
+// series which have been idle for longer than the TTL
+func (d *Downsampler) flushIdle(now time.Time, result model.Samples) model.Samples {
+	evict := now.Sub(d.lastEviction) >= d.cfg.SeriesTTL
+	if evict {
+		d.lastEviction = now
+	}
+
+	end := model.TimeFromUnixNano(now.UnixNano())
+	for fp, state := range d.series {
+		interval := model.Time(state.rule.Interval / time.Millisecond)
+		if state.start+2*interval <= end {
+			result = d.flush(state, result)
+		}
+		if evict && now.Sub(state.lastSeen) > d.cfg.SeriesTTL {
+			result = d.flush(state, result)
+			delete(d.series, fp)
+			evictedSeries.WithLabelValues(downsamplingProcessor).Inc()
+		}
+	}
+	return result
+}
+
+func (s *downsampledSeries) add(sample *model.Sample) {
+	s.count++
+	switch s.rule.Function {
+	case config.DownsamplingLast:
+		s.pending = sample
+	case config.DownsamplingAvg:
+		s.sum += float64(sample.Value)
+		s.pending = sample
+	case config.DownsamplingMax:
+		if s.pending == nil || sample.Value > s.pending.Value {
+			s.pending = sample
+		}
+	}
+}
+
+// flush appends the sample of the window of the series, if it was not
+// written yet, and marks the window as written
+func (d *Downsampler) flush(s *downsampledSeries, result model.Samples) model.Samples {
+	if s.pending == nil {
+		return result
+	}
+
+	sample := s.pending
+	if s.rule.Function == config.DownsamplingAvg {
+		sample = &model.Sample{
+			Metric:    sample.Metric,
+			Value:     model.SampleValue(s.sum / float64(s.count)),
+			Timestamp: sample.Timestamp,
+		}
+	}
+	downsampledSamples.WithLabelValues(d.remote).Add(float64(s.count - 1))
+	s.pending = nil
+	s.sum = 0
+	s.count = 0
+	s.flushed = true
+	return append(result, sample)
+}
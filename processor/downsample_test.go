@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func downsamplingRule(function config.DownsamplingFunction) *config.DownsamplingRule {
+	return &config.DownsamplingRule{
+		Match: []*config.RelabelConfig{
+			{
+				SourceLabels: model.LabelNames{model.MetricNameLabel},
+				Regex:        config.MustNewRegexp("requests_total"),
+				Action:       config.RelabelKeep,
+			},
+		},
+		Interval: 10 * time.Second,
+		Function: function,
+	}
+}
+
+func TestDownsampler(t *testing.T) {
+	window := model.Samples{
+		counterSample("requests_total", 3, 1000),
+		counterSample("requests_total", 9, 5000),
+		counterSample("requests_total", 6, 9000),
+	}
+	next := counterSample("requests_total", 1, 11000)
+
+	cases := []struct {
+		function config.DownsamplingFunction
+		first    model.Samples
+		second   model.Samples
+	}{
+		{
+			function: config.DownsamplingFirst,
+			first:    model.Samples{counterSample("requests_total", 3, 1000)},
+			second:   model.Samples{next},
+		},
+		{
+			function: config.DownsamplingLast,
+			first:    model.Samples{},
+			second:   model.Samples{counterSample("requests_total", 6, 9000)},
+		},
+		{
+			function: config.DownsamplingAvg,
+			first:    model.Samples{},
+			second:   model.Samples{counterSample("requests_total", 6, 9000)},
+		},
+		{
+			function: config.DownsamplingMax,
+			first:    model.Samples{},
+			second:   model.Samples{counterSample("requests_total", 9, 5000)},
+		},
+	}
+
+	for _, c := range cases {
+		downsampler := NewDownsampler(config.Downsampling{
+			SeriesTTL: time.Minute,
+			Rules:     []*config.DownsamplingRule{downsamplingRule(c.function)},
+		}, "kairosdb")
+		downsampler.now = func() time.Time { return time.Unix(10, 0) }
+
+		assert.Equal(t, c.first, downsampler.Process(window), string(c.function))
+		assert.Equal(t, c.second, downsampler.Process(model.Samples{next}), string(c.function))
+	}
+}
+
+func TestDownsamplerPassesThrough(t *testing.T) {
+	downsampler := NewDownsampler(config.Downsampling{
+		SeriesTTL: time.Minute,
+		Rules:     []*config.DownsamplingRule{downsamplingRule(config.DownsamplingFirst)},
+	}, "kairosdb")
+
+	samples := model.Samples{
+		counterSample("temperature", 1, 1000),
+		counterSample("temperature", 2, 2000),
+		counterSample("requests_total", 3, 1000),
+	}
+	assert.Equal(t, samples, downsampler.Process(samples))
+
+	stale := counterSample("requests_total", staleMarker, 2000)
+	actual := downsampler.Process(model.Samples{stale})
+	assert.Len(t, actual, 1, "stale marker must be passed through")
+	assert.Equal(t, model.Samples{}, downsampler.Process(model.Samples{counterSample("requests_total", 4, 3000)}))
+	assert.Equal(t, model.Samples{}, downsampler.Process(model.Samples{counterSample("requests_total", math.NaN(), 4000)}),
+		"NaN values must be downsampled")
+}
+
+func TestDownsamplerIdleSeries(t *testing.T) {
+	now := time.Unix(10, 0)
+	downsampler := NewDownsampler(config.Downsampling{
+		SeriesTTL: time.Minute,
+		Rules:     []*config.DownsamplingRule{downsamplingRule(config.DownsamplingLast)},
+	}, "kairosdb")
+	downsampler.now = func() time.Time { return now }
+
+	assert.Equal(t, model.Samples{}, downsampler.Process(model.Samples{counterSample("requests_total", 3, 1000)}))
+
+	now = time.Unix(20, 0)
+	assert.Equal(t, model.Samples{counterSample("requests_total", 3, 1000)}, downsampler.Process(nil),
+		"window must be written an interval after it ended")
+	assert.Len(t, downsampler.series, 1)
+
+	now = time.Unix(90, 0)
+	downsampler.Process(nil)
+	assert.Empty(t, downsampler.series, "idle series must be evicted")
+}
+
+func TestDownsamplerLateSampleAfterIdleFlush(t *testing.T) {
+	cases := []struct {
+		function config.DownsamplingFunction
+		written  *model.Sample
+	}{
+		{function: config.DownsamplingLast, written: counterSample("requests_total", 5, 2000)},
+		{function: config.DownsamplingAvg, written: counterSample("requests_total", 4, 2000)},
+	}
+
+	for _, c := range cases {
+		now := time.Unix(10, 0)
+		downsampler := NewDownsampler(config.Downsampling{
+			SeriesTTL: time.Minute,
+			Rules:     []*config.DownsamplingRule{downsamplingRule(c.function)},
+		}, "kairosdb")
+		downsampler.now = func() time.Time { return now }
+
+		var before dto.Metric
+		downsampledSamples.WithLabelValues("kairosdb").Write(&before)
+
+		window := model.Samples{counterSample("requests_total", 3, 1000), counterSample("requests_total", 5, 2000)}
+		assert.Equal(t, model.Samples{}, downsampler.Process(window), string(c.function))
+		now = time.Unix(20, 0)
+		assert.Equal(t, model.Samples{c.written}, downsampler.Process(nil), string(c.function))
+
+		late := model.Samples{counterSample("requests_total", 9, 3000)}
+		assert.Equal(t, model.Samples{}, downsampler.Process(late), "late sample of a written window must be ignored")
+		assert.Empty(t, downsampler.Flush(), string(c.function))
+
+		var after dto.Metric
+		downsampledSamples.WithLabelValues("kairosdb").Write(&after)
+		assert.Equal(t, before.GetCounter().GetValue()+1, after.GetCounter().GetValue(), string(c.function))
+	}
+}
@@ -0,0 +1,207 @@
+package processor
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+var (
+	activeSeries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "processor_active_series",
+			Help: "Number of series a stateful processor keeps state for.",
+		},
+		[]string{"processor"},
+	)
+	evictedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "processor_evicted_series_total",
+			Help: "Total number of idle series whose state got evicted by a stateful processor.",
+		},
+		[]string{"processor"},
+	)
+	lateSamples = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "processor_late_samples_total",
+			Help: "Total number of samples ignored by a stateful processor because their window was already written.",
+		},
+		[]string{"processor"},
+	)
+)
+
+func RegisterPrometheusMetrics() {
+	prometheus.MustRegister(activeSeries)
+	prometheus.MustRegister(evictedSeries)
+	prometheus.MustRegister(lateSamples)
+	prometheus.MustRegister(downsampledSamples)
+	prometheus.MustRegister(unchangedSamples)
+}
+
+// StaleNaN is the bit pattern of the NaN Prometheus marks stale series with
+const StaleNaN uint64 = 0x7ff0000000000002
+
+// IsStaleMarker tells if the value is a Prometheus stale marker. Other NaN
+// values are real values, which are written according to the special values
+// config.
+func IsStaleMarker(value float64) bool {
+	return math.Float64bits(value) == StaleNaN
+}
+
+// Processor transforms samples before they are relabeled and written to
+// KairosDB. Processors may keep state across calls and have to be safe for
+// concurrent use.
+type Processor interface {
+	Process(samples model.Samples) model.Samples
+}
+
+// Flusher is a Processor which holds back state, like the open windows of a
+// Downsampler, which would be lost when it is dropped on reload
+type Flusher interface {
+	// Flush returns the samples held back and saves the state which is kept
+	// across restarts
+	Flush() model.Samples
+}
+
+// Pipeline runs the processors enabled in the config in order
+type Pipeline struct {
+	remote     string
+	processors []Processor
+}
+
+// NewPipeline returns the pipeline of processors enabled in the config,
+// which count the samples they drop for the remote
+func NewPipeline(cfg *config.Config, remote string) *Pipeline {
+	pipeline, _ := (&Pipeline{remote: remote}).Reload(&config.Config{}, cfg)
+	return pipeline
+}
+
+// Reload returns the pipeline of processors enabled in the config. The
+// processors of the pipeline whose section of the config is the same in prev
+// are kept with their state. The other processors are flushed before their
+// replacements are created, and the flushed samples are returned after they
+// went through the rest of the pipeline.
+func (p *Pipeline) Reload(prev, cfg *config.Config) (*Pipeline, model.Samples) {
+	var resets *ResetNormalizer
+	var rates *RateConverter
+	var downsampler *Downsampler
+	var aggregator *Aggregator
+	var changeFilter *ChangeFilter
+	kept := make(map[Processor]bool)
+	for _, processor := range p.processors {
+		switch processor := processor.(type) {
+		case *ResetNormalizer:
+			if len(cfg.CounterResets.Rules) > 0 && config.Equal(prev.CounterResets, cfg.CounterResets) {
+				resets = processor
+				kept[processor] = true
+			}
+		case *RateConverter:
+			if len(cfg.CounterRates.Rules) > 0 && config.Equal(prev.CounterRates, cfg.CounterRates) {
+				rates = processor
+				kept[processor] = true
+			}
+		case *Downsampler:
+			if len(cfg.Downsampling.Rules) > 0 && config.Equal(prev.Downsampling, cfg.Downsampling) {
+				downsampler = processor
+				kept[processor] = true
+			}
+		case *Aggregator:
+			if len(cfg.Aggregations.Rules) > 0 && config.Equal(prev.Aggregations, cfg.Aggregations) {
+				aggregator = processor
+				kept[processor] = true
+			}
+		case *ChangeFilter:
+			if len(cfg.ChangeOnly.Rules) > 0 && config.Equal(prev.ChangeOnly, cfg.ChangeOnly) {
+				changeFilter = processor
+				kept[processor] = true
+			}
+		}
+	}
+
+	// the state file of a ResetNormalizer is saved before its replacement
+	// loads it
+	flushed := p.flush(func(processor Processor) bool { return !kept[processor] })
+
+	reloaded := &Pipeline{remote: p.remote}
+	if len(cfg.CounterResets.Rules) > 0 {
+		if resets == nil {
+			resets = NewResetNormalizer(cfg.CounterResets)
+		}
+		reloaded.processors = append(reloaded.processors, resets)
+	}
+	if len(cfg.CounterRates.Rules) > 0 {
+		if rates == nil {
+			rates = NewRateConverter(cfg.CounterRates)
+		}
+		reloaded.processors = append(reloaded.processors, rates)
+	}
+	if len(cfg.Downsampling.Rules) > 0 {
+		if downsampler == nil {
+			downsampler = NewDownsampler(cfg.Downsampling, p.remote)
+		}
+		reloaded.processors = append(reloaded.processors, downsampler)
+	}
+	if len(cfg.Aggregations.Rules) > 0 {
+		if aggregator == nil {
+			aggregator = NewAggregator(cfg.Aggregations)
+		}
+		reloaded.processors = append(reloaded.processors, aggregator)
+	}
+	if len(cfg.ChangeOnly.Rules) > 0 {
+		if changeFilter == nil {
+			changeFilter = NewChangeFilter(cfg.ChangeOnly, p.remote)
+		}
+		reloaded.processors = append(reloaded.processors, changeFilter)
+	}
+	return reloaded, flushed
+}
+
+// Flush flushes all processors and returns the flushed samples after they
+// went through the rest of the pipeline
+func (p *Pipeline) Flush() model.Samples {
+	return p.flush(func(Processor) bool { return true })
+}
+
+// flush flushes the selected processors in order, running the samples
+// flushed by a processor through the processors after it
+func (p *Pipeline) flush(selected func(Processor) bool) model.Samples {
+	var flushed model.Samples
+	for _, processor := range p.processors {
+		if len(flushed) > 0 {
+			flushed = processor.Process(flushed)
+		}
+		if flusher, ok := processor.(Flusher); ok && selected(processor) {
+			flushed = append(flushed, flusher.Flush()...)
+		}
+	}
+	return flushed
+}
+
+// Process runs the samples through all processors
+func (p *Pipeline) Process(samples model.Samples) model.Samples {
+	for _, processor := range p.processors {
+		samples = processor.Process(samples)
+	}
+	return samples
+}
+
+// counterState is the last seen value of a counter series
+type counterState struct {
+	value     float64
+	offset    float64
+	timestamp model.Time
+	lastSeen  time.Time
+}
+
+// evictIdle drops the state of series which have been idle for longer than ttl
+func evictIdle(series map[model.Fingerprint]*counterState, now time.Time, ttl time.Duration, processor string) {
+	for fp, state := range series {
+		if now.Sub(state.lastSeen) > ttl {
+			delete(series, fp)
+			evictedSeries.WithLabelValues(processor).Inc()
+		}
+	}
+}
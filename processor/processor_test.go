@@ -0,0 +1,111 @@
+package processor
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// staleMarker is the value of a stale marker, unlike other NaN values
+var staleMarker = math.Float64frombits(StaleNaN)
+
+func TestIsStaleMarker(t *testing.T) {
+	assert.True(t, IsStaleMarker(staleMarker))
+	assert.False(t, IsStaleMarker(math.NaN()))
+	assert.False(t, IsStaleMarker(math.Inf(1)))
+}
+
+func TestPipelineReload(t *testing.T) {
+	rates := func(ttl time.Duration) config.CounterRates {
+		return config.CounterRates{SeriesTTL: ttl, Rules: []*config.RateRule{rateRule(config.RateOutputRate)}}
+	}
+	cfg := &config.Config{CounterRates: rates(time.Minute)}
+	pipeline := NewPipeline(cfg, "kairosdb")
+	assert.Len(t, pipeline.processors, 1)
+
+	// the rules are compiled again on reload
+	unchanged := &config.Config{
+		CounterRates: rates(time.Minute),
+		ChangeOnly:   config.ChangeOnly{Rules: []*config.ChangeRule{{Heartbeat: time.Minute}}},
+	}
+	reloaded, flushed := pipeline.Reload(cfg, unchanged)
+	assert.Empty(t, flushed)
+	assert.Len(t, reloaded.processors, 2)
+	assert.True(t, pipeline.processors[0] == reloaded.processors[0])
+	assert.IsType(t, &ChangeFilter{}, reloaded.processors[1])
+
+	changed := &config.Config{CounterRates: rates(time.Hour)}
+	reloaded, _ = reloaded.Reload(unchanged, changed)
+	assert.Len(t, reloaded.processors, 1)
+	assert.False(t, pipeline.processors[0] == reloaded.processors[0])
+
+	reloaded, _ = reloaded.Reload(changed, &config.Config{})
+	assert.Empty(t, reloaded.processors)
+}
+
+func TestPipelineReloadFlushes(t *testing.T) {
+	downsampling := func(interval time.Duration) config.Downsampling {
+		rule := downsamplingRule(config.DownsamplingLast)
+		rule.Interval = interval
+		return config.Downsampling{SeriesTTL: time.Minute, Rules: []*config.DownsamplingRule{rule}}
+	}
+	aggregations := config.Aggregations{Rules: []*config.AggregationRule{{
+		Match:    downsamplingRule(config.DownsamplingLast).Match,
+		Metric:   "job:requests",
+		Function: config.AggregationSum,
+		By:       model.LabelNames{"job"},
+		Interval: time.Minute,
+	}}}
+	cfg := &config.Config{Downsampling: downsampling(10 * time.Second), Aggregations: aggregations}
+	pipeline := NewPipeline(cfg, "kairosdb")
+	now := func() time.Time { return time.Unix(5, 0) }
+	pipeline.processors[0].(*Downsampler).now = now
+	pipeline.processors[1].(*Aggregator).now = now
+	assert.Empty(t, pipeline.Process(model.Samples{
+		counterSample("requests_total", 10, 1000),
+		counterSample("requests_total", 20, 2000),
+	}))
+
+	// the open window of the downsampler is written and goes through the
+	// aggregator which is kept
+	changed := &config.Config{Downsampling: downsampling(time.Minute), Aggregations: aggregations}
+	reloaded, flushed := pipeline.Reload(cfg, changed)
+	assert.Equal(t, model.Samples{counterSample("requests_total", 20, 2000)}, flushed)
+	assert.False(t, pipeline.processors[0] == reloaded.processors[0])
+	assert.True(t, pipeline.processors[1] == reloaded.processors[1])
+
+	expected := &model.Sample{
+		Metric:    model.Metric{model.MetricNameLabel: "job:requests", "job": "api"},
+		Value:     20,
+		Timestamp: 60000,
+	}
+	assert.Equal(t, model.Samples{expected}, reloaded.Flush())
+}
+
+func TestPipelineReloadSavesOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{CounterResets: resetConfig(filepath.Join(dir, "counters.json"))}
+	pipeline := NewPipeline(cfg, "kairosdb")
+	pipeline.Process(model.Samples{counterSample("requests_total", 100, 1000)})
+	pipeline.Process(model.Samples{counterSample("requests_total", 20, 2000)})
+
+	// the offsets are saved before the replacement loads them, though the
+	// save interval did not pass
+	changed := &config.Config{CounterResets: resetConfig(filepath.Join(dir, "counters.json"))}
+	changed.CounterResets.SaveInterval = time.Hour
+	reloaded, _ := pipeline.Reload(cfg, changed)
+	actual := reloaded.Process(model.Samples{counterSample("requests_total", 30, 3000)})
+	assert.Equal(t, counterSample("requests_total_normalized", 130, 3000), actual[1])
+}
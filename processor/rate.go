@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const rateProcessor = "rate"
+
+// RateConverter writes the per-second rate and the increase of counters as
+// new metrics, next to the counters themselves. A decreasing value is taken
+// as counter reset, the increase is the new value then.
+type RateConverter struct {
+	mtx          sync.Mutex
+	rules        []*config.RateRule
+	ttl          time.Duration
+	series       map[model.Fingerprint]*counterState
+	lastEviction time.Time
+	now          func() time.Time
+}
+
+// NewRateConverter returns a RateConverter for the configured rules
+func NewRateConverter(cfg config.CounterRates) *RateConverter {
+	return &RateConverter{
+		rules:  cfg.Rules,
+		ttl:    cfg.SeriesTTL,
+		series: make(map[model.Fingerprint]*counterState),
+		now:    time.Now,
+	}
+}
+
+// Process appends the rates and increases of the matching counters
+func (c *RateConverter) Process(samples model.Samples) model.Samples {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.now()
+	result := make(model.Samples, len(samples), len(samples))
+	copy(result, samples)
+
+	for _, sample := range samples {
+		rule := c.match(sample.Metric)
+		if rule == nil {
+			continue
+		}
+
+		fp := sample.Metric.Fingerprint()
+		value := float64(sample.Value)
+		if IsStaleMarker(value) {
+			// the series ended
+			delete(c.series, fp)
+			continue
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			// no rate of a value which is not a count, the sample itself is
+			// written according to the special values config
+			continue
+		}
+
+		state, ok := c.series[fp]
+		if !ok {
+			c.series[fp] = &counterState{value: value, timestamp: sample.Timestamp, lastSeen: now}
+			continue
+		}
+		if sample.Timestamp <= state.timestamp {
+			continue
+		}
+
+		increase := value - state.value
+		if value < state.value {
+			increase = value
+		}
+		seconds := float64(sample.Timestamp-state.timestamp) / 1000
+
+		if rule.Output != config.RateOutputIncrease {
+			result = append(result, derivedSample(sample, rule.RateSuffix, increase/seconds))
+		}
+		if rule.Output != config.RateOutputRate {
+			result = append(result, derivedSample(sample, rule.IncreaseSuffix, increase))
+		}
+
+		state.value = value
+		state.timestamp = sample.Timestamp
+		state.lastSeen = now
+	}
+
+	c.evict(now)
+	return result
+}
+
+func (c *RateConverter) match(metric model.Metric) *config.RateRule {
+	for _, rule := range c.rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// evict drops the state of idle series at most once per TTL
+func (c *RateConverter) evict(now time.Time) {
+	if now.Sub(c.lastEviction) >= c.ttl {
+		c.lastEviction = now
+		evictIdle(c.series, now, c.ttl, rateProcessor)
+	}
+	activeSeries.WithLabelValues(rateProcessor).Set(float64(len(c.series)))
+}
+
+// derivedSample returns a new sample of the metric name with the suffix
+func derivedSample(sample *model.Sample, suffix string, value float64) *model.Sample {
+	metric := sample.Metric.Clone()
+	metric[model.MetricNameLabel] += model.LabelValue(suffix)
+	return &model.Sample{
+		Metric:    metric,
+		Value:     model.SampleValue(value),
+		Timestamp: sample.Timestamp,
+	}
+}
@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func counterSample(name string, value float64, ts model.Time) *model.Sample {
+	return &model.Sample{
+		Metric:    model.Metric{model.MetricNameLabel: model.LabelValue(name), "job": "api"},
+		Value:     model.SampleValue(value),
+		Timestamp: ts,
+	}
+}
+
+func rateRule(output config.RateOutput) *config.RateRule {
+	return &config.RateRule{
+		Match: []*config.RelabelConfig{
+			{
+				SourceLabels: model.LabelNames{model.MetricNameLabel},
+				Regex:        config.MustNewRegexp(".*_total"),
+				Action:       config.RelabelKeep,
+			},
+		},
+		Output:         output,
+		RateSuffix:     "_rate",
+		IncreaseSuffix: "_increase",
+	}
+}
+
+func TestRateConverter(t *testing.T) {
+	cases := []struct {
+		name     string
+		output   config.RateOutput
+		batches  []model.Samples
+		expected model.Samples
+	}{
+		{
+			name:   "rate across batches",
+			output: config.RateOutputRate,
+			batches: []model.Samples{
+				{counterSample("requests_total", 10, 1000)},
+				{counterSample("requests_total", 40, 11000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 40, 11000),
+				counterSample("requests_total_rate", 3, 11000),
+			},
+		},
+		{
+			name:   "increase within a batch",
+			output: config.RateOutputIncrease,
+			batches: []model.Samples{
+				{
+					counterSample("requests_total", 10, 1000),
+					counterSample("requests_total", 40, 11000),
+				},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 10, 1000),
+				counterSample("requests_total", 40, 11000),
+				counterSample("requests_total_increase", 30, 11000),
+			},
+		},
+		{
+			name:   "counter reset",
+			output: config.RateOutputBoth,
+			batches: []model.Samples{
+				{counterSample("requests_total", 100, 1000)},
+				{counterSample("requests_total", 20, 5000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 20, 5000),
+				counterSample("requests_total_rate", 5, 5000),
+				counterSample("requests_total_increase", 20, 5000),
+			},
+		},
+		{
+			name:   "out of order sample",
+			output: config.RateOutputRate,
+			batches: []model.Samples{
+				{counterSample("requests_total", 100, 5000)},
+				{counterSample("requests_total", 90, 1000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 90, 1000),
+			},
+		},
+		{
+			name:   "stale marker ends the series",
+			output: config.RateOutputRate,
+			batches: []model.Samples{
+				{counterSample("requests_total", 100, 1000)},
+				{counterSample("requests_total", staleMarker, 2000)},
+				{counterSample("requests_total", 10, 3000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 10, 3000),
+			},
+		},
+		{
+			name:   "NaN value keeps the series",
+			output: config.RateOutputRate,
+			batches: []model.Samples{
+				{counterSample("requests_total", 100, 1000)},
+				{counterSample("requests_total", math.NaN(), 2000)},
+				{counterSample("requests_total", 120, 3000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 120, 3000),
+				counterSample("requests_total_rate", 10, 3000),
+			},
+		},
+		{
+			name:   "not matching series",
+			output: config.RateOutputRate,
+			batches: []model.Samples{
+				{counterSample("temperature", 10, 1000)},
+				{counterSample("temperature", 20, 2000)},
+			},
+			expected: model.Samples{
+				counterSample("temperature", 20, 2000),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		converter := NewRateConverter(config.CounterRates{
+			SeriesTTL: time.Minute,
+			Rules:     []*config.RateRule{rateRule(c.output)},
+		})
+
+		var actual model.Samples
+		for _, batch := range c.batches {
+			actual = converter.Process(batch)
+		}
+		assert.Equal(t, c.expected, actual, c.name)
+	}
+}
+
+func TestRateConverterEviction(t *testing.T) {
+	now := time.Unix(0, 0)
+	converter := NewRateConverter(config.CounterRates{
+		SeriesTTL: time.Minute,
+		Rules:     []*config.RateRule{rateRule(config.RateOutputRate)},
+	})
+	converter.now = func() time.Time { return now }
+
+	converter.Process(model.Samples{counterSample("requests_total", 10, 1000)})
+	assert.Len(t, converter.series, 1)
+
+	now = now.Add(30 * time.Second)
+	converter.Process(model.Samples{counterSample("other_total", 10, 1000)})
+	assert.Len(t, converter.series, 2)
+
+	now = now.Add(45 * time.Second)
+	actual := converter.Process(model.Samples{counterSample("other_total", 20, 6000)})
+	assert.Len(t, converter.series, 1, "idle series must be evicted")
+	assert.Equal(t, model.Samples{
+		counterSample("other_total", 20, 6000),
+		counterSample("other_total_rate", 2, 6000),
+	}, actual)
+}
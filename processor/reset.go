@@ -0,0 +1,228 @@
+package processor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+const resetProcessor = "reset"
+
+// ResetNormalizer writes counters which keep increasing across counter
+// resets. On every reset the last value before the reset is added to the
+// offset of the series, and the normalized counter is value plus offset.
+// The offsets are saved to the state file periodically and on Flush.
+type ResetNormalizer struct {
+	mtx          sync.Mutex
+	cfg          config.CounterResets
+	series       map[model.Fingerprint]*counterState
+	lastEviction time.Time
+	lastSave     time.Time
+	dirty        bool
+	now          func() time.Time
+}
+
+// savedCounter is the state of a series in the state file
+type savedCounter struct {
+	Value     float64    `json:"value"`
+	Offset    float64    `json:"offset"`
+	Timestamp model.Time `json:"timestamp"`
+}
+
+// NewResetNormalizer returns a ResetNormalizer for the configured rules,
+// restoring the offsets from the state file if there is one.
+func NewResetNormalizer(cfg config.CounterResets) *ResetNormalizer {
+	n := &ResetNormalizer{
+		cfg:    cfg,
+		series: make(map[model.Fingerprint]*counterState),
+		now:    time.Now,
+	}
+	if err := n.load(); err != nil {
+		logrus.Warnf("failed loading counter state from %s: %s", cfg.StateFile, err)
+	}
+	return n
+}
+
+// Process appends the normalized counters of the matching series
+func (n *ResetNormalizer) Process(samples model.Samples) model.Samples {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	now := n.now()
+	result := make(model.Samples, len(samples), len(samples))
+	copy(result, samples)
+
+	for _, sample := range samples {
+		rule := n.match(sample.Metric)
+		if rule == nil {
+			continue
+		}
+
+		// stale markers keep the offset, as the counter may start again.
+		// NaN and infinite values are not normalized, the samples themselves
+		// are written according to the special values config.
+		value := float64(sample.Value)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		fp := sample.Metric.Fingerprint()
+		state, ok := n.series[fp]
+		if !ok {
+			state = &counterState{value: value, timestamp: sample.Timestamp}
+			n.series[fp] = state
+		}
+		state.lastSeen = now
+
+		if sample.Timestamp < state.timestamp {
+			continue
+		}
+		if value < state.value {
+			state.offset += state.value
+		}
+		state.value = value
+		state.timestamp = sample.Timestamp
+		n.dirty = true
+
+		result = append(result, derivedSample(sample, rule.Suffix, value+state.offset))
+	}
+
+	if now.Sub(n.lastEviction) >= n.cfg.SeriesTTL {
+		n.lastEviction = now
+		n.evictIdle(now)
+	}
+	activeSeries.WithLabelValues(resetProcessor).Set(float64(len(n.series)))
+
+	if n.dirty && now.Sub(n.lastSave) >= n.cfg.SaveInterval {
+		n.lastSave = now
+		if err := n.save(); err != nil {
+			logrus.Errorf("failed saving counter state to %s: %s", n.cfg.StateFile, err)
+		} else {
+			n.dirty = false
+		}
+	}
+	return result
+}
+
+// Flush saves the state file if the offsets changed since it was last saved.
+// The normalized counters are written right away, so it returns no samples.
+func (n *ResetNormalizer) Flush() model.Samples {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if !n.dirty {
+		return nil
+	}
+	n.lastSave = n.now()
+	if err := n.save(); err != nil {
+		logrus.Errorf("failed saving counter state to %s: %s", n.cfg.StateFile, err)
+		return nil
+	}
+	n.dirty = false
+	return nil
+}
+
+// evictIdle drops the state of series idle for longer than the series TTL
+// whose normalized counter is the counter itself. Series with an offset are
+// kept until idle for the offset TTL, as their offset is lost when dropped.
+func (n *ResetNormalizer) evictIdle(now time.Time) {
+	for fp, state := range n.series {
+		ttl := n.cfg.SeriesTTL
+		if state.offset != 0 {
+			ttl = n.cfg.OffsetTTL
+		}
+		if now.Sub(state.lastSeen) > ttl {
+			delete(n.series, fp)
+			n.dirty = true
+			evictedSeries.WithLabelValues(resetProcessor).Inc()
+		}
+	}
+}
+
+func (n *ResetNormalizer) match(metric model.Metric) *config.NormalizeRule {
+	for _, rule := range n.cfg.Rules {
+		if relabel.Matches(metric, rule.Match...) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (n *ResetNormalizer) load() error {
+	if n.cfg.StateFile == "" {
+		return nil
+	}
+
+	buf, err := ioutil.ReadFile(n.cfg.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved map[string]savedCounter
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		return err
+	}
+
+	now := n.now()
+	for key, counter := range saved {
+		fp, err := model.FingerprintFromString(key)
+		if err != nil {
+			return err
+		}
+		n.series[fp] = &counterState{
+			value:     counter.Value,
+			offset:    counter.Offset,
+			timestamp: counter.Timestamp,
+			lastSeen:  now,
+		}
+	}
+	logrus.Infof("loaded state of %d counters from %s", len(saved), n.cfg.StateFile)
+	return nil
+}
+
+// save writes the state file atomically by renaming a temporary file
+func (n *ResetNormalizer) save() error {
+	if n.cfg.StateFile == "" {
+		return nil
+	}
+
+	saved := make(map[string]savedCounter, len(n.series))
+	for fp, state := range n.series {
+		saved[fp.String()] = savedCounter{
+			Value:     state.value,
+			Offset:    state.offset,
+			Timestamp: state.timestamp,
+		}
+	}
+	buf, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(n.cfg.StateFile), filepath.Base(n.cfg.StateFile))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), n.cfg.StateFile)
+}
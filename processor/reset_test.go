@@ -0,0 +1,160 @@
+package processor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetConfig(stateFile string) config.CounterResets {
+	return config.CounterResets{
+		StateFile:    stateFile,
+		SaveInterval: time.Minute,
+		SeriesTTL:    time.Hour,
+		OffsetTTL:    24 * time.Hour,
+		Rules: []*config.NormalizeRule{
+			{Match: rateRule(config.RateOutputRate).Match, Suffix: "_normalized"},
+		},
+	}
+}
+
+func TestResetNormalizer(t *testing.T) {
+	cases := []struct {
+		name     string
+		batches  []model.Samples
+		expected model.Samples
+	}{
+		{
+			name: "no reset",
+			batches: []model.Samples{
+				{counterSample("requests_total", 10, 1000)},
+				{counterSample("requests_total", 40, 2000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 40, 2000),
+				counterSample("requests_total_normalized", 40, 2000),
+			},
+		},
+		{
+			name: "resets",
+			batches: []model.Samples{
+				{
+					counterSample("requests_total", 100, 1000),
+					counterSample("requests_total", 20, 2000),
+				},
+				{counterSample("requests_total", 5, 3000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 5, 3000),
+				counterSample("requests_total_normalized", 125, 3000),
+			},
+		},
+		{
+			name: "out of order sample",
+			batches: []model.Samples{
+				{counterSample("requests_total", 100, 5000)},
+				{counterSample("requests_total", 90, 1000)},
+			},
+			expected: model.Samples{
+				counterSample("requests_total", 90, 1000),
+			},
+		},
+		{
+			name: "not matching series",
+			batches: []model.Samples{
+				{counterSample("temperature", 10, 1000)},
+				{counterSample("temperature", 5, 2000)},
+			},
+			expected: model.Samples{
+				counterSample("temperature", 5, 2000),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		normalizer := NewResetNormalizer(resetConfig(""))
+
+		var actual model.Samples
+		for _, batch := range c.batches {
+			actual = normalizer.Process(batch)
+		}
+		assert.Equal(t, c.expected, actual, c.name)
+	}
+}
+
+func TestResetNormalizerStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reset")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "counters.json")
+
+	now := time.Unix(0, 0)
+	normalizer := NewResetNormalizer(resetConfig(stateFile))
+	normalizer.now = func() time.Time { return now }
+	normalizer.Process(model.Samples{counterSample("requests_total", 100, 1000)})
+	normalizer.Process(model.Samples{counterSample("requests_total", 20, 2000)})
+
+	// only the first batch is saved before the save interval passed
+	restarted := NewResetNormalizer(resetConfig(stateFile))
+	actual := restarted.Process(model.Samples{counterSample("requests_total", 30, 3000)})
+	assert.Equal(t, counterSample("requests_total_normalized", 130, 3000), actual[1])
+
+	now = now.Add(time.Minute)
+	normalizer.Process(model.Samples{counterSample("requests_total", 25, 3000)})
+
+	restarted = NewResetNormalizer(resetConfig(stateFile))
+	actual = restarted.Process(model.Samples{counterSample("requests_total", 10, 4000)})
+	assert.Equal(t, counterSample("requests_total_normalized", 135, 4000), actual[1])
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1, "temporary files must be renamed")
+}
+
+func TestResetNormalizerIdleSeries(t *testing.T) {
+	now := time.Unix(0, 0)
+	normalizer := NewResetNormalizer(resetConfig(""))
+	normalizer.now = func() time.Time { return now }
+	normalizer.Process(model.Samples{
+		counterSample("requests_total", 100, 1000),
+		counterSample("requests_total", 20, 2000),
+		counterSample("errors_total", 5, 2000),
+	})
+
+	// the series without offset is evicted, the offset is kept
+	now = now.Add(2 * time.Hour)
+	normalizer.Process(nil)
+	assert.Len(t, normalizer.series, 1)
+	actual := normalizer.Process(model.Samples{counterSample("requests_total", 30, 3000)})
+	assert.Equal(t, counterSample("requests_total_normalized", 130, 3000), actual[1])
+
+	now = now.Add(25 * time.Hour)
+	normalizer.Process(nil)
+	assert.Empty(t, normalizer.series, "offsets must be evicted after the offset TTL")
+}
+
+func TestResetNormalizerFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reset")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "counters.json")
+
+	normalizer := NewResetNormalizer(resetConfig(stateFile))
+	normalizer.Process(model.Samples{counterSample("requests_total", 100, 1000)})
+	normalizer.Process(model.Samples{counterSample("requests_total", 20, 2000)})
+	assert.Empty(t, normalizer.Flush())
+
+	// the offset is saved on flush, though the save interval did not pass
+	restarted := NewResetNormalizer(resetConfig(stateFile))
+	actual := restarted.Process(model.Samples{counterSample("requests_total", 30, 3000)})
+	assert.Equal(t, counterSample("requests_total_normalized", 130, 3000), actual[1])
+}
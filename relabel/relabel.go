@@ -12,7 +12,7 @@ import (
 //Process the samples and apply RelabelConfig
 func Process(metric model.Metric, cfgs ...*config.RelabelConfig) model.Metric {
 	for _, cfg := range cfgs {
-		metric = relabel(metric, cfg)
+		metric, _, _ = relabel(metric, cfg)
 		if metric == nil {
 			return nil
 		}
@@ -20,34 +20,96 @@ func Process(metric model.Metric, cfgs ...*config.RelabelConfig) model.Metric {
 	return metric
 }
 
-func relabel(metric model.Metric, cfg *config.RelabelConfig) model.Metric {
+// ProcessBatch applies the RelabelConfigs to the metrics in place like
+// Process, setting the dropped ones to nil
+func ProcessBatch(metrics []model.Metric, cfgs ...*config.RelabelConfig) {
+	for i, metric := range metrics {
+		if metric != nil {
+			metrics[i] = Process(metric, cfgs...)
+		}
+	}
+}
+
+// Step is the result of a relabel rule applied to a metric
+type Step struct {
+	Rule    int
+	Config  *config.RelabelConfig
+	Matched bool
+	// Result is one of DroppedResult, ChangedResult or UnchangedResult
+	Result string
+	// Metric is the metric after the rule, nil if it was dropped
+	Metric model.Metric
+}
+
+// Explain applies the RelabelConfigs to a copy of the metric like Process and
+// returns the metric after every rule, up to the rule dropping it
+func Explain(metric model.Metric, cfgs ...*config.RelabelConfig) []Step {
+	metric = metric.Clone()
+	steps := make([]Step, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		var matched, changed bool
+		metric, matched, changed = relabel(metric, cfg)
+		step := Step{Rule: i, Config: cfg, Matched: matched, Result: result(metric == nil, changed)}
+		if metric != nil {
+			step.Metric = metric.Clone()
+		}
+		steps = append(steps, step)
+		if metric == nil {
+			break
+		}
+	}
+	return steps
+}
+
+// Matches tells if the metric is kept by all of the keep and drop configs
+func Matches(metric model.Metric, cfgs ...*config.RelabelConfig) bool {
+	for _, cfg := range cfgs {
+		matched := cfg.Regex.MatchString(sourceValue(metric, cfg))
+		if matched == (cfg.Action == config.RelabelDrop) {
+			return false
+		}
+	}
+	return true
+}
+
+func sourceValue(metric model.Metric, cfg *config.RelabelConfig) string {
 	values := make([]string, 0, len(cfg.SourceLabels))
 	for _, labelName := range cfg.SourceLabels {
 		values = append(values, string(metric[labelName]))
 	}
-	valueOfSourceLabels := strings.Join(values, cfg.Separator)
+	return strings.Join(values, cfg.Separator)
+}
+
+// relabel applies the config to the metric. It tells if the regex matched
+// and if the metric was changed.
+func relabel(metric model.Metric, cfg *config.RelabelConfig) (model.Metric, bool, bool) {
+	valueOfSourceLabels := sourceValue(metric, cfg)
 
+	var matched, changed bool
 	switch cfg.Action {
 	case config.RelabelDrop:
 		if cfg.Regex.MatchString(valueOfSourceLabels) {
 			logrus.Debug("dropping metric with values: ", valueOfSourceLabels)
-			return nil
+			return nil, true, false
 		}
 	case config.RelabelKeep:
 		if !cfg.Regex.MatchString(valueOfSourceLabels) {
 			logrus.Debug("dropping metric with values: ", valueOfSourceLabels)
-			return nil
+			return nil, false, false
 		}
+		matched = true
 	case config.RelabelAddPrefix:
 		if cfg.Regex.MatchString(valueOfSourceLabels) {
 			metric[model.MetricNameLabel] = model.LabelValue(fmt.Sprintf("%s%s", cfg.Prefix, metric[model.MetricNameLabel]))
 			logrus.Debugf("Added prefix [%s]: %s\n", cfg.Prefix, metric[model.MetricNameLabel])
+			matched, changed = true, true
 		}
 	case config.RelabelLabelDrop:
 		for labelName := range metric {
 			if cfg.Regex.MatchString(string(labelName)) {
 				logrus.Debugf("dropping label [%s] in metric [%s]: ", labelName, string(metric["__name__"]))
 				delete(metric, labelName)
+				matched, changed = true, true
 			}
 		}
 	case config.RelabelLabelKeep:
@@ -55,10 +117,13 @@ func relabel(metric model.Metric, cfg *config.RelabelConfig) model.Metric {
 			if !cfg.Regex.MatchString(string(labelName)) {
 				logrus.Debugf("dropping labels [%s] from metric [%s]", labelName, string(metric["__name__"]))
 				delete(metric, labelName)
+				changed = true
+			} else {
+				matched = true
 			}
 		}
 	default:
 		logrus.Warnf("warn: retrieval.relabel: unknown relabel action type %s\n", cfg.Action)
 	}
-	return metric
+	return metric, matched, changed
 }
@@ -228,3 +228,62 @@ func TestRelabel(t *testing.T) {
 		}
 	}
 }
+
+func TestMatches(t *testing.T) {
+	metric := model.Metric{
+		"__name__": "http_requests_total",
+		"job":      "api",
+	}
+
+	cases := []struct {
+		name     string
+		matchers []*config.RelabelConfig
+		expected bool
+	}{
+		{
+			name:     "no matchers",
+			expected: true,
+		},
+		{
+			name: "keep matches",
+			matchers: []*config.RelabelConfig{
+				{
+					SourceLabels: model.LabelNames{"__name__"},
+					Regex:        config.MustNewRegexp(".*_total"),
+					Action:       config.RelabelKeep,
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "keep does not match",
+			matchers: []*config.RelabelConfig{
+				{
+					SourceLabels: model.LabelNames{"__name__"},
+					Regex:        config.MustNewRegexp("^up$"),
+					Action:       config.RelabelKeep,
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "drop matches joined source labels",
+			matchers: []*config.RelabelConfig{
+				{
+					SourceLabels: model.LabelNames{"__name__", "job"},
+					Separator:    ";",
+					Regex:        config.MustNewRegexp("^http_requests_total;api$"),
+					Action:       config.RelabelDrop,
+				},
+			},
+			expected: false,
+		},
+	}
+
+	for _, c := range cases {
+		actual := Matches(metric, c.matchers...)
+		if actual != c.expected {
+			t.Errorf("case '%s'. Expected %v, got %v", c.name, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package relabel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// ParseSeries parses a series in the text exposition format, optionally
+// followed by value and timestamp, which default to 1 and now
+func ParseSeries(s string) (*model.Sample, error) {
+	s = strings.TrimSpace(s)
+	sample := &model.Sample{Metric: make(model.Metric), Value: 1, Timestamp: model.Now()}
+
+	end := strings.IndexAny(s, "{ \t")
+	if end < 0 {
+		end = len(s)
+	}
+	if end > 0 {
+		sample.Metric[model.MetricNameLabel] = model.LabelValue(s[:end])
+	}
+	s = s[end:]
+
+	if strings.HasPrefix(s, "{") {
+		rest, err := parseLabels(s[1:], sample.Metric)
+		if err != nil {
+			return nil, err
+		}
+		s = rest
+	}
+	if len(sample.Metric) == 0 {
+		return nil, fmt.Errorf("series without labels")
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("unexpected %q after the series", strings.Join(fields[2:], " "))
+	}
+	if len(fields) > 0 {
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", fields[0])
+		}
+		sample.Value = model.SampleValue(value)
+	}
+	if len(fields) > 1 {
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		sample.Timestamp = model.Time(timestamp)
+	}
+	return sample, nil
+}
+
+// parseLabels parses the labels up to the closing brace and returns the rest
+func parseLabels(s string, metric model.Metric) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+
+		eq := strings.Index(s, "=")
+		if eq <= 0 {
+			return "", fmt.Errorf("expected label name and value at %q", s)
+		}
+		name := model.LabelName(strings.TrimSpace(s[:eq]))
+		if !name.IsValid() {
+			return "", fmt.Errorf("invalid label name %q", name)
+		}
+
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		value, rest, err := parseQuoted(s)
+		if err != nil {
+			return "", err
+		}
+		metric[name] = model.LabelValue(value)
+		s = rest
+	}
+}
+
+// parseQuoted parses the double quoted string the input starts with
+func parseQuoted(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("expected quoted label value at %q", s)
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err := strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated label value %q", s)
+}
@@ -0,0 +1,69 @@
+package relabel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeries(t *testing.T) {
+	cases := []struct {
+		name     string
+		series   string
+		expected *model.Sample
+		err      string
+	}{
+		{
+			name:     "name only",
+			series:   "up 0 1000",
+			expected: &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up"}, Value: 0, Timestamp: 1000},
+		},
+		{
+			name:   "labels",
+			series: ` http_requests_total{job="api", path="/a\"b", } 12.5 2000`,
+			expected: &model.Sample{
+				Metric:    model.Metric{model.MetricNameLabel: "http_requests_total", "job": "api", "path": `/a"b`},
+				Value:     12.5,
+				Timestamp: 2000,
+			},
+		},
+		{
+			name:     "name label",
+			series:   `{__name__="up",job="api"} 1 3000`,
+			expected: &model.Sample{Metric: model.Metric{model.MetricNameLabel: "up", "job": "api"}, Value: 1, Timestamp: 3000},
+		},
+		{
+			name:   "unterminated label value",
+			series: `up{job="api}`,
+			err:    `unterminated label value "\"api}"`,
+		},
+		{
+			name:   "invalid value",
+			series: `up{job="api"} x`,
+			err:    `invalid value "x"`,
+		},
+		{
+			name:   "empty",
+			series: ``,
+			err:    "series without labels",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := ParseSeries(c.series)
+		if c.err != "" {
+			assert.EqualError(t, err, c.err, c.name)
+			continue
+		}
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.expected, actual, c.name)
+	}
+
+	// value and timestamp default to 1 and now
+	sample, err := ParseSeries(`up{job="api"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, model.SampleValue(1), sample.Value)
+	assert.WithinDuration(t, time.Now(), sample.Timestamp.Time(), time.Minute)
+}
@@ -0,0 +1,257 @@
+package relabel
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+)
+
+// Results of a relabel rule applied to a series
+const (
+	DroppedResult   = "dropped"
+	ChangedResult   = "changed"
+	UnchangedResult = "unchanged"
+)
+
+var (
+	ruleSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "relabel_rule_series_total",
+			Help: "Total number of distinct series a metric relabel rule was applied to, by rule index, action and result.",
+		},
+		[]string{"rule", "action", "result"},
+	)
+	ruleMatchedSeries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "relabel_rule_matched_series_total",
+			Help: "Total number of distinct series matched by the regex of a metric relabel rule, by rule index and action.",
+		},
+		[]string{"rule", "action"},
+	)
+	ruleSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "relabel_rule_seconds_total",
+			Help: "Total time spent applying a metric relabel rule, by rule index and action.",
+		},
+		[]string{"rule", "action"},
+	)
+)
+
+func RegisterPrometheusMetrics() {
+	prometheus.MustRegister(ruleSeries)
+	prometheus.MustRegister(ruleMatchedSeries)
+	prometheus.MustRegister(ruleSeconds)
+}
+
+// seriesTTL is how long a series is remembered as counted after its last
+// sample. A series idle for longer is counted again when it comes back.
+const seriesTTL = 15 * time.Minute
+
+// RuleStats counts the distinct series a relabel rule was applied to and the
+// time spent applying it
+type RuleStats struct {
+	Matched   uint64
+	Dropped   uint64
+	Changed   uint64
+	Unchanged uint64
+	Duration  time.Duration
+}
+
+// ruleCounters are the statistics of a rule and its counters, looked up once
+// per rule instead of per series
+type ruleCounters struct {
+	// accessed atomically, first for 64 bit alignment
+	stats RuleStats
+
+	matched   prometheus.Counter
+	dropped   prometheus.Counter
+	changed   prometheus.Counter
+	unchanged prometheus.Counter
+	seconds   prometheus.Counter
+}
+
+// Relabeler applies metric relabel configs and counts the distinct series
+// every rule was applied to, by the index of the rule. As relabeling only
+// depends on the labels, the result of a rule is the same for every sample of
+// a series, which is counted the first time it is seen. A Relabeler is created
+// per config, as the index may refer to another rule after the config
+// changed.
+type Relabeler struct {
+	// accessed atomically, set once the Relabeler is retired
+	retired int32
+
+	cfgs  []*config.RelabelConfig
+	rules []*ruleCounters
+
+	mtx          sync.Mutex
+	seen         map[model.Fingerprint]time.Time
+	lastEviction time.Time
+}
+
+// NewRelabeler returns a Relabeler of the configs, with counters starting
+// from zero
+func NewRelabeler(cfgs []*config.RelabelConfig) *Relabeler {
+	r := &Relabeler{
+		cfgs:         cfgs,
+		rules:        make([]*ruleCounters, len(cfgs)),
+		seen:         make(map[model.Fingerprint]time.Time),
+		lastEviction: time.Now(),
+	}
+	for i, cfg := range cfgs {
+		rule, action := strconv.Itoa(i), string(cfg.Action)
+		r.rules[i] = &ruleCounters{
+			matched:   ruleMatchedSeries.WithLabelValues(rule, action),
+			dropped:   ruleSeries.WithLabelValues(rule, action, DroppedResult),
+			changed:   ruleSeries.WithLabelValues(rule, action, ChangedResult),
+			unchanged: ruleSeries.WithLabelValues(rule, action, UnchangedResult),
+			seconds:   ruleSeconds.WithLabelValues(rule, action),
+		}
+	}
+	return r
+}
+
+// ProcessBatch applies the relabel configs to the metrics in place, setting
+// the dropped ones to nil. The configs are applied rule by rule to the whole
+// batch, so the time spent is measured once per rule and batch. The result of
+// every rule is counted for the series not seen before, unless the Relabeler
+// was retired.
+func (r *Relabeler) ProcessBatch(metrics []model.Metric) {
+	r.processBatch(metrics, true)
+}
+
+// ProcessBatchUncounted applies the relabel configs to the metrics in place
+// without counting them, like exemplars, which are not series of their own
+func (r *Relabeler) ProcessBatchUncounted(metrics []model.Metric) {
+	r.processBatch(metrics, false)
+}
+
+// ProcessUncounted applies the relabel configs to the metric without
+// counting it
+func (r *Relabeler) ProcessUncounted(metric model.Metric) model.Metric {
+	return Process(metric, r.cfgs...)
+}
+
+func (r *Relabeler) processBatch(metrics []model.Metric, counted bool) {
+	if !counted || len(r.cfgs) == 0 || atomic.LoadInt32(&r.retired) != 0 {
+		ProcessBatch(metrics, r.cfgs...)
+		return
+	}
+
+	unseen := r.unseen(metrics)
+	for i, cfg := range r.cfgs {
+		rule := r.rules[i]
+		begin := time.Now()
+		for j, metric := range metrics {
+			if metric == nil {
+				continue
+			}
+			var matched, changed bool
+			metrics[j], matched, changed = relabel(metric, cfg)
+			if unseen[j] {
+				rule.observe(metrics[j] == nil, matched, changed)
+			}
+		}
+		rule.observeDuration(time.Since(begin))
+	}
+}
+
+// unseen tells which metrics are of series not seen before, neither earlier
+// in the batch nor within the series TTL, and marks them as seen. It must be
+// called before relabeling, as the series are the ones received.
+func (r *Relabeler) unseen(metrics []model.Metric) []bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastEviction) >= seriesTTL {
+		for fingerprint, lastSeen := range r.seen {
+			if now.Sub(lastSeen) >= seriesTTL {
+				delete(r.seen, fingerprint)
+			}
+		}
+		r.lastEviction = now
+	}
+
+	unseen := make([]bool, len(metrics))
+	for i, metric := range metrics {
+		if metric == nil {
+			continue
+		}
+		fingerprint := metric.Fingerprint()
+		if _, ok := r.seen[fingerprint]; !ok {
+			unseen[i] = true
+		}
+		r.seen[fingerprint] = now
+	}
+	return unseen
+}
+
+// Stats returns the statistics of the rules
+func (r *Relabeler) Stats() []RuleStats {
+	stats := make([]RuleStats, len(r.rules))
+	for i, c := range r.rules {
+		stats[i] = RuleStats{
+			Matched:   atomic.LoadUint64(&c.stats.Matched),
+			Dropped:   atomic.LoadUint64(&c.stats.Dropped),
+			Changed:   atomic.LoadUint64(&c.stats.Changed),
+			Unchanged: atomic.LoadUint64(&c.stats.Unchanged),
+			Duration:  time.Duration(atomic.LoadInt64((*int64)(&c.stats.Duration))),
+		}
+	}
+	return stats
+}
+
+// Retire stops counting and deletes the counters of the rules, so the
+// counters of a Relabeler replacing it start from zero like its statistics.
+// Requests which started with the Relabeler may still be relabeling with
+// it, without being counted.
+func (r *Relabeler) Retire() {
+	atomic.StoreInt32(&r.retired, 1)
+	for i, cfg := range r.cfgs {
+		rule, action := strconv.Itoa(i), string(cfg.Action)
+		ruleMatchedSeries.DeleteLabelValues(rule, action)
+		ruleSeries.DeleteLabelValues(rule, action, DroppedResult)
+		ruleSeries.DeleteLabelValues(rule, action, ChangedResult)
+		ruleSeries.DeleteLabelValues(rule, action, UnchangedResult)
+		ruleSeconds.DeleteLabelValues(rule, action)
+	}
+}
+
+func (c *ruleCounters) observe(dropped, matched, changed bool) {
+	if matched {
+		atomic.AddUint64(&c.stats.Matched, 1)
+		c.matched.Inc()
+	}
+	switch result(dropped, changed) {
+	case DroppedResult:
+		atomic.AddUint64(&c.stats.Dropped, 1)
+		c.dropped.Inc()
+	case ChangedResult:
+		atomic.AddUint64(&c.stats.Changed, 1)
+		c.changed.Inc()
+	default:
+		atomic.AddUint64(&c.stats.Unchanged, 1)
+		c.unchanged.Inc()
+	}
+}
+
+func (c *ruleCounters) observeDuration(duration time.Duration) {
+	atomic.AddInt64((*int64)(&c.stats.Duration), int64(duration))
+	c.seconds.Add(duration.Seconds())
+}
+
+func result(dropped, changed bool) string {
+	switch {
+	case dropped:
+		return DroppedResult
+	case changed:
+		return ChangedResult
+	default:
+		return UnchangedResult
+	}
+}
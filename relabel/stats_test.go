@@ -0,0 +1,123 @@
+package relabel
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	cfgs := []*config.RelabelConfig{
+		{
+			SourceLabels: model.LabelNames{model.MetricNameLabel},
+			Regex:        config.MustNewRegexp("debug_.*"),
+			Action:       config.RelabelDrop,
+		},
+		{
+			Regex:  config.MustNewRegexp("pod"),
+			Action: config.RelabelLabelDrop,
+		},
+		{
+			SourceLabels: model.LabelNames{"job"},
+			Regex:        config.MustNewRegexp("api"),
+			Action:       config.RelabelAddPrefix,
+			Prefix:       "api.",
+		},
+	}
+
+	cfgs = append(cfgs, &config.RelabelConfig{
+		SourceLabels: model.LabelNames{"job"},
+		Regex:        config.MustNewRegexp("db"),
+		Action:       config.RelabelDrop,
+	})
+
+	relabeler := NewRelabeler(cfgs)
+	metrics := []model.Metric{
+		{model.MetricNameLabel: "debug_requests", "job": "api"},
+		{model.MetricNameLabel: "up", "job": "api", "pod": "a"},
+		{model.MetricNameLabel: "up", "job": "api", "pod": "a"},
+	}
+	relabeler.ProcessBatch(metrics)
+	assert.Equal(t, []model.Metric{
+		nil,
+		{model.MetricNameLabel: "api.up", "job": "api"},
+		{model.MetricNameLabel: "api.up", "job": "api"},
+	}, metrics)
+
+	// series seen in an earlier batch are not counted again
+	relabeler.ProcessBatch([]model.Metric{
+		{model.MetricNameLabel: "up", "job": "api", "pod": "a"},
+		{model.MetricNameLabel: "up", "job": "db", "pod": "b"},
+	})
+
+	// exemplars are relabeled without being counted
+	assert.Nil(t, relabeler.ProcessUncounted(model.Metric{model.MetricNameLabel: "debug_requests"}))
+	exemplars := []model.Metric{{model.MetricNameLabel: "debug_errors"}}
+	relabeler.ProcessBatchUncounted(exemplars)
+	assert.Equal(t, []model.Metric{nil}, exemplars)
+
+	stats := relabeler.Stats()
+	for i := range stats {
+		stats[i].Duration = 0
+	}
+	assert.Equal(t, []RuleStats{
+		{Matched: 1, Dropped: 1, Unchanged: 2},
+		{Matched: 2, Changed: 2},
+		{Matched: 1, Changed: 1, Unchanged: 1},
+		{Matched: 1, Dropped: 1, Unchanged: 1},
+	}, stats, "every series is counted once")
+	assert.Equal(t, 2.0, counterValue(t, ruleSeries.WithLabelValues("1", "labeldrop", ChangedResult)))
+
+	// the counters of a relabeler of another config start from zero
+	relabeler.Retire()
+	reloaded := NewRelabeler(cfgs[1:])
+	assert.Equal(t, make([]RuleStats, 3), reloaded.Stats())
+	assert.Equal(t, 0.0, counterValue(t, ruleSeries.WithLabelValues("1", "addprefix", ChangedResult)))
+
+	// requests still relabeling with the retired relabeler are not counted
+	metrics = []model.Metric{{model.MetricNameLabel: "debug_panics"}}
+	relabeler.ProcessBatch(metrics)
+	assert.Equal(t, []model.Metric{nil}, metrics)
+	assert.Equal(t, uint64(1), relabeler.Stats()[0].Dropped)
+	assert.Equal(t, 0.0, counterValue(t, ruleSeries.WithLabelValues("0", "drop", DroppedResult)))
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := counter.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestExplain(t *testing.T) {
+	cfgs := []*config.RelabelConfig{
+		{
+			Regex:  config.MustNewRegexp("pod"),
+			Action: config.RelabelLabelDrop,
+		},
+		{
+			SourceLabels: model.LabelNames{"job"},
+			Regex:        config.MustNewRegexp("db"),
+			Action:       config.RelabelDrop,
+		},
+		{
+			SourceLabels: model.LabelNames{"job"},
+			Regex:        config.MustNewRegexp("api"),
+			Action:       config.RelabelKeep,
+		},
+	}
+
+	metric := model.Metric{model.MetricNameLabel: "up", "job": "db", "pod": "a"}
+	assert.Equal(t, []Step{
+		{Rule: 0, Config: cfgs[0], Matched: true, Result: ChangedResult, Metric: model.Metric{model.MetricNameLabel: "up", "job": "db"}},
+		{Rule: 1, Config: cfgs[1], Matched: true, Result: DroppedResult},
+	}, Explain(metric, cfgs...))
+
+	// the metric is not modified
+	assert.Equal(t, model.Metric{model.MetricNameLabel: "up", "job": "db", "pod": "a"}, metric)
+}
@@ -0,0 +1,404 @@
+package remote
+
+// Unmarshal decodes a protobuf encoded prometheus.WriteRequest. Fields which
+// are not known to this package are skipped.
+func (m *WriteRequest) Unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			b, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			var ts TimeSeries
+			if err := ts.unmarshal(b); err != nil {
+				return err
+			}
+			m.Timeseries = append(m.Timeseries, ts)
+		case field == 3 && wire == wireBytes:
+			b, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			var md MetricMetadata
+			if err := md.unmarshal(b); err != nil {
+				return err
+			}
+			m.Metadata = append(m.Metadata, md)
+		default:
+			if err := d.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Marshal encodes the WriteRequest in protobuf wire format
+func (m *WriteRequest) Marshal() ([]byte, error) {
+	e := &encoder{}
+	for i := range m.Timeseries {
+		e.message(1, m.Timeseries[i].marshal)
+	}
+	for i := range m.Metadata {
+		e.message(3, m.Metadata[i].marshal)
+	}
+	return e.buf, nil
+}
+
+func (m *TimeSeries) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		if wire != wireBytes || field < 1 || field > 4 {
+			if err := d.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			var l Label
+			err = l.unmarshal(b)
+			m.Labels = append(m.Labels, l)
+		case 2:
+			var s Sample
+			err = s.unmarshal(b)
+			m.Samples = append(m.Samples, s)
+		case 3:
+			var ex Exemplar
+			err = ex.unmarshal(b, nil)
+			m.Exemplars = append(m.Exemplars, ex)
+		case 4:
+			var h Histogram
+			err = h.unmarshal(b)
+			m.Histograms = append(m.Histograms, h)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *TimeSeries) marshal(e *encoder) {
+	for i := range m.Labels {
+		e.message(1, m.Labels[i].marshal)
+	}
+	for i := range m.Samples {
+		e.message(2, m.Samples[i].marshal)
+	}
+	for i := range m.Exemplars {
+		e.message(3, func(e *encoder) {
+			m.Exemplars[i].marshal(e, nil)
+		})
+	}
+	for i := range m.Histograms {
+		e.message(4, m.Histograms[i].marshal)
+	}
+}
+
+// unmarshal decodes a v1 exemplar, or a v2 exemplar when a symbol table is given
+func (m *Exemplar) unmarshal(b []byte, symbols []string) error {
+	var refs []uint32
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && symbols != nil:
+			err = d.packed(wire, func(d *decoder) error {
+				ref, err := d.varint()
+				refs = append(refs, uint32(ref))
+				return err
+			})
+		case field == 1 && wire == wireBytes:
+			var l Label
+			if b, err = d.bytes(); err == nil {
+				err = l.unmarshal(b)
+			}
+			m.Labels = append(m.Labels, l)
+		case field == 2 && wire == wireFixed64:
+			m.Value, err = d.double()
+		case field == 3 && wire == wireVarint:
+			var x uint64
+			x, err = d.varint()
+			m.Timestamp = int64(x)
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if symbols == nil {
+		return nil
+	}
+	labels, err := resolveLabels(refs, symbols)
+	m.Labels = labels
+	return err
+}
+
+// marshal encodes a v1 exemplar, or a v2 exemplar when a symbol table is given
+func (m *Exemplar) marshal(e *encoder, table *symbolTable) {
+	if table != nil {
+		e.labelRefs(1, m.Labels, table)
+	} else {
+		for i := range m.Labels {
+			e.message(1, m.Labels[i].marshal)
+		}
+	}
+	e.double(2, m.Value)
+	e.int(3, m.Timestamp)
+}
+
+func (m *MetricMetadata) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == wireVarint:
+			var x uint64
+			x, err = d.varint()
+			m.Type = MetricType(x)
+		case field == 2 && wire == wireBytes:
+			m.MetricFamilyName, err = d.string()
+		case field == 4 && wire == wireBytes:
+			m.Help, err = d.string()
+		case field == 5 && wire == wireBytes:
+			m.Unit, err = d.string()
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MetricMetadata) marshal(e *encoder) {
+	e.uint(1, uint64(m.Type))
+	e.string(2, m.MetricFamilyName)
+	e.string(4, m.Help)
+	e.string(5, m.Unit)
+}
+
+func (m *Label) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == wireBytes:
+			m.Name, err = d.string()
+		case field == 2 && wire == wireBytes:
+			m.Value, err = d.string()
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Label) marshal(e *encoder) {
+	e.string(1, m.Name)
+	e.string(2, m.Value)
+}
+
+func (m *Sample) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 1 && wire == wireFixed64:
+			m.Value, err = d.double()
+		case field == 2 && wire == wireVarint:
+			var x uint64
+			x, err = d.varint()
+			m.Timestamp = int64(x)
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Sample) marshal(e *encoder) {
+	e.double(1, m.Value)
+	e.int(2, m.Timestamp)
+}
+
+func (m *Histogram) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		var x uint64
+		switch {
+		case field == 1 && wire == wireVarint:
+			m.CountInt, err = d.varint()
+		case field == 2 && wire == wireFixed64:
+			m.CountFloat, err = d.double()
+			m.IsFloat = true
+		case field == 3 && wire == wireFixed64:
+			m.Sum, err = d.double()
+		case field == 4 && wire == wireVarint:
+			var s int64
+			s, err = d.zigzag()
+			m.Schema = int32(s)
+		case field == 5 && wire == wireFixed64:
+			m.ZeroThreshold, err = d.double()
+		case field == 6 && wire == wireVarint:
+			m.ZeroCountInt, err = d.varint()
+		case field == 7 && wire == wireFixed64:
+			m.ZeroCountFloat, err = d.double()
+			m.IsFloat = true
+		case field == 8 && wire == wireBytes:
+			m.NegativeSpans, err = appendSpan(d, m.NegativeSpans)
+		case field == 9:
+			err = d.packed(wire, func(d *decoder) error {
+				v, err := d.zigzag()
+				m.NegativeDeltas = append(m.NegativeDeltas, v)
+				return err
+			})
+		case field == 10:
+			err = d.packed(wire, func(d *decoder) error {
+				v, err := d.double()
+				m.NegativeCounts = append(m.NegativeCounts, v)
+				return err
+			})
+			m.IsFloat = true
+		case field == 11 && wire == wireBytes:
+			m.PositiveSpans, err = appendSpan(d, m.PositiveSpans)
+		case field == 12:
+			err = d.packed(wire, func(d *decoder) error {
+				v, err := d.zigzag()
+				m.PositiveDeltas = append(m.PositiveDeltas, v)
+				return err
+			})
+		case field == 13:
+			err = d.packed(wire, func(d *decoder) error {
+				v, err := d.double()
+				m.PositiveCounts = append(m.PositiveCounts, v)
+				return err
+			})
+			m.IsFloat = true
+		case field == 14 && wire == wireVarint:
+			x, err = d.varint()
+			m.ResetHint = ResetHint(x)
+		case field == 15 && wire == wireVarint:
+			x, err = d.varint()
+			m.Timestamp = int64(x)
+		case field == 16:
+			err = d.packed(wire, func(d *decoder) error {
+				v, err := d.double()
+				m.CustomValues = append(m.CustomValues, v)
+				return err
+			})
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Histogram) marshal(e *encoder) {
+	if m.IsFloat {
+		e.oneofDouble(2, m.CountFloat)
+	} else {
+		e.uint(1, m.CountInt)
+	}
+	e.double(3, m.Sum)
+	e.sint(4, int64(m.Schema))
+	e.double(5, m.ZeroThreshold)
+	if m.IsFloat {
+		e.oneofDouble(7, m.ZeroCountFloat)
+	} else {
+		e.uint(6, m.ZeroCountInt)
+	}
+	for i := range m.NegativeSpans {
+		e.message(8, m.NegativeSpans[i].marshal)
+	}
+	e.packedSint(9, m.NegativeDeltas)
+	e.packedDouble(10, m.NegativeCounts)
+	for i := range m.PositiveSpans {
+		e.message(11, m.PositiveSpans[i].marshal)
+	}
+	e.packedSint(12, m.PositiveDeltas)
+	e.packedDouble(13, m.PositiveCounts)
+	e.uint(14, uint64(m.ResetHint))
+	e.int(15, m.Timestamp)
+	e.packedDouble(16, m.CustomValues)
+}
+
+func appendSpan(d *decoder, spans []BucketSpan) ([]BucketSpan, error) {
+	b, err := d.bytes()
+	if err != nil {
+		return spans, err
+	}
+	var span BucketSpan
+	inner := &decoder{buf: b}
+	for !inner.done() {
+		field, wire, err := inner.key()
+		if err != nil {
+			return spans, err
+		}
+		switch {
+		case field == 1 && wire == wireVarint:
+			var x int64
+			x, err = inner.zigzag()
+			span.Offset = int32(x)
+		case field == 2 && wire == wireVarint:
+			var x uint64
+			x, err = inner.varint()
+			span.Length = uint32(x)
+		default:
+			err = inner.skip(wire)
+		}
+		if err != nil {
+			return spans, err
+		}
+	}
+	return append(spans, span), nil
+}
+
+func (m *BucketSpan) marshal(e *encoder) {
+	e.sint(1, int64(m.Offset))
+	e.uint(2, uint64(m.Length))
+}
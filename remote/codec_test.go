@@ -0,0 +1,168 @@
+package remote
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestUnmarshalPrompbWriteRequest(t *testing.T) {
+	in := &prompb.WriteRequest{
+		Timeseries: []*prompb.TimeSeries{
+			{
+				Labels: []*prompb.Label{
+					{Name: "__name__", Value: "up"},
+					{Name: "job", Value: "api"},
+				},
+				Samples: []*prompb.Sample{
+					{Value: 1, Timestamp: 1000},
+					{Value: 0, Timestamp: 2000},
+					{Value: -1.5, Timestamp: -1},
+				},
+			},
+		},
+	}
+	buf, err := proto.Marshal(in)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err)
+	}
+
+	expected := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels: []Label{
+					{Name: "__name__", Value: "up"},
+					{Name: "job", Value: "api"},
+				},
+				Samples: []Sample{
+					{Value: 1, Timestamp: 1000},
+					{Value: 0, Timestamp: 2000},
+					{Value: -1.5, Timestamp: -1},
+				},
+			},
+		},
+	}
+
+	var actual WriteRequest
+	if err := actual.Unmarshal(buf); err != nil {
+		t.Fatalf("failed to unmarshal request: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		req  WriteRequest
+	}{
+		{
+			name: "integer histogram",
+			req: WriteRequest{
+				Timeseries: []TimeSeries{
+					{
+						Labels: []Label{{Name: "__name__", Value: "latency_seconds"}},
+						Histograms: []Histogram{
+							{
+								CountInt:       9,
+								Sum:            18.4,
+								Schema:         -2,
+								ZeroThreshold:  0.001,
+								ZeroCountInt:   2,
+								NegativeSpans:  []BucketSpan{{Offset: -3, Length: 1}},
+								NegativeDeltas: []int64{2},
+								PositiveSpans:  []BucketSpan{{Offset: 0, Length: 2}, {Offset: 1, Length: 2}},
+								PositiveDeltas: []int64{1, 1, -1, 0},
+								ResetHint:      ResetHintYes,
+								Timestamp:      1000,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "exemplars",
+			req: WriteRequest{
+				Timeseries: []TimeSeries{
+					{
+						Labels:  []Label{{Name: "__name__", Value: "latency_seconds_bucket"}, {Name: "le", Value: "0.5"}},
+						Samples: []Sample{{Value: 3, Timestamp: 1000}},
+						Exemplars: []Exemplar{
+							{Labels: []Label{{Name: "trace_id", Value: "abc123"}}, Value: 0.27, Timestamp: 990},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "metadata",
+			req: WriteRequest{
+				Metadata: []MetricMetadata{
+					{Type: MetricTypeCounter, MetricFamilyName: "http_requests_total", Help: "Total HTTP requests."},
+					{Type: MetricTypeGauge, MetricFamilyName: "temperature", Unit: "celsius"},
+				},
+			},
+		},
+		{
+			name: "float histogram with custom buckets",
+			req: WriteRequest{
+				Timeseries: []TimeSeries{
+					{
+						Labels: []Label{{Name: "__name__", Value: "latency_seconds"}},
+						Histograms: []Histogram{
+							{
+								IsFloat:        true,
+								Sum:            math.Inf(1),
+								Schema:         CustomBucketsSchema,
+								PositiveSpans:  []BucketSpan{{Offset: 0, Length: 3}},
+								PositiveCounts: []float64{0, 1.5, 2},
+								CustomValues:   []float64{0.1, 1},
+								ResetHint:      ResetHintGauge,
+								Timestamp:      1000,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		buf, err := c.req.Marshal()
+		if err != nil {
+			t.Errorf("case '%s'. failed to marshal: %s", c.name, err)
+			continue
+		}
+
+		var actual WriteRequest
+		if err := actual.Unmarshal(buf); err != nil {
+			t.Errorf("case '%s'. failed to unmarshal: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.req, actual) {
+			t.Errorf("case '%s'. expected %+v, got %+v", c.name, c.req, actual)
+		}
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	req := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "up"}},
+				Samples: []Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	}
+	buf, _ := req.Marshal()
+
+	var actual WriteRequest
+	if err := actual.Unmarshal(buf[:len(buf)-3]); err == nil {
+		t.Errorf("expected error for truncated request")
+	}
+}
@@ -0,0 +1,130 @@
+package remote
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	minSchema = -4
+	maxSchema = 8
+
+	// CustomBucketsSchema marks histograms with explicit bucket bounds in CustomValues
+	CustomBucketsSchema = -53
+)
+
+// Bucket is a single, non-cumulative bucket of a native histogram
+type Bucket struct {
+	Lower float64
+	Upper float64
+	Count float64
+}
+
+// Count returns the total number of observations
+func (h *Histogram) Count() float64 {
+	if h.IsFloat {
+		return h.CountFloat
+	}
+	return float64(h.CountInt)
+}
+
+// ZeroCount returns the number of observations in the zero bucket
+func (h *Histogram) ZeroCount() float64 {
+	if h.IsFloat {
+		return h.ZeroCountFloat
+	}
+	return float64(h.ZeroCountInt)
+}
+
+// Buckets returns the populated buckets of the histogram ordered by their
+// bounds, from the lowest negative bucket over the zero bucket to the
+// highest positive bucket.
+func (h *Histogram) Buckets() ([]Bucket, error) {
+	if h.Schema != CustomBucketsSchema && (h.Schema < minSchema || h.Schema > maxSchema) {
+		return nil, fmt.Errorf("unsupported native histogram schema %d", h.Schema)
+	}
+
+	negative, err := h.counts(h.NegativeSpans, h.NegativeDeltas, h.NegativeCounts)
+	if err != nil {
+		return nil, err
+	}
+	positive, err := h.counts(h.PositiveSpans, h.PositiveDeltas, h.PositiveCounts)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []Bucket
+	for i := len(negative) - 1; i >= 0; i-- {
+		lower, upper := h.bounds(negative[i].index)
+		buckets = append(buckets, Bucket{Lower: -upper, Upper: -lower, Count: negative[i].count})
+	}
+	if h.ZeroThreshold > 0 || h.ZeroCount() > 0 {
+		buckets = append(buckets, Bucket{Lower: -h.ZeroThreshold, Upper: h.ZeroThreshold, Count: h.ZeroCount()})
+	}
+	for _, b := range positive {
+		lower, upper := h.bounds(b.index)
+		buckets = append(buckets, Bucket{Lower: lower, Upper: upper, Count: b.count})
+	}
+	return buckets, nil
+}
+
+type indexedCount struct {
+	index int32
+	count float64
+}
+
+// counts resolves the spans into bucket indexes with absolute counts. Integer
+// histograms encode each count as delta to the previous bucket.
+func (h *Histogram) counts(spans []BucketSpan, deltas []int64, counts []float64) ([]indexedCount, error) {
+	var n int
+	for _, span := range spans {
+		n += int(span.Length)
+	}
+	if h.IsFloat && n != len(counts) || !h.IsFloat && n != len(deltas) {
+		return nil, fmt.Errorf("native histogram spans describe %d buckets, got %d counts", n, len(deltas)+len(counts))
+	}
+
+	result := make([]indexedCount, 0, n)
+	var index int32
+	var current int64
+	for _, span := range spans {
+		index += span.Offset
+		for j := uint32(0); j < span.Length; j++ {
+			var count float64
+			if h.IsFloat {
+				count = counts[len(result)]
+			} else {
+				current += deltas[len(result)]
+				count = float64(current)
+			}
+			result = append(result, indexedCount{index: index, count: count})
+			index++
+		}
+	}
+	return result, nil
+}
+
+// bounds returns the lower and upper bound of the bucket with the given index
+func (h *Histogram) bounds(index int32) (float64, float64) {
+	if h.Schema == CustomBucketsSchema {
+		lower, upper := math.Inf(-1), math.Inf(1)
+		if index > 0 && int(index) <= len(h.CustomValues) {
+			lower = h.CustomValues[index-1]
+		}
+		if index >= 0 && int(index) < len(h.CustomValues) {
+			upper = h.CustomValues[index]
+		}
+		return lower, upper
+	}
+	return bucketBound(h.Schema, index-1), bucketBound(h.Schema, index)
+}
+
+// bucketBound returns base^index with base = 2^(2^-schema)
+func bucketBound(schema int32, index int32) float64 {
+	if schema < 0 {
+		return math.Ldexp(1, int(index)<<uint(-schema))
+	}
+	frac := index & (1<<uint(schema) - 1)
+	exp := index >> uint(schema)
+	return math.Ldexp(math.Pow(2, float64(frac)/float64(int32(1)<<uint(schema))), int(exp))
+}
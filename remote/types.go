@@ -0,0 +1,118 @@
+package remote
+
+// WriteRequest is the prometheus.WriteRequest message sent by Prometheus
+// remote write. Unlike the vendored prompb types it also carries native
+// histograms. Remote write 2.0 requests are decoded into the same type.
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+// TimeSeries is a single series with its float samples, exemplars and native histograms
+type TimeSeries struct {
+	Labels     []Label
+	Samples    []Sample
+	Exemplars  []Exemplar
+	Histograms []Histogram
+
+	// CreatedTimestamp is only sent by remote write 2.0
+	CreatedTimestamp int64
+}
+
+// Label is a name/value pair of a series
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a float sample
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Exemplar is a sample with labels, such as a trace ID, which refer to an
+// example of what has been observed
+type Exemplar struct {
+	Labels    []Label
+	Value     float64
+	Timestamp int64
+}
+
+// MetricType is the type of a metric family
+type MetricType int32
+
+// Metric types as defined by the remote write protocol
+const (
+	MetricTypeUnknown MetricType = iota
+	MetricTypeCounter
+	MetricTypeGauge
+	MetricTypeHistogram
+	MetricTypeGaugeHistogram
+	MetricTypeSummary
+	MetricTypeInfo
+	MetricTypeStateset
+)
+
+var metricTypeNames = []string{
+	"unknown", "counter", "gauge", "histogram", "gaugehistogram", "summary", "info", "stateset",
+}
+
+func (t MetricType) String() string {
+	if t < 0 || int(t) >= len(metricTypeNames) {
+		return metricTypeNames[MetricTypeUnknown]
+	}
+	return metricTypeNames[t]
+}
+
+// MetricMetadata describes a metric family
+type MetricMetadata struct {
+	Type             MetricType
+	MetricFamilyName string
+	Help             string
+	Unit             string
+}
+
+// ResetHint tells if a native histogram follows a counter reset
+type ResetHint int32
+
+const (
+	// ResetHintUnknown means a counter reset has to be tested for explicitly.
+	ResetHintUnknown ResetHint = 0
+	// ResetHintYes marks the first histogram after a counter reset.
+	ResetHintYes ResetHint = 1
+	// ResetHintNo means there was no counter reset since the previous histogram.
+	ResetHintNo ResetHint = 2
+	// ResetHintGauge marks a gauge histogram, which has no counter resets.
+	ResetHintGauge ResetHint = 3
+)
+
+// Histogram is a native (exponential) histogram. Integer histograms carry
+// their bucket counts as deltas, float histograms as absolute counts.
+type Histogram struct {
+	CountInt       uint64
+	CountFloat     float64
+	Sum            float64
+	Schema         int32
+	ZeroThreshold  float64
+	ZeroCountInt   uint64
+	ZeroCountFloat float64
+	NegativeSpans  []BucketSpan
+	NegativeDeltas []int64
+	NegativeCounts []float64
+	PositiveSpans  []BucketSpan
+	PositiveDeltas []int64
+	PositiveCounts []float64
+	ResetHint      ResetHint
+	Timestamp      int64
+	CustomValues   []float64
+
+	// IsFloat is set when the histogram was encoded with float counts.
+	IsFloat bool
+}
+
+// BucketSpan is a run of consecutive buckets
+type BucketSpan struct {
+	Offset int32
+	Length uint32
+}
@@ -0,0 +1,276 @@
+package remote
+
+import (
+	"fmt"
+)
+
+// UnmarshalV2 decodes a protobuf encoded io.prometheus.write.v2.Request.
+// Labels referencing the symbol table are resolved, so the result is the
+// same as for the equivalent prometheus.WriteRequest.
+func (m *WriteRequest) UnmarshalV2(b []byte) error {
+	var symbols []string
+	var series [][]byte
+
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		switch {
+		case field == 4 && wire == wireBytes:
+			s, err := d.string()
+			if err != nil {
+				return err
+			}
+			symbols = append(symbols, s)
+		case field == 5 && wire == wireBytes:
+			// symbols may follow the series they are referenced by
+			b, err := d.bytes()
+			if err != nil {
+				return err
+			}
+			series = append(series, b)
+		default:
+			if err := d.skip(wire); err != nil {
+				return err
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, b := range series {
+		var ts TimeSeries
+		md, err := ts.unmarshalV2(b, symbols)
+		if err != nil {
+			return err
+		}
+		m.Timeseries = append(m.Timeseries, ts)
+
+		// v2 attaches metadata to every series, v1 once per metric family
+		if md != nil && !seen[md.MetricFamilyName] {
+			seen[md.MetricFamilyName] = true
+			m.Metadata = append(m.Metadata, *md)
+		}
+	}
+	return nil
+}
+
+// MarshalV2 encodes the WriteRequest as io.prometheus.write.v2.Request
+func (m *WriteRequest) MarshalV2() ([]byte, error) {
+	metadata := make(map[string]*MetricMetadata, len(m.Metadata))
+	for i := range m.Metadata {
+		metadata[m.Metadata[i].MetricFamilyName] = &m.Metadata[i]
+	}
+
+	table := newSymbolTable()
+	series := &encoder{}
+	for i := range m.Timeseries {
+		md := metadata[m.Timeseries[i].name()]
+		series.message(5, func(e *encoder) {
+			m.Timeseries[i].marshalV2(e, table, md)
+		})
+	}
+
+	e := &encoder{}
+	for _, s := range table.symbols {
+		e.bytes(4, []byte(s))
+	}
+	e.buf = append(e.buf, series.buf...)
+	return e.buf, nil
+}
+
+// unmarshalV2 decodes a series and returns its metadata, if it has any
+func (m *TimeSeries) unmarshalV2(b []byte, symbols []string) (*MetricMetadata, error) {
+	var refs []uint32
+	var mdRefs metadataRefs
+	var hasMetadata bool
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case field == 1:
+			err = d.packed(wire, func(d *decoder) error {
+				ref, err := d.varint()
+				refs = append(refs, uint32(ref))
+				return err
+			})
+		case field == 2 && wire == wireBytes:
+			var s Sample
+			if b, err = d.bytes(); err == nil {
+				err = s.unmarshal(b)
+			}
+			m.Samples = append(m.Samples, s)
+		case field == 3 && wire == wireBytes:
+			var h Histogram
+			if b, err = d.bytes(); err == nil {
+				err = h.unmarshal(b)
+			}
+			m.Histograms = append(m.Histograms, h)
+		case field == 4 && wire == wireBytes:
+			var ex Exemplar
+			if b, err = d.bytes(); err == nil {
+				err = ex.unmarshal(b, symbols)
+			}
+			m.Exemplars = append(m.Exemplars, ex)
+		case field == 5 && wire == wireBytes:
+			if b, err = d.bytes(); err == nil {
+				err = mdRefs.unmarshal(b)
+			}
+			hasMetadata = true
+		case field == 6 && wire == wireVarint:
+			var x uint64
+			x, err = d.varint()
+			m.CreatedTimestamp = int64(x)
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	labels, err := resolveLabels(refs, symbols)
+	if err != nil {
+		return nil, err
+	}
+	m.Labels = labels
+
+	if !hasMetadata || mdRefs == (metadataRefs{}) {
+		return nil, nil
+	}
+	if int(mdRefs.help) >= len(symbols) || int(mdRefs.unit) >= len(symbols) {
+		return nil, fmt.Errorf("metadata reference out of range of %d symbols", len(symbols))
+	}
+	return &MetricMetadata{
+		Type:             mdRefs.metricType,
+		MetricFamilyName: m.name(),
+		Help:             symbols[mdRefs.help],
+		Unit:             symbols[mdRefs.unit],
+	}, nil
+}
+
+func (m *TimeSeries) name() string {
+	for _, l := range m.Labels {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func (m *TimeSeries) marshalV2(e *encoder, table *symbolTable, md *MetricMetadata) {
+	e.labelRefs(1, m.Labels, table)
+	for i := range m.Samples {
+		e.message(2, m.Samples[i].marshal)
+	}
+	for i := range m.Histograms {
+		e.message(3, m.Histograms[i].marshal)
+	}
+	for i := range m.Exemplars {
+		e.message(4, func(e *encoder) {
+			m.Exemplars[i].marshal(e, table)
+		})
+	}
+	if md != nil {
+		e.message(5, func(inner *encoder) {
+			inner.uint(1, uint64(md.Type))
+			inner.uint(3, uint64(table.ref(md.Help)))
+			inner.uint(4, uint64(table.ref(md.Unit)))
+		})
+	}
+	e.int(6, m.CreatedTimestamp)
+}
+
+// metadataRefs is the io.prometheus.write.v2.Metadata message
+type metadataRefs struct {
+	metricType MetricType
+	help       uint32
+	unit       uint32
+}
+
+func (m *metadataRefs) unmarshal(b []byte) error {
+	d := &decoder{buf: b}
+	for !d.done() {
+		field, wire, err := d.key()
+		if err != nil {
+			return err
+		}
+		var x uint64
+		switch {
+		case field == 1 && wire == wireVarint:
+			x, err = d.varint()
+			m.metricType = MetricType(x)
+		case field == 3 && wire == wireVarint:
+			x, err = d.varint()
+			m.help = uint32(x)
+		case field == 4 && wire == wireVarint:
+			x, err = d.varint()
+			m.unit = uint32(x)
+		default:
+			err = d.skip(wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelRefs writes the labels as packed pairs of symbol references
+func (e *encoder) labelRefs(field int, labels []Label, table *symbolTable) {
+	if len(labels) == 0 {
+		return
+	}
+	e.message(field, func(inner *encoder) {
+		for _, l := range labels {
+			inner.varint(uint64(table.ref(l.Name)))
+			inner.varint(uint64(table.ref(l.Value)))
+		}
+	})
+}
+
+func resolveLabels(refs []uint32, symbols []string) ([]Label, error) {
+	if len(refs)%2 != 0 {
+		return nil, fmt.Errorf("odd number of label references: %d", len(refs))
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	labels := make([]Label, 0, len(refs)/2)
+	for i := 0; i < len(refs); i += 2 {
+		if int(refs[i]) >= len(symbols) || int(refs[i+1]) >= len(symbols) {
+			return nil, fmt.Errorf("label reference out of range of %d symbols", len(symbols))
+		}
+		labels = append(labels, Label{Name: symbols[refs[i]], Value: symbols[refs[i+1]]})
+	}
+	return labels, nil
+}
+
+// symbolTable deduplicates the strings of a v2 request. The empty string is
+// always the first symbol.
+type symbolTable struct {
+	symbols []string
+	refs    map[string]uint32
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		symbols: []string{""},
+		refs:    map[string]uint32{"": 0},
+	}
+}
+
+func (t *symbolTable) ref(s string) uint32 {
+	if ref, ok := t.refs[s]; ok {
+		return ref
+	}
+	ref := uint32(len(t.symbols))
+	t.symbols = append(t.symbols, s)
+	t.refs[s] = ref
+	return ref
+}
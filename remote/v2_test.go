@@ -0,0 +1,90 @@
+package remote
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalV2RoundTrip(t *testing.T) {
+	req := WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels: []Label{
+					{Name: "__name__", Value: "http_requests_total"},
+					{Name: "job", Value: "api"},
+				},
+				Samples:          []Sample{{Value: 10, Timestamp: 1000}},
+				CreatedTimestamp: 500,
+				Exemplars: []Exemplar{
+					{Labels: []Label{{Name: "trace_id", Value: "abc123"}}, Value: 1, Timestamp: 990},
+					{Value: 2, Timestamp: 995},
+				},
+			},
+			{
+				Labels: []Label{
+					{Name: "__name__", Value: "http_request_duration_seconds"},
+					{Name: "job", Value: "api"},
+					{Name: "empty", Value: ""},
+				},
+				Histograms: []Histogram{
+					{
+						CountInt:       3,
+						PositiveSpans:  []BucketSpan{{Offset: 0, Length: 2}},
+						PositiveDeltas: []int64{1, 1},
+						Timestamp:      1000,
+					},
+				},
+			},
+		},
+		Metadata: []MetricMetadata{
+			{Type: MetricTypeCounter, MetricFamilyName: "http_requests_total", Help: "Total HTTP requests."},
+		},
+	}
+
+	buf, err := req.MarshalV2()
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+
+	var actual WriteRequest
+	if err := actual.UnmarshalV2(buf); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if !reflect.DeepEqual(req, actual) {
+		t.Errorf("expected %+v, got %+v", req, actual)
+	}
+}
+
+func TestUnmarshalV2InvalidLabelRefs(t *testing.T) {
+	cases := []struct {
+		name string
+		refs []uint64
+	}{
+		{
+			name: "reference out of range",
+			refs: []uint64{1, 2},
+		},
+		{
+			name: "odd number of references",
+			refs: []uint64{1},
+		},
+	}
+
+	for _, c := range cases {
+		e := &encoder{}
+		e.bytes(4, []byte(""))
+		e.bytes(4, []byte("__name__"))
+		e.message(5, func(series *encoder) {
+			series.message(1, func(refs *encoder) {
+				for _, ref := range c.refs {
+					refs.varint(ref)
+				}
+			})
+		})
+
+		var actual WriteRequest
+		if err := actual.UnmarshalV2(e.buf); err == nil {
+			t.Errorf("case '%s'. expected error", c.name)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package remote
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errVarintOverflow = fmt.Errorf("proto: invalid varint")
+
+// decoder reads protobuf wire format fields from a buffer
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) done() bool {
+	return d.pos >= len(d.buf)
+}
+
+func (d *decoder) varint() (uint64, error) {
+	if d.done() {
+		return 0, io.ErrUnexpectedEOF
+	}
+	x, n := proto.DecodeVarint(d.buf[d.pos:])
+	if n == 0 {
+		return 0, errVarintOverflow
+	}
+	d.pos += n
+	return x, nil
+}
+
+func (d *decoder) key() (field int, wire int, err error) {
+	k, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	field, wire = int(k>>3), int(k&7)
+	if field <= 0 {
+		return 0, 0, fmt.Errorf("proto: illegal field number %d", field)
+	}
+	return field, wire, nil
+}
+
+func (d *decoder) fixed64() (uint64, error) {
+	if d.pos+8 > len(d.buf) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	x := binary.LittleEndian.Uint64(d.buf[d.pos:])
+	d.pos += 8
+	return x, nil
+}
+
+func (d *decoder) double() (float64, error) {
+	x, err := d.fixed64()
+	return math.Float64frombits(x), err
+}
+
+func (d *decoder) zigzag() (int64, error) {
+	x, err := d.varint()
+	return int64(x>>1) ^ -int64(x&1), err
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	l, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(len(d.buf)-d.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	end := d.pos + int(l)
+	b := d.buf[d.pos:end]
+	d.pos = end
+	return b, nil
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+// skip discards a field this package does not know about
+func (d *decoder) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = d.varint()
+	case wireFixed64:
+		_, err = d.fixed64()
+	case wireBytes:
+		_, err = d.bytes()
+	case wireFixed32:
+		if d.pos+4 > len(d.buf) {
+			return io.ErrUnexpectedEOF
+		}
+		d.pos += 4
+	default:
+		err = fmt.Errorf("proto: unsupported wire type %d", wire)
+	}
+	return err
+}
+
+// packed calls fn for every element of a repeated scalar field, accepting
+// both the packed and the unpacked encoding.
+func (d *decoder) packed(wire int, fn func(*decoder) error) error {
+	if wire != wireBytes {
+		return fn(d)
+	}
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	inner := &decoder{buf: b}
+	for !inner.done() {
+		if err := fn(inner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encoder appends protobuf wire format fields to a buffer
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) key(field int, wire int) {
+	e.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (e *encoder) varint(x uint64) {
+	e.buf = append(e.buf, proto.EncodeVarint(x)...)
+}
+
+func (e *encoder) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	e.key(field, wireVarint)
+	e.varint(x)
+}
+
+func (e *encoder) int(field int, x int64) {
+	e.uint(field, uint64(x))
+}
+
+func (e *encoder) sint(field int, x int64) {
+	e.uint(field, uint64(x<<1)^uint64(x>>63))
+}
+
+func (e *encoder) double(field int, x float64) {
+	if x == 0 && !math.Signbit(x) {
+		return
+	}
+	e.oneofDouble(field, x)
+}
+
+// oneofDouble writes x even if it is zero, as set oneof members always are
+func (e *encoder) oneofDouble(field int, x float64) {
+	e.key(field, wireFixed64)
+	e.fixed64(math.Float64bits(x))
+}
+
+func (e *encoder) fixed64(x uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], x)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.key(field, wireBytes)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.bytes(field, []byte(s))
+}
+
+func (e *encoder) message(field int, fn func(*encoder)) {
+	inner := &encoder{}
+	fn(inner)
+	e.bytes(field, inner.buf)
+}
+
+func (e *encoder) packedSint(field int, xs []int64) {
+	if len(xs) == 0 {
+		return
+	}
+	e.message(field, func(inner *encoder) {
+		for _, x := range xs {
+			inner.varint(uint64(x<<1) ^ uint64(x>>63))
+		}
+	})
+}
+
+func (e *encoder) packedDouble(field int, xs []float64) {
+	if len(xs) == 0 {
+		return
+	}
+	e.message(field, func(inner *encoder) {
+		for _, x := range xs {
+			inner.fixed64(math.Float64bits(x))
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ServeAnalytics writes the estimated series of the pre and post relabel
+// views as JSON. The view parameter selects one of them, the n parameter
+// overrides how many metric names, label names and label values are listed.
+func (server *Server) ServeAnalytics(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n <= 0 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	analytics := server.client().Analytics()
+	if analytics == nil {
+		http.Error(w, "analytics are not enabled", http.StatusNotFound)
+		return
+	}
+
+	reports, err := analytics.Report(r.URL.Query().Get("view"), n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		logrus.Errorf("failed writing analytics report. error: %s", err)
+	}
+}
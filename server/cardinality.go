@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// ServeCardinality writes the active series and the metrics with the most
+// series as JSON. The n parameter overrides how many metrics are listed.
+func (server *Server) ServeCardinality(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n <= 0 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report := server.client().CardinalityReport(n)
+	if report == nil {
+		http.Error(w, "cardinality limits are not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logrus.Errorf("failed writing cardinality report. error: %s", err)
+	}
+}
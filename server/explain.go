@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+)
+
+// explainRequest is the JSON form of the series to explain
+type explainRequest struct {
+	Labels    map[string]string `json:"labels"`
+	Value     *float64          `json:"value"`
+	Timestamp *int64            `json:"timestamp"`
+}
+
+// ServeExplain writes the series after every metric relabel rule, the rules
+// which dropped or changed it and the datapoints which would be written for
+// it as JSON. The series is read from the series parameter or the body in
+// text form, like `up{job="api"} 1 1500000000000`, or from a JSON body with
+// labels, value and timestamp. The value defaults to 1, the timestamp to now.
+func (server *Server) ServeExplain(w http.ResponseWriter, r *http.Request) {
+	sample, err := explainSample(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(server.client().Explain(sample)); err != nil {
+		logrus.Errorf("failed writing explanation. error: %s", err)
+	}
+}
+
+func explainSample(r *http.Request) (*model.Sample, error) {
+	if series := r.URL.Query().Get("series"); series != "" {
+		return relabel.ParseSeries(series)
+	}
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("series parameter or POST body required")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return relabel.ParseSeries(string(body))
+	}
+
+	var req explainRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Labels) == 0 {
+		return nil, fmt.Errorf("series without labels")
+	}
+	sample := &model.Sample{Metric: make(model.Metric, len(req.Labels)), Value: 1, Timestamp: model.Now()}
+	for name, value := range req.Labels {
+		sample.Metric[model.LabelName(name)] = model.LabelValue(value)
+	}
+	if req.Value != nil {
+		sample.Value = model.SampleValue(*req.Value)
+	}
+	if req.Timestamp != nil {
+		sample.Timestamp = model.Time(*req.Timestamp)
+	}
+	return sample, nil
+}
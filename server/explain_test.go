@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/kairosdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeExplain(t *testing.T) {
+	u, _ := url.Parse("http://localhost:8080")
+	cfg := &config.Config{
+		KairosdbURL: config.URL{URL: u},
+		Timeout:     time.Second,
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("pod"), Action: config.RelabelLabelDrop},
+			{SourceLabels: model.LabelNames{"job"}, Regex: config.MustNewRegexp("db"), Action: config.RelabelDrop},
+		},
+		TTL: config.TTLs{Default: model.Duration(time.Hour)},
+	}
+	server := &Server{Client: *kairosdb.NewClient(cfg)}
+
+	cases := []struct {
+		name        string
+		request     *http.Request
+		contentType string
+		status      int
+		expected    string
+	}{
+		{
+			name:    "changed series as parameter",
+			request: httptest.NewRequest("GET", "/api/v1/explain?series="+url.QueryEscape(`up{job="api",pod="a"} 1 1000`), nil),
+			status:  http.StatusOK,
+			expected: `{
+				"series": {"__name__": "up", "job": "api", "pod": "a"},
+				"steps": [
+					{"rule": 0, "action": "labeldrop", "matched": true, "result": "changed", "series": {"__name__": "up", "job": "api"}},
+					{"rule": 1, "action": "drop", "matched": false, "result": "unchanged", "series": {"__name__": "up", "job": "api"}}
+				],
+				"changed_by": [0],
+				"datapoints": [{"name": "up", "timestamp": 1000, "value": 1, "tags": {"job": "api"}, "ttl": 3600}]
+			}`,
+		},
+		{
+			name:        "dropped series as JSON",
+			request:     httptest.NewRequest("POST", "/api/v1/explain", strings.NewReader(`{"labels": {"__name__": "up", "job": "db"}, "value": 0, "timestamp": 1000}`)),
+			contentType: "application/json",
+			status:      http.StatusOK,
+			expected: `{
+				"series": {"__name__": "up", "job": "db"},
+				"steps": [
+					{"rule": 0, "action": "labeldrop", "matched": false, "result": "unchanged", "series": {"__name__": "up", "job": "db"}},
+					{"rule": 1, "action": "drop", "matched": true, "result": "dropped"}
+				],
+				"dropped_by": 1,
+				"dropped_in": "metric_relabel_configs",
+				"changed_by": [],
+				"datapoints": []
+			}`,
+		},
+		{
+			name:    "series as text body",
+			request: httptest.NewRequest("POST", "/api/v1/explain", strings.NewReader(`up{job="api"} 2 1000`)),
+			status:  http.StatusOK,
+			expected: `{
+				"series": {"__name__": "up", "job": "api"},
+				"steps": [
+					{"rule": 0, "action": "labeldrop", "matched": false, "result": "unchanged", "series": {"__name__": "up", "job": "api"}},
+					{"rule": 1, "action": "drop", "matched": false, "result": "unchanged", "series": {"__name__": "up", "job": "api"}}
+				],
+				"changed_by": [],
+				"datapoints": [{"name": "up", "timestamp": 1000, "value": 2, "tags": {"job": "api"}, "ttl": 3600}]
+			}`,
+		},
+		{
+			name:    "NaN dropped by special values",
+			request: httptest.NewRequest("POST", "/api/v1/explain", strings.NewReader(`up{job="api"} NaN 1000`)),
+			status:  http.StatusOK,
+			expected: `{
+				"series": {"__name__": "up", "job": "api"},
+				"steps": [
+					{"rule": 0, "action": "labeldrop", "matched": false, "result": "unchanged", "series": {"__name__": "up", "job": "api"}},
+					{"rule": 1, "action": "drop", "matched": false, "result": "unchanged", "series": {"__name__": "up", "job": "api"}}
+				],
+				"dropped_in": "special_values",
+				"changed_by": [],
+				"datapoints": []
+			}`,
+		},
+		{
+			name:    "without series",
+			request: httptest.NewRequest("GET", "/api/v1/explain", nil),
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		if c.contentType != "" {
+			c.request.Header.Set("Content-Type", c.contentType)
+		}
+		w := httptest.NewRecorder()
+		server.ServeExplain(w, c.request)
+		assert.Equal(t, c.status, w.Code, c.name)
+		if c.expected != "" {
+			assert.JSONEq(t, c.expected, w.Body.String(), c.name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+var relabelStatusTemplate = template.Must(template.New("relabel").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Metric relabel configs</title></head>
+<body>
+<h1>Metric relabel configs</h1>
+<table border="1" cellpadding="4">
+<tr><th>Rule</th><th>Config</th><th>Matched</th><th>Dropped</th><th>Changed</th><th>Unchanged</th><th>Time</th></tr>
+{{range .}}<tr>
+<td>{{.Index}}</td>
+<td><pre>{{.YAML}}</pre></td>
+<td>{{.Stats.Matched}}</td>
+<td>{{.Stats.Dropped}}</td>
+<td>{{.Stats.Changed}}</td>
+<td>{{.Stats.Unchanged}}</td>
+<td>{{.Stats.Duration}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// relabelRuleStatus is a row of the relabel status page
+type relabelRuleStatus struct {
+	Index int
+	YAML  string
+	Stats relabel.RuleStats
+}
+
+// ServeRelabelStatus writes a page listing every metric relabel config as
+// YAML next to the number of distinct series it matched, dropped, changed or
+// left unchanged and the time spent applying it since the config was loaded
+func (server *Server) ServeRelabelStatus(w http.ResponseWriter, r *http.Request) {
+	client := server.client()
+	cfgs := client.MetricRelabelConfigs()
+	stats := client.RelabelStats()
+
+	rules := make([]relabelRuleStatus, len(cfgs))
+	for i, cfg := range cfgs {
+		rules[i] = relabelRuleStatus{Index: i, YAML: ruleYAML(cfg), Stats: stats[i]}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := relabelStatusTemplate.Execute(w, rules); err != nil {
+		logrus.Errorf("failed writing relabel status. error: %s", err)
+	}
+}
+
+func ruleYAML(cfg *config.RelabelConfig) string {
+	buf, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
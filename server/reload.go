@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/ha"
+)
+
+var (
+	lastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful.",
+		},
+	)
+	lastReloadSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload.",
+		},
+	)
+)
+
+// Reloader reloads the config of the server. The config is loaded and
+// validated before it is swapped in, the current config is kept if it is
+// invalid.
+type Reloader struct {
+	mtx    sync.Mutex
+	server *Server
+	cfg    *config.Config
+	load   func() (*config.Config, error)
+	now    func() time.Time
+}
+
+// NewReloader returns a Reloader of the server running with the config,
+// which loads the config with load
+func NewReloader(server *Server, cfg *config.Config, load func() (*config.Config, error)) *Reloader {
+	r := &Reloader{
+		server: server,
+		cfg:    cfg,
+		load:   load,
+		now:    time.Now,
+	}
+	lastReloadSuccessful.Set(1)
+	lastReloadSuccessTimestamp.Set(float64(r.now().Unix()))
+	return r
+}
+
+// Reload loads the config and swaps it in if it is valid
+func (r *Reloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		lastReloadSuccessful.Set(0)
+		logrus.Errorf("failed reloading config, keeping the current config: %s", err)
+		return err
+	}
+
+	if cfg.Server.Port != r.cfg.Server.Port {
+		logrus.Warnf("server port changed to %s, it is applied on restart", cfg.Server.Port)
+	}
+
+	client, tracker := r.server.current()
+	switch {
+	case !cfg.HADedup.Enabled:
+		tracker = nil
+	case tracker == nil || !config.Equal(r.cfg.HADedup, cfg.HADedup):
+		tracker = ha.NewTracker(cfg.HADedup)
+	}
+	r.server.Reload(*client.Reload(cfg), tracker)
+	r.cfg = cfg
+
+	lastReloadSuccessful.Set(1)
+	lastReloadSuccessTimestamp.Set(float64(r.now().Unix()))
+	logrus.Info("reloaded config")
+	return nil
+}
+
+// ServeReload reloads the config on POST requests
+func (r *Reloader) ServeReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.Reload(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// WatchSignals reloads the config on SIGHUP
+func (r *Reloader) WatchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			r.Reload()
+		}
+	}()
+}
+
+// WatchFile reloads the config when the modification time or the size of the
+// file changed, checking every interval
+func (r *Reloader) WatchFile(file string, interval time.Duration) {
+	last, _ := os.Stat(file)
+	go func() {
+		for range time.Tick(interval) {
+			info, err := os.Stat(file)
+			if err != nil {
+				logrus.Warnf("failed watching config file: %s", err)
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			r.Reload()
+		}
+	}()
+}
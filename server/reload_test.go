@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/kairosdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := gauge.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestReloader(t *testing.T) {
+	dropRule := &config.RelabelConfig{
+		SourceLabels: model.LabelNames{model.MetricNameLabel},
+		Regex:        config.MustNewRegexp("^up$"),
+		Action:       config.RelabelDrop,
+	}
+	cfg := &config.Config{Timeout: time.Second}
+	reloaded := &config.Config{
+		Timeout:              time.Second,
+		MetricRelabelConfigs: []*config.RelabelConfig{dropRule},
+		HADedup:              config.HADedup{Enabled: true, ClusterLabel: "cluster", ReplicaLabel: "replica"},
+	}
+
+	server := &Server{Client: *kairosdb.NewClient(cfg)}
+	var loadErr error
+	reloader := NewReloader(server, cfg, func() (*config.Config, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return reloaded, nil
+	})
+	reloader.now = func() time.Time { return time.Unix(1000, 0) }
+
+	rec := httptest.NewRecorder()
+	reloader.ServeReload(rec, httptest.NewRequest("GET", "/-/reload", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	reloader.ServeReload(rec, httptest.NewRequest("POST", "/-/reload", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	client, tracker := server.current()
+	assert.Equal(t, []*config.RelabelConfig{dropRule}, client.MetricRelabelConfigs())
+	assert.NotNil(t, tracker)
+	assert.Equal(t, 1.0, gaugeValue(t, lastReloadSuccessful))
+	assert.Equal(t, 1000.0, gaugeValue(t, lastReloadSuccessTimestamp))
+
+	// an invalid config keeps the loaded one
+	loadErr = errors.New("kairosdb-url is mandatory")
+	reloader.now = func() time.Time { return time.Unix(2000, 0) }
+	rec = httptest.NewRecorder()
+	reloader.ServeReload(rec, httptest.NewRequest("POST", "/-/reload", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "failed to reload config: kairosdb-url is mandatory\n", rec.Body.String())
+	client, reloadedTracker := server.current()
+	assert.Equal(t, []*config.RelabelConfig{dropRule}, client.MetricRelabelConfigs())
+	assert.True(t, tracker == reloadedTracker)
+	assert.Equal(t, 0.0, gaugeValue(t, lastReloadSuccessful))
+	assert.Equal(t, 1000.0, gaugeValue(t, lastReloadSuccessTimestamp))
+}
+
+func TestReloaderKeepsHATracker(t *testing.T) {
+	ha := config.HADedup{Enabled: true, ClusterLabel: "cluster", ReplicaLabel: "replica"}
+	cfg := &config.Config{Timeout: time.Second, HADedup: ha}
+	reloaded := &config.Config{Timeout: 2 * time.Second, HADedup: ha}
+
+	server := &Server{Client: *kairosdb.NewClient(cfg)}
+	reloader := NewReloader(server, cfg, func() (*config.Config, error) { return reloaded, nil })
+	assert.NoError(t, reloader.Reload())
+	_, tracker := server.current()
+	assert.NotNil(t, tracker)
+
+	reloaded = &config.Config{Timeout: 3 * time.Second, HADedup: ha}
+	assert.NoError(t, reloader.Reload())
+	_, kept := server.current()
+	assert.True(t, tracker == kept, "tracker must be kept unless ha-dedup changed")
+
+	changed := ha
+	changed.ReplicaLabel = "prometheus_replica"
+	reloaded = &config.Config{Timeout: 3 * time.Second, HADedup: changed}
+	assert.NoError(t, reloader.Reload())
+	_, replaced := server.current()
+	assert.False(t, tracker == replaced, "tracker must be replaced when ha-dedup changed")
+}
@@ -1,15 +1,19 @@
 package server
 
 import (
+	"fmt"
 	"github.com/Sirupsen/logrus"
-	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
-	"github.com/prometheus/prometheus/prompb"
+	"github.com/proofpoint/prom-to-kairosdb/ha"
 	"github.com/proofpoint/prom-to-kairosdb/kairosdb"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"sync"
 )
 
 var (
@@ -19,17 +23,90 @@ var (
 			Help: "Total number of received samples.",
 		},
 	)
+	receivedHistograms = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "received_histograms_total",
+			Help: "Total number of received native histograms.",
+		},
+	)
+	receivedExemplars = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "received_exemplars_total",
+			Help: "Total number of received exemplars.",
+		},
+	)
+	receivedMetadata = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "received_metadata_total",
+			Help: "Total number of received metric metadata.",
+		},
+	)
 )
 
 func RegisterPrometheusMetrics() {
 	prometheus.MustRegister(receivedSamples)
+	prometheus.MustRegister(receivedHistograms)
+	prometheus.MustRegister(receivedExemplars)
+	prometheus.MustRegister(receivedMetadata)
+	prometheus.MustRegister(lastReloadSuccessful)
+	prometheus.MustRegister(lastReloadSuccessTimestamp)
 }
 
+const (
+	protoMsgV1 = "prometheus.WriteRequest"
+	protoMsgV2 = "io.prometheus.write.v2.Request"
+
+	contentTypeProtobuf = "application/x-protobuf"
+
+	samplesWrittenHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	histogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	exemplarsWrittenHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
 type Server struct {
+	// mtx guards Client and HATracker, which are swapped on config reload
+	mtx    sync.RWMutex
 	Client kairosdb.Client
+	// HATracker deduplicates Prometheus HA pairs, if set
+	HATracker *ha.Tracker
+}
+
+// Reload swaps the client and the HA tracker. Requests being served keep
+// the ones they started with.
+func (server *Server) Reload(client kairosdb.Client, tracker *ha.Tracker) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+
+	server.Client = client
+	server.HATracker = tracker
+}
+
+func (server *Server) client() *kairosdb.Client {
+	client, _ := server.current()
+	return &client
+}
+
+// Flush flushes the processors of the loaded config
+func (server *Server) Flush() error {
+	return server.client().Flush()
+}
+
+// current returns the client and the HA tracker of the loaded config
+func (server *Server) current() (kairosdb.Client, *ha.Tracker) {
+	server.mtx.RLock()
+	defer server.mtx.RUnlock()
+
+	return server.Client, server.HATracker
 }
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	protoMsg, err := protoMessage(r.Header.Get("Content-Type"))
+	if err != nil {
+		logrus.Errorf("%s", err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
 	compressed, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		logrus.Errorf("%s", err)
@@ -44,19 +121,93 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req prompb.WriteRequest
-	if err := proto.Unmarshal(reqBuf, &req); err != nil {
+	var req remote.WriteRequest
+	if protoMsg == protoMsgV2 {
+		err = req.UnmarshalV2(reqBuf)
+	} else {
+		err = req.Unmarshal(reqBuf)
+	}
+	if err != nil {
 		logrus.Errorf("%s", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	client, tracker := server.current()
+
+	// like Cortex, requests of replicas which are not elected are accepted
+	// without being written, so Prometheus does not retry them
+	if tracker != nil && !tracker.Dedup(&req) {
+		setWrittenHeaders(w, protoMsg, 0, 0, 0)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// metadata goes first, samples are tagged with the metric type it carries
+	if len(req.Metadata) > 0 {
+		receivedMetadata.Add(float64(len(req.Metadata)))
+		client.SendMetadata(req.Metadata)
+	}
+
+	// errors are logged by the client, the headers tell how much was written
+	var samplesWritten, histogramsWritten, exemplarsWritten int
 	samples := protoToSamples(&req)
 	receivedSamples.Add(float64(len(samples)))
-	server.Client.Send(samples)
+	samplesWritten, _ = client.Send(samples)
+
+	histograms := protoToHistograms(&req)
+	if len(histograms) > 0 {
+		receivedHistograms.Add(float64(len(histograms)))
+		histogramsWritten, _ = client.SendHistograms(histograms)
+	}
+
+	exemplars := protoToExemplars(&req)
+	receivedExemplars.Add(float64(len(exemplars)))
+	if len(exemplars) > 0 && client.ExemplarsEnabled() {
+		exemplarsWritten, _ = client.SendExemplars(exemplars)
+	}
+
+	setWrittenHeaders(w, protoMsg, samplesWritten, histogramsWritten, exemplarsWritten)
 }
 
-func protoToSamples(req *prompb.WriteRequest) model.Samples {
+// setWrittenHeaders tells remote write 2.0 senders how much was written, so
+// they don't take a response without the headers as written by a receiver
+// predating 2.0
+func setWrittenHeaders(w http.ResponseWriter, protoMsg string, samples, histograms, exemplars int) {
+	if protoMsg != protoMsgV2 {
+		return
+	}
+	w.Header().Set(samplesWrittenHeader, strconv.Itoa(samples))
+	w.Header().Set(histogramsWrittenHeader, strconv.Itoa(histograms))
+	w.Header().Set(exemplarsWrittenHeader, strconv.Itoa(exemplars))
+}
+
+// protoMessage returns the remote write protobuf message announced by the
+// content type. Senders which predate remote write 2.0 don't set it.
+func protoMessage(contentType string) (string, error) {
+	if contentType == "" {
+		return protoMsgV1, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+	if mediaType != contentTypeProtobuf {
+		return "", fmt.Errorf("unsupported content type %q", contentType)
+	}
+
+	switch params["proto"] {
+	case "", protoMsgV1:
+		return protoMsgV1, nil
+	case protoMsgV2:
+		return protoMsgV2, nil
+	default:
+		return "", fmt.Errorf("unsupported remote write protobuf message %q", params["proto"])
+	}
+}
+
+func protoToSamples(req *remote.WriteRequest) model.Samples {
 	var samples model.Samples
 	for _, ts := range req.Timeseries {
 		metric := make(model.Metric, len(ts.Labels))
@@ -74,3 +225,54 @@ func protoToSamples(req *prompb.WriteRequest) model.Samples {
 	}
 	return samples
 }
+
+func protoToHistograms(req *remote.WriteRequest) []*kairosdb.HistogramSample {
+	var histograms []*kairosdb.HistogramSample
+	for _, ts := range req.Timeseries {
+		if len(ts.Histograms) == 0 {
+			continue
+		}
+
+		metric := make(model.Metric, len(ts.Labels))
+		for _, l := range ts.Labels {
+			metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+		}
+
+		for i := range ts.Histograms {
+			histograms = append(histograms, &kairosdb.HistogramSample{
+				Metric:    metric,
+				Timestamp: model.Time(ts.Histograms[i].Timestamp),
+				Histogram: &ts.Histograms[i],
+			})
+		}
+	}
+	return histograms
+}
+
+func protoToExemplars(req *remote.WriteRequest) []*kairosdb.ExemplarSample {
+	var exemplars []*kairosdb.ExemplarSample
+	for _, ts := range req.Timeseries {
+		if len(ts.Exemplars) == 0 {
+			continue
+		}
+
+		metric := make(model.Metric, len(ts.Labels))
+		for _, l := range ts.Labels {
+			metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+		}
+
+		for _, e := range ts.Exemplars {
+			labels := make(model.LabelSet, len(e.Labels))
+			for _, l := range e.Labels {
+				labels[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+			}
+			exemplars = append(exemplars, &kairosdb.ExemplarSample{
+				Metric:    metric,
+				Labels:    labels,
+				Value:     model.SampleValue(e.Value),
+				Timestamp: model.Time(e.Timestamp),
+			})
+		}
+	}
+	return exemplars
+}
@@ -0,0 +1,429 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/proofpoint/prom-to-kairosdb/config"
+	"github.com/proofpoint/prom-to-kairosdb/ha"
+	"github.com/proofpoint/prom-to-kairosdb/kairosdb"
+	"github.com/proofpoint/prom-to-kairosdb/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+var testRequest = remote.WriteRequest{
+	Timeseries: []remote.TimeSeries{
+		{
+			Labels: []remote.Label{
+				{Name: "__name__", Value: "http_requests_total"},
+				{Name: "job", Value: "api"},
+				{Name: "code", Value: "200"},
+			},
+			Samples: []remote.Sample{
+				{Value: 10, Timestamp: 1000},
+				{Value: 12, Timestamp: 2000},
+			},
+			Exemplars: []remote.Exemplar{
+				{Labels: []remote.Label{{Name: "trace_id", Value: "abc123"}}, Value: 1, Timestamp: 1500},
+			},
+		},
+		{
+			Labels: []remote.Label{
+				{Name: "__name__", Value: "http_request_duration_seconds"},
+				{Name: "job", Value: "api"},
+			},
+			Histograms: []remote.Histogram{
+				{
+					CountInt:       3,
+					Sum:            2.5,
+					PositiveSpans:  []remote.BucketSpan{{Offset: 0, Length: 2}},
+					PositiveDeltas: []int64{1, 1},
+					Timestamp:      1000,
+				},
+			},
+		},
+	},
+}
+
+// kairosDBRecorder is a fake KairosDB which records the bodies written to it
+type kairosDBRecorder struct {
+	*httptest.Server
+	bodies []string
+}
+
+func newKairosDBRecorder() *kairosDBRecorder {
+	k := &kairosDBRecorder{}
+	k.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		k.bodies = append(k.bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return k
+}
+
+func (k *kairosDBRecorder) server(t *testing.T) *Server {
+	return k.serverWithExemplars(t, false)
+}
+
+func (k *kairosDBRecorder) serverWithExemplars(t *testing.T, exemplars bool) *Server {
+	u, err := url.Parse(k.URL)
+	if err != nil {
+		t.Fatalf("failed to parse url: %s", err)
+	}
+
+	cfg := &config.Config{
+		KairosdbURL:      config.URL{URL: u},
+		Timeout:          time.Second,
+		NativeHistograms: config.NativeHistograms{Mode: config.HistogramModeSeries},
+		Exemplars:        config.Exemplars{Enabled: exemplars, MetricSuffix: "_exemplar"},
+	}
+	return &Server{Client: *kairosdb.NewClient(cfg)}
+}
+
+func post(server *Server, contentType string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/write", bytes.NewReader(snappy.Encode(nil, body)))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeHTTPProtocolVersions(t *testing.T) {
+	v1, err := testRequest.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal v1 request: %s", err)
+	}
+	v2, err := testRequest.MarshalV2()
+	if err != nil {
+		t.Fatalf("failed to marshal v2 request: %s", err)
+	}
+
+	cases := []struct {
+		name        string
+		contentType string
+		body        []byte
+		status      int
+		headers     map[string]string
+	}{
+		{
+			name:   "v1 without content type",
+			body:   v1,
+			status: http.StatusOK,
+		},
+		{
+			name:        "v1 without proto parameter",
+			contentType: "application/x-protobuf",
+			body:        v1,
+			status:      http.StatusOK,
+		},
+		{
+			name:        "v1",
+			contentType: "application/x-protobuf;proto=prometheus.WriteRequest",
+			body:        v1,
+			status:      http.StatusOK,
+		},
+		{
+			name:        "v2",
+			contentType: "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			body:        v2,
+			status:      http.StatusOK,
+			headers: map[string]string{
+				samplesWrittenHeader:    "2",
+				histogramsWrittenHeader: "1",
+				exemplarsWrittenHeader:  "0",
+			},
+		},
+		{
+			name:        "unknown proto message",
+			contentType: "application/x-protobuf;proto=io.prometheus.write.v3.Request",
+			body:        v2,
+			status:      http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "unknown content type",
+			contentType: "application/json",
+			body:        v1,
+			status:      http.StatusUnsupportedMediaType,
+		},
+	}
+
+	var expected []string
+	for _, c := range cases {
+		kairos := newKairosDBRecorder()
+		w := post(kairos.server(t), c.contentType, c.body)
+		kairos.Close()
+
+		assert.Equal(t, c.status, w.Code, c.name)
+		for header, value := range c.headers {
+			assert.Equal(t, value, w.Header().Get(header), c.name)
+		}
+		if c.headers == nil {
+			assert.Empty(t, w.Header().Get(samplesWrittenHeader), c.name)
+		}
+
+		if c.status != http.StatusOK {
+			assert.Empty(t, kairos.bodies, c.name)
+			continue
+		}
+		if expected == nil {
+			expected = kairos.bodies
+			assert.Len(t, expected, 2, c.name)
+		}
+		assert.Equal(t, expected, kairos.bodies, c.name)
+	}
+}
+
+func TestServeHTTPExemplars(t *testing.T) {
+	v2, err := testRequest.MarshalV2()
+	if err != nil {
+		t.Fatalf("failed to marshal v2 request: %s", err)
+	}
+
+	kairos := newKairosDBRecorder()
+	defer kairos.Close()
+
+	w := post(kairos.serverWithExemplars(t, true), "application/x-protobuf;proto=io.prometheus.write.v2.Request", v2)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get(exemplarsWrittenHeader))
+	assert.Len(t, kairos.bodies, 3)
+	assert.JSONEq(t, `[{
+		"name": "http_requests_total_exemplar",
+		"timestamp": 1500,
+		"value": 1,
+		"tags": {"job": "api", "code": "200", "trace_id": "abc123"}
+	}]`, kairos.bodies[2])
+}
+
+func TestServeHTTPWrittenHeaders(t *testing.T) {
+	v2, err := testRequest.MarshalV2()
+	if err != nil {
+		t.Fatalf("failed to marshal v2 request: %s", err)
+	}
+
+	// rejects the first datapoint of every batch
+	kairos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errors": ["datapoint rejected"]}`))
+	}))
+	defer kairos.Close()
+	u, err := url.Parse(kairos.URL)
+	if err != nil {
+		t.Fatalf("failed to parse url: %s", err)
+	}
+
+	cases := []struct {
+		name    string
+		relabel []*config.RelabelConfig
+		samples string
+	}{
+		{
+			name:    "partially rejected",
+			samples: "1",
+		},
+		{
+			name: "dropped by relabeling",
+			relabel: []*config.RelabelConfig{
+				{SourceLabels: []model.LabelName{"code"}, Regex: config.MustNewRegexp("200"), Action: config.RelabelDrop},
+			},
+			samples: "0",
+		},
+	}
+
+	for _, c := range cases {
+		cfg := &config.Config{
+			KairosdbURL:          config.URL{URL: u},
+			Timeout:              time.Second,
+			MetricRelabelConfigs: c.relabel,
+			NativeHistograms:     config.NativeHistograms{Mode: config.HistogramModeKairosDB},
+		}
+		w := post(&Server{Client: *kairosdb.NewClient(cfg)}, "application/x-protobuf;proto=io.prometheus.write.v2.Request", v2)
+		assert.Equal(t, c.samples, w.Header().Get(samplesWrittenHeader), c.name)
+		assert.Equal(t, "0", w.Header().Get(histogramsWrittenHeader), c.name)
+	}
+}
+
+func TestServeHTTPHADedup(t *testing.T) {
+	replica := func(name string, marshal func(*remote.WriteRequest) ([]byte, error)) []byte {
+		req := &remote.WriteRequest{
+			Timeseries: []remote.TimeSeries{
+				{
+					Labels: []remote.Label{
+						{Name: "__name__", Value: "up"},
+						{Name: "cluster", Value: "eu"},
+						{Name: "replica", Value: name},
+					},
+					Samples: []remote.Sample{{Value: 1, Timestamp: 1000}},
+				},
+			},
+		}
+		buf, err := marshal(req)
+		if err != nil {
+			t.Fatalf("failed to marshal request: %s", err)
+		}
+		return buf
+	}
+
+	kairos := newKairosDBRecorder()
+	defer kairos.Close()
+
+	server := kairos.server(t)
+	server.HATracker = ha.NewTracker(config.HADedup{ClusterLabel: "cluster", ReplicaLabel: "replica", FailoverTimeout: time.Minute})
+
+	assert.Equal(t, http.StatusOK, post(server, "", replica("a", (*remote.WriteRequest).Marshal)).Code)
+	assert.Equal(t, http.StatusAccepted, post(server, "", replica("b", (*remote.WriteRequest).Marshal)).Code)
+	assert.Len(t, kairos.bodies, 1)
+	assert.JSONEq(t, `[{"name": "up", "timestamp": 1000, "value": 1, "tags": {"cluster": "eu"}}]`, kairos.bodies[0])
+
+	// remote write 2.0 senders are told nothing was written
+	w := post(server, "application/x-protobuf;proto=io.prometheus.write.v2.Request", replica("b", (*remote.WriteRequest).MarshalV2))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "0", w.Header().Get(samplesWrittenHeader))
+	assert.Equal(t, "0", w.Header().Get(histogramsWrittenHeader))
+	assert.Equal(t, "0", w.Header().Get(exemplarsWrittenHeader))
+	assert.Len(t, kairos.bodies, 1)
+}
+
+func TestServeCardinality(t *testing.T) {
+	kairos := newKairosDBRecorder()
+	defer kairos.Close()
+
+	u, _ := url.Parse(kairos.URL)
+	cfg := &config.Config{
+		KairosdbURL:       config.URL{URL: u},
+		Timeout:           time.Second,
+		CardinalityLimits: config.CardinalityLimits{Enabled: true, MaxSeries: 10, MaxSeriesPerMetric: 1, SeriesTTL: time.Minute, TopOffenders: 10},
+	}
+	server := &Server{Client: *kairosdb.NewClient(cfg)}
+
+	req := remote.WriteRequest{
+		Timeseries: []remote.TimeSeries{
+			{
+				Labels:  []remote.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+				Samples: []remote.Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []remote.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "500"}},
+				Samples: []remote.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	}
+	buf, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err)
+	}
+	assert.Equal(t, http.StatusOK, post(server, "", buf).Code)
+	assert.Len(t, kairos.bodies, 1)
+
+	w := httptest.NewRecorder()
+	server.ServeCardinality(w, httptest.NewRequest("GET", "/debug/cardinality?n=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"active_series": 1,
+		"max_series": 10,
+		"top_offenders": [{"metric": "http_requests_total", "active_series": 1, "max_series": 1, "limited_samples": 1}]
+	}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	server.ServeCardinality(w, httptest.NewRequest("GET", "/debug/cardinality?n=x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	kairos.server(t).ServeCardinality(w, httptest.NewRequest("GET", "/debug/cardinality", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServeAnalytics(t *testing.T) {
+	kairos := newKairosDBRecorder()
+	defer kairos.Close()
+
+	u, _ := url.Parse(kairos.URL)
+	cfg := &config.Config{
+		KairosdbURL: config.URL{URL: u},
+		Timeout:     time.Second,
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("code"), Action: config.RelabelLabelDrop},
+		},
+		Analytics: config.Analytics{Enabled: true, Window: time.Minute, Buckets: 1, Precision: 10, MaxKeys: 100, TopN: 10},
+	}
+	server := &Server{Client: *kairosdb.NewClient(cfg)}
+
+	req := remote.WriteRequest{
+		Timeseries: []remote.TimeSeries{
+			{
+				Labels:  []remote.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "200"}},
+				Samples: []remote.Sample{{Value: 1, Timestamp: 1000}},
+			},
+			{
+				Labels:  []remote.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "code", Value: "500"}},
+				Samples: []remote.Sample{{Value: 1, Timestamp: 1000}},
+			},
+		},
+	}
+	buf, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err)
+	}
+	assert.Equal(t, http.StatusOK, post(server, "", buf).Code)
+
+	series := func(view string) int64 {
+		w := httptest.NewRecorder()
+		server.ServeAnalytics(w, httptest.NewRequest("GET", "/debug/analytics?view="+view, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var reports []struct {
+			Series int64 `json:"series"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil {
+			t.Fatalf("failed to unmarshal report: %s", err)
+		}
+		return reports[0].Series
+	}
+	assert.Equal(t, int64(2), series("pre-relabel"))
+	assert.Equal(t, int64(1), series("post-relabel"))
+
+	w := httptest.NewRecorder()
+	server.ServeAnalytics(w, httptest.NewRequest("GET", "/debug/analytics?view=x", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	kairos.server(t).ServeAnalytics(w, httptest.NewRequest("GET", "/debug/analytics", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServeRelabelStatus(t *testing.T) {
+	kairos := newKairosDBRecorder()
+	defer kairos.Close()
+
+	u, _ := url.Parse(kairos.URL)
+	cfg := &config.Config{
+		KairosdbURL: config.URL{URL: u},
+		Timeout:     time.Second,
+		MetricRelabelConfigs: []*config.RelabelConfig{
+			{Regex: config.MustNewRegexp("code"), Action: config.RelabelLabelDrop},
+		},
+	}
+	server := &Server{Client: *kairosdb.NewClient(cfg)}
+
+	v1, err := testRequest.Marshal()
+	if err != nil {
+		t.Fatalf("failed to marshal request: %s", err)
+	}
+	assert.Equal(t, http.StatusOK, post(server, "", v1).Code)
+
+	w := httptest.NewRecorder()
+	server.ServeRelabelStatus(w, httptest.NewRequest("GET", "/status/relabel", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<pre>regex: code\naction: labeldrop\nprefix: &#34;&#34;\n</pre>")
+	// the series of both samples is counted once
+	assert.Contains(t, w.Body.String(), "<td>1</td>\n<td>0</td>\n<td>1</td>")
+}